@@ -17,16 +17,32 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/vaefremov/pnglic/config"
 	"github.com/vaefremov/pnglic/pkg/chkexprd"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/leader"
 	sw "github.com/vaefremov/pnglic/pkg/openapi"
+	"github.com/vaefremov/pnglic/pkg/outbox"
+	"github.com/vaefremov/pnglic/pkg/rules"
+	"github.com/vaefremov/pnglic/pkg/scheduler"
+	"github.com/vaefremov/pnglic/pkg/webhook"
 )
 
 var configPath = flag.String("c", "./pnglic_config.yaml", "Path to config file")
 var writeConfigToFile = flag.Bool("x", false, "Generate config file filled with default parameters")
 
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [notify]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Without a command the server is started, notify runs the expiry checks once and exits.\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+}
+
 func main() {
 	flag.Parse()
 	conf := config.NewConfig(*configPath)
@@ -36,8 +52,46 @@ func main() {
 			log.Printf("Warning: %s", err.Error())
 		}
 	}
-	go chkexprd.RunExpiryNotifications(conf)
-	router := sw.NewRouter(conf)
+	db := dao.MustNewPool(conf.DSN)
+	worker, err := outbox.NewWorker(db, conf)
+	if err != nil {
+		log.Fatalf("mail transport: %s\n", err)
+	}
+	featureRules, err := rules.Load(conf.RulesFile)
+	if err != nil {
+		log.Fatalf("feature rules: %s\n", err)
+	}
+	hooks := webhook.New(db, conf)
+	sched, err := newScheduler(db, conf)
+	if err != nil {
+		log.Fatalf("scheduler: %s\n", err)
+	}
+
+	// Only the leader of the instances sharing the database runs background work
+	elector := newElector(db, conf)
+
+	switch flag.Arg(0) {
+	case "":
+	case "notify":
+		os.Exit(notify(elector, sched, worker, hooks))
+	default:
+		log.Fatalf("unknown command %s\n", flag.Arg(0))
+	}
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	workersDone := make(chan struct{})
+	go func() {
+		elector.Run(workersCtx, func(ctx context.Context) {
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() { defer wg.Done(); worker.Run(ctx) }()
+			go func() { defer wg.Done(); hooks.Run(ctx) }()
+			sched.Run(ctx)
+			wg.Wait()
+		})
+		close(workersDone)
+	}()
+	router := sw.NewRouter(conf, db, sched, elector, featureRules)
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", conf.Port),
@@ -53,8 +107,8 @@ func main() {
 
 	// Wait for interrupt signal to gracefully shutdown the server with
 	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutdown Server ...")
 
@@ -63,6 +117,94 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server Shutdown:", err)
 	}
+	stopWorkers()
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		log.Println("Background jobs have not finished in time")
+	}
 	log.Println("Server exiting")
 	// log.Fatal(router.Run(fmt.Sprintf(":%d", conf.Port)))
 }
+
+// newScheduler makes the scheduler of periodic jobs
+func newScheduler(db *dao.DbConn, conf *config.Config) (*scheduler.Scheduler, error) {
+	loc, err := conf.Location()
+	if err != nil {
+		return nil, err
+	}
+	notifySchedule, err := scheduler.Parse(conf.NotifySchedule, loc)
+	if err != nil {
+		return nil, err
+	}
+	digestSchedule, err := scheduler.Parse(conf.DigestSchedule, loc)
+	if err != nil {
+		return nil, err
+	}
+	sched := scheduler.New()
+	err = sched.Add(chkexprd.ExpiryJob, notifySchedule, func(ctx context.Context) error {
+		return chkexprd.RunExpiryChecks(ctx, db, conf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = sched.Add(chkexprd.DigestJob, digestSchedule, func(ctx context.Context) error {
+		return chkexprd.SendDigest(ctx, db, outbox.NewNotifyer(db, conf), conf)
+	})
+	return sched, err
+}
+
+// backgroundLease is the lease held by the instance running background work
+const backgroundLease = "background"
+
+func newElector(db *dao.DbConn, conf *config.Config) *leader.Elector {
+	elector := leader.New(db, backgroundLease)
+	if conf.LeaseTTL > 0 {
+		elector.TTL = time.Duration(conf.LeaseTTL) * time.Second
+	}
+	return elector
+}
+
+// notify runs the expiry checks once and delivers the resulting mail and webhook
+// events, the exit code is returned. Nothing is done if a server instance is the
+// leader, the checks are run by it. The lease is renewed while the checks run, the
+// remaining steps are skipped if it is lost.
+func notify(elector *leader.Elector, sched *scheduler.Scheduler, worker *outbox.Worker, hooks *webhook.Dispatcher) (code int) {
+	ok, err := elector.RunOnce(context.Background(), func(ctx context.Context) {
+		if err := sched.RunNow(ctx, chkexprd.ExpiryJob); err != nil {
+			code = 1
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		now := time.Now()
+		if sent, err := worker.RunOnce(now); err != nil {
+			log.Println("Error when sending mail: ", err)
+			code = 1
+		} else {
+			log.Printf("Messages sent: %d", sent)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if delivered, err := hooks.RunOnce(now); err != nil {
+			log.Println("Error when delivering webhooks: ", err)
+			code = 1
+		} else {
+			log.Printf("Webhook events delivered: %d", delivered)
+		}
+	})
+	switch {
+	case err == leader.ErrLeaseLost:
+		log.Println("The lease has been lost, the checks have been interrupted")
+		return 1
+	case err != nil && !ok:
+		log.Println("Error when acquiring the lease: ", err)
+		return 1
+	case err != nil:
+		log.Println("Error when releasing the lease: ", err)
+	case !ok:
+		log.Println("Another instance is the leader, it runs the checks")
+	}
+	return
+}
@@ -5,31 +5,68 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	Port                 int    `yaml:"port"`
-	PublicName           string `yaml:"serverPublicName"`
-	DSN                  string `yaml:"dsn"`
-	LicfileEncoderLegacy string `yaml:"encoderOld"`
-	LicfileEncoderV3     string `yaml:"encoderV3"`
-	SecretsHasp          string `yaml:"secretsHASP"`
-	SecretsGuardant      string `yaml:"secretsGuardant"`
-	StaticContent        string `yaml:"static"`
-	AdminName            string `yaml:"adminName"`
-	AdminPass            string `yaml:"adminPass"`
-	AdminMail            string `yaml:"adminMail"`
-	MailServer           string `yaml:"mailServer"`
-	MailPort             int    `yaml:"mailPort"`
-	MailUser             string `yaml:"mailUser"`
-	MailPass             string `yaml:"mailPass"`
-	BackMail             string `yaml:"backMail"`
-	DaysToExpire1        int    `yaml:"daysToExpire1"`
-	DaysToExpire2        int    `yaml:"daysToExpire2"`
+	Port                   int            `yaml:"port"`
+	PublicName             string         `yaml:"serverPublicName"`
+	DSN                    string         `yaml:"dsn"`
+	LicfileEncoderLegacy   string         `yaml:"encoderOld"`
+	LicfileEncoderV3       string         `yaml:"encoderV3"`
+	SecretsHasp            string         `yaml:"secretsHASP"`
+	SecretsGuardant        string         `yaml:"secretsGuardant"`
+	StaticContent          string         `yaml:"static"`
+	AdminName              string         `yaml:"adminName"`
+	AdminPass              string         `yaml:"adminPass"`
+	AdminMail              string         `yaml:"adminMail"`
+	MailServer             string         `yaml:"mailServer"`
+	MailPort               int            `yaml:"mailPort"`
+	MailUser               string         `yaml:"mailUser"`
+	MailPass               string         `yaml:"mailPass"`
+	MailFrom               string         `yaml:"mailFrom"`
+	MailFromName           string         `yaml:"mailFromName"`
+	MailTransport          string         `yaml:"mailTransport"`
+	MailCA                 string         `yaml:"mailCA"`
+	MailCert               string         `yaml:"mailCert"`
+	MailKey                string         `yaml:"mailKey"`
+	MailInsecureSkipVerify bool           `yaml:"mailInsecureSkipVerify"`
+	MailLang               string         `yaml:"mailLang"`
+	BackMail               string         `yaml:"backMail"`
+	MailMaxAttempts        int            `yaml:"mailMaxAttempts"`
+	DaysToExpire1          int            `yaml:"daysToExpire1"`
+	DaysToExpire2          int            `yaml:"daysToExpire2"`
+	ExpiryThresholds       []int          `yaml:"expiryThresholds"`
+	ExpiredReportDays      int            `yaml:"expiredReportDays"`
+	CustomerNoticeDays     int            `yaml:"customerNoticeDays"`
+	NotifySchedule         string         `yaml:"notifySchedule"`
+	TimeZone               string         `yaml:"timeZone"`
+	DigestSchedule         string         `yaml:"digestSchedule"`
+	DigestRecipients       []string       `yaml:"digestRecipients"`
+	LeaseTTL               int            `yaml:"leaseTTL"`
+	Webhooks               []Webhook      `yaml:"webhooks"`
+	WebhookMaxAttempts     int            `yaml:"webhookMaxAttempts"`
+	CalendarTokens         []string       `yaml:"calendarTokens"`
+	CalendarDays           int            `yaml:"calendarDays"`
+	RulesFile              string         `yaml:"rulesFile"`
+	MaxCounts              map[string]int `yaml:"maxCounts"`
 }
 
+// Webhook is an URL license events are posted to. Requests are signed with
+// the secret (HMAC-SHA256), Events lists the events the hook is subscribed to,
+// all events are posted if the list is empty.
+type Webhook struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events"`
+}
+
+// DefaultMailFromName is the display name of the sender unless mailFromName is set
+const DefaultMailFromName = "Pangea License Generator"
+
 const (
 	defaultPort               = 9995
 	defaultDsn                = "/Users/efremov/Projects/LIC/PNGLicenseManager/Backend/licset.sqlite"
@@ -41,8 +78,18 @@ const (
 	defaultAdminPass          = "admin"
 	defaultAdminMail          = "admin@pangea.ru"
 	defaultMailPort           = 25
+	defaultMailTransport      = "auto"
+	defaultMailLang           = "en"
+	defaultNotifySchedule     = "09:00"
+	defaultDigestSchedule     = "0 8 * * 1"
+	defaultLeaseTTL           = 30
+	defaultWebhookMaxAttempts = 10
+	defaultCalendarDays       = 90
+	defaultMailMaxAttempts    = 10
 	defaultDaysToExpire1      = 7
 	defaultDaysToExpire2      = 1
+	defaultExpiredReportDays  = 30
+	defaultCustomerNoticeDays = 7
 	defaultBackMail           = ""
 	defaultPublicName         = "localhost"
 )
@@ -94,9 +141,90 @@ func (c Config) Report() {
 	fmt.Printf("  Admin mail is:         %s\n", c.AdminMail)
 	fmt.Printf("  Mail server:           %s:%d\n", c.MailServer, c.MailPort)
 	fmt.Printf("  Mail user:             %s\n", c.MailUser)
+	fmt.Printf("  Mail from:             %s <%s>\n", c.MailSenderName(), c.MailSender())
+	fmt.Printf("  Mail transport:        %s\n", c.MailTransport)
+	fmt.Printf("  Mail language:         %s\n", c.MailLang)
+	if c.MailInsecureSkipVerify {
+		fmt.Printf("  Warning: mail server certificate is not verified\n")
+	}
 	fmt.Printf("  Back mail:             %s\n", c.BackMail)
+	fmt.Printf("  Mail max attempts:     %d\n", c.MailMaxAttempts)
 	fmt.Printf("  Exp. Term 1:           %d\n", c.DaysToExpire1)
 	fmt.Printf("  Exp. Term 2:           %d\n", c.DaysToExpire2)
+	fmt.Printf("  Exp. thresholds:       %v\n", c.Thresholds())
+	fmt.Printf("  Expired report days:   %d\n", c.ExpiredReportDays)
+	fmt.Printf("  Customer notice days:  %d\n", c.CustomerNoticeDays)
+	fmt.Printf("  Notify schedule:       %s\n", c.NotifySchedule)
+	fmt.Printf("  Time zone:             %s\n", c.TimeZone)
+	fmt.Printf("  Digest schedule:       %s\n", c.DigestSchedule)
+	fmt.Printf("  Digest recipients:     %v\n", c.DigestRecipients)
+	fmt.Printf("  Leader lease TTL:      %ds\n", c.LeaseTTL)
+	for _, h := range c.Webhooks {
+		fmt.Printf("  Webhook:               %s %v\n", h.URL, h.Events)
+	}
+	fmt.Printf("  Calendar tokens:       %d\n", len(c.CalendarTokens))
+	fmt.Printf("  Calendar days:         %d\n", c.CalendarDays)
+	if c.RulesFile != "" {
+		fmt.Printf("  Feature rules file:    %s\n", c.RulesFile)
+	} else {
+		fmt.Printf("  Feature rules:         built-in\n")
+	}
+	if len(c.MaxCounts) > 0 {
+		fmt.Printf("  Max feature counts:    %v\n", c.MaxCounts)
+	}
+}
+
+// MailSenderName returns the display name of the sender, the default one unless
+// MailFromName is set
+func (c Config) MailSenderName() string {
+	if c.MailFromName != "" {
+		return c.MailFromName
+	}
+	return DefaultMailFromName
+}
+
+// MailSender returns the address mail is sent from, MailUser unless MailFrom is set
+func (c Config) MailSender() string {
+	if c.MailFrom != "" {
+		return c.MailFrom
+	}
+	return c.MailUser
+}
+
+// MaxCount returns the maximum count the feature may be licensed with, the limit set
+// for the feature in MaxCounts or the one set for "*". Zero means there is no limit.
+func (c Config) MaxCount(feature string) int {
+	if max, ok := c.MaxCounts[feature]; ok {
+		return max
+	}
+	return c.MaxCounts["*"]
+}
+
+// Location returns the time zone schedules are set in, the local one unless TimeZone is set
+func (c Config) Location() (*time.Location, error) {
+	if c.TimeZone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.TimeZone)
+}
+
+// Thresholds returns the list of days before expiry when warnings are sent,
+// in ascending order. DaysToExpire1 and DaysToExpire2 are used unless the
+// ExpiryThresholds list is set.
+func (c Config) Thresholds() (res []int) {
+	tmp := c.ExpiryThresholds
+	if len(tmp) == 0 {
+		tmp = []int{c.DaysToExpire1, c.DaysToExpire2}
+	}
+	seen := map[int]bool{}
+	for _, d := range tmp {
+		if d > 0 && !seen[d] {
+			seen[d] = true
+			res = append(res, d)
+		}
+	}
+	sort.Ints(res)
+	return
 }
 
 func (c Config) Write(configPath string) (err error) {
@@ -163,8 +291,19 @@ func (c *Config) InsertDefaults() {
 	c.AdminPass = defaultAdminPass
 	c.AdminMail = defaultAdminMail
 	c.MailPort = defaultMailPort
+	c.MailTransport = defaultMailTransport
+	c.MailFromName = DefaultMailFromName
+	c.MailLang = defaultMailLang
+	c.NotifySchedule = defaultNotifySchedule
+	c.DigestSchedule = defaultDigestSchedule
+	c.LeaseTTL = defaultLeaseTTL
+	c.WebhookMaxAttempts = defaultWebhookMaxAttempts
+	c.CalendarDays = defaultCalendarDays
+	c.MailMaxAttempts = defaultMailMaxAttempts
 	c.DaysToExpire1 = defaultDaysToExpire1
 	c.DaysToExpire2 = defaultDaysToExpire2
+	c.ExpiredReportDays = defaultExpiredReportDays
+	c.CustomerNoticeDays = defaultCustomerNoticeDays
 	c.BackMail = defaultBackMail
 	c.PublicName = defaultPublicName
 }
@@ -0,0 +1,72 @@
+package chkexprd
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// ExpiryEvent lists the features of the key ending on the same date
+type ExpiryEvent struct {
+	KeyID      string
+	ClientID   int
+	ClientName string
+	Manager    string
+	End        time.Time
+	Features   []string
+}
+
+// FindExpiryEvents returns one event per key and expiry date for the features
+// that will expire within the given interval, the nearest first. The data are
+// the same as of FindFeaturesWillExpire, retired keys are skipped.
+func FindExpiryEvents(db *dao.DbConn, expTerm time.Duration) ([]ExpiryEvent, error) {
+	features, err := db.WillEndSoon(expTerm)
+	if err != nil {
+		return nil, err
+	}
+	retired, err := retiredKeys(db)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := db.AllClientSettings()
+	if err != nil {
+		return nil, err
+	}
+	clients := map[string]dao.Organization{}
+	events := map[string]*ExpiryEvent{}
+	res := []*ExpiryEvent{}
+	for _, f := range features {
+		if retired[f.KeyID] {
+			continue
+		}
+		id := f.KeyID + "/" + f.ExpTime.Format("2006-01-02")
+		if e, ok := events[id]; ok {
+			e.Features = append(e.Features, f.Feature)
+			continue
+		}
+		cl, ok := clients[f.KeyID]
+		if !ok {
+			if cl, err = db.KeyOfWhichOrg(f.KeyID); err != nil {
+				return nil, err
+			}
+			clients[f.KeyID] = cl
+		}
+		e := &ExpiryEvent{KeyID: f.KeyID, ClientID: cl.Id, ClientName: cl.Name, Manager: settings.Get(cl.Id).Manager,
+			End: f.ExpTime, Features: []string{f.Feature}}
+		events[id] = e
+		res = append(res, e)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if !res[i].End.Equal(res[j].End) {
+			return res[i].End.Before(res[j].End)
+		}
+		return res[i].KeyID < res[j].KeyID
+	})
+	out := make([]ExpiryEvent, len(res))
+	for i, e := range res {
+		sort.Strings(e.Features)
+		out[i] = *e
+	}
+	return out, nil
+}
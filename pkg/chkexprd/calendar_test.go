@@ -0,0 +1,58 @@
+package chkexprd_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/chkexprd"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+func TestFindExpiryEvents(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	keyID := "123abc"
+	licSet, err := db.LicensesSetByKeyId(keyID)
+	assert.Nil(t, err)
+	// The first feature ends tomorrow, the other ones in 5 days
+	for i := range licSet {
+		licSet[i].End = time.Now().AddDate(0, 0, 5)
+		if i == 0 {
+			licSet[i].End = time.Now().AddDate(0, 0, 1)
+		}
+	}
+	assert.Nil(t, db.UpdateLicenseSet(keyID, licSet))
+	assert.Nil(t, db.SetClientSettings(dao.ClientSettings{OrgId: 1, Manager: "ivanov@pangea.ru"}))
+
+	events, err := chkexprd.FindExpiryEvents(db, 10*24*time.Hour)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(events)) {
+		assert.Equal(t, keyID, events[0].KeyID)
+		assert.Equal(t, 1, events[0].ClientID)
+		assert.Equal(t, "Org 1", events[0].ClientName)
+		assert.Equal(t, "ivanov@pangea.ru", events[0].Manager)
+		assert.Equal(t, 1, len(events[0].Features))
+		assert.Equal(t, len(licSet)-1, len(events[1].Features))
+		assert.True(t, events[0].End.Before(events[1].End))
+	}
+	events, err = chkexprd.FindExpiryEvents(db, 3*24*time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+}
+
+func TestFindExpiryEventsRetiredKey(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	licSet := []dao.LicenseSetItem{{KeyID: "123abc", Feature: "F1", Version: 19.0, Count: 1, Start: time.Now().AddDate(-1, 0, 0), End: time.Now().AddDate(0, 0, 5)}}
+	assert.Nil(t, db.UpdateLicenseSet("123abc", licSet))
+	revision, _ := db.LicenseSetRevision("123abc")
+	_, err := db.ReplaceKey("123abc", "123dbc", "spare", "broken", revision, time.Now())
+	assert.Nil(t, err)
+	// Features written to the retired key after the replacement are not reported
+	assert.Nil(t, db.UpdateLicenseSet("123abc", licSet))
+
+	events, err := chkexprd.FindExpiryEvents(db, 10*24*time.Hour)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(events)) {
+		assert.Equal(t, "123dbc", events[0].KeyID)
+	}
+}
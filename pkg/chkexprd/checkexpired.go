@@ -1,34 +1,127 @@
 package chkexprd
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"time"
 
+	"github.com/vaefremov/pnglic/config"
 	"github.com/vaefremov/pnglic/pkg/dao"
 	"github.com/vaefremov/pnglic/pkg/mailnotify"
-	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/outbox"
+	"github.com/vaefremov/pnglic/pkg/webhook"
 )
 
-func RunExpiryNotifications(conf *config.Config) {
-	db := dao.MustNewPool(conf.DSN)
-	notifyer := mailnotify.New(conf.MailServer, conf.MailPort, conf.MailUser, conf.MailPass).AddTo(conf.AdminMail).AddTo(conf.BackMail)
-	ticker := time.NewTicker(24 * time.Hour)
-	expTerm1 := time.Duration(time.Duration(24) * time.Duration(conf.DaysToExpire1) * time.Hour)
+// ExpiryJob is the name of the scheduler job running RunExpiryChecks
+const ExpiryJob = "expiry"
 
-	for {
-		features, err := FindFeaturesWillExpire(db, expTerm1)
-		if err != nil {
-			panic(err)
+// RunExpiryChecks makes all the expiry checks once: warnings and the digest of
+// lapsed features to admins, notices to customers and events to webhooks. A failed
+// check does not prevent the next ones, the first error is returned.
+func RunExpiryChecks(ctx context.Context, db *dao.DbConn, conf *config.Config) (err error) {
+	notifyer := outbox.NewNotifyer(db, conf).AddTo(conf.AdminMail).AddTo(conf.BackMail)
+	// Customers must not see the back office address, it gets a blind copy
+	newCustomerNotifyer := func() mailnotify.MailNotifyer {
+		return outbox.NewNotifyer(db, conf).AddBcc(conf.BackMail)
+	}
+	hooks := webhook.New(db, conf)
+	checks := []struct {
+		name string
+		run  func() error
+	}{
+		{"expiring features", func() error { return NotifyExpiring(db, notifyer, conf) }},
+		{"lapsed features", func() error { return NotifyLapsed(db, notifyer, conf) }},
+		{"customer notices", func() error { return NotifyCustomers(db, newCustomerNotifyer, conf) }},
+		{"webhook events", func() error {
+			if !hooks.Enabled() {
+				return nil
+			}
+			return EmitExpiryEvents(db, hooks, conf)
+		}},
+	}
+	for _, check := range checks {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-		if len(features) > 0 {
-			err = ReportFeaturesWillExpire(features, expTerm1, notifyer, conf)
-			if err != nil {
-				panic(err)
+		if checkErr := check.run(); checkErr != nil {
+			log.Printf("Error when checking %s: %s", check.name, checkErr)
+			if err == nil {
+				err = checkErr
 			}
 		}
-		<-ticker.C
 	}
+	return
+}
+
+// NotifyExpiring sends a warning for every expiry threshold that has been reached
+// by some features. Each key/feature/threshold is reported only once, the fact
+// of reporting is stored in the notifications log.
+func NotifyExpiring(db *dao.DbConn, nt mailnotify.MailNotifyer, conf *config.Config) error {
+	thresholds := conf.Thresholds()
+	if len(thresholds) == 0 {
+		return nil
+	}
+	maxTerm := daysToDuration(thresholds[len(thresholds)-1])
+	log.Println("Checking if there are features going to expire in ", maxTerm)
+	features, err := db.WillEndSoon(maxTerm)
+	if err != nil {
+		return err
+	}
+	byThreshold := map[int][]dao.KeyFeatureExpiry{}
+	for _, f := range features {
+		threshold := thresholdFor(f.ExpTerm, thresholds)
+		notified, err := db.IsNotified(dao.NotificationExpiry, f, threshold)
+		if err != nil {
+			return err
+		}
+		if !notified {
+			byThreshold[threshold] = append(byThreshold[threshold], f)
+		}
+	}
+	for _, threshold := range thresholds {
+		toReport, ok := byThreshold[threshold]
+		if !ok {
+			continue
+		}
+		report, err := groupByKey(db, toReport)
+		if err != nil {
+			return err
+		}
+		if err = ReportFeaturesWillExpire(report, daysToDuration(threshold), nt, conf); err != nil {
+			return err
+		}
+		if err = db.MarkNotified(dao.NotificationExpiry, toReport, threshold, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// thresholdFor returns the smallest of thresholds (in days) the expTerm fits in
+func thresholdFor(expTerm time.Duration, thresholds []int) int {
+	for _, t := range thresholds {
+		if expTerm <= daysToDuration(t) {
+			return t
+		}
+	}
+	return thresholds[len(thresholds)-1]
+}
+
+func daysToDuration(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// retiredKeys returns the IDs of the keys replaced by other ones
+func retiredKeys(db *dao.DbConn) (map[string]bool, error) {
+	replacements, err := db.KeyReplacements(0)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]bool{}
+	for _, r := range replacements {
+		res[r.KeyID] = true
+	}
+	return res, nil
 }
 
 type ExpFeaturesReportElt struct {
@@ -40,11 +133,16 @@ type ExpFeaturesReportElt struct {
 
 func FindFeaturesWillExpire(db *dao.DbConn, expTerm time.Duration) (map[string]ExpFeaturesReportElt, error) {
 	log.Println("Checking if there are features going to expire in ", expTerm)
-	res := map[string]ExpFeaturesReportElt{}
 	features, err := db.WillEndSoon(expTerm)
 	if err != nil {
-		return res, err
+		return map[string]ExpFeaturesReportElt{}, err
 	}
+	return groupByKey(db, features)
+}
+
+// groupByKey collects features into report elements, one per key
+func groupByKey(db *dao.DbConn, features []dao.KeyFeatureExpiry) (map[string]ExpFeaturesReportElt, error) {
+	res := map[string]ExpFeaturesReportElt{}
 	for _, f := range features {
 		if r, ok := res[f.KeyID]; !ok {
 			clFull, err := db.KeyOfWhichOrg(f.KeyID)
@@ -65,7 +163,9 @@ func FindFeaturesWillExpire(db *dao.DbConn, expTerm time.Duration) (map[string]E
 
 func ReportFeaturesWillExpire(features map[string]ExpFeaturesReportElt, expTerm time.Duration, nt mailnotify.MailNotifyer, conf *config.Config) error {
 	log.Println("Reporting features that will expire within ", expTerm.String())
-	message, _ := MakeMessageFromTemplate(features, expTerm, conf)
-	err := nt.SendMessage("Warning: some licenses will expire in "+fmt.Sprint(expTerm.Hours()/24)+" days", message)
-	return err
+	ml, err := MakeExpiringMail(features, expTerm, conf)
+	if err != nil {
+		return err
+	}
+	return nt.SendMail(ml)
 }
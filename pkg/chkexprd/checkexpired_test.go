@@ -1,6 +1,7 @@
 package chkexprd_test
 
 import (
+	"context"
 	"fmt"
 	"net/smtp"
 	"testing"
@@ -72,3 +73,61 @@ func TestFindFeaturesWillExpire(t *testing.T) {
 	assert.True(t, (24*time.Hour > tillDate1.Sub(res[keyID].ExpTime)) && (tillDate1.Sub(res[keyID].ExpTime) > 0))
 	// t.Error(res)
 }
+
+func TestNotifyExpiring(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	keyID := "123abc"
+	newLicset := []dao.LicenseSetItem{}
+	currentLicSet, err := db.LicensesSetByKeyId(keyID)
+	if err != nil {
+		t.Error(err)
+	}
+	for i, f := range currentLicSet {
+		switch i {
+		case 0:
+			f.End = time.Now().AddDate(0, 0, 1)
+		default:
+			f.End = time.Now().AddDate(0, 0, 5)
+		}
+		newLicset = append(newLicset, f)
+	}
+	db.UpdateLicenseSet(keyID, newLicset)
+
+	sent := 0
+	notifyer := mailnotify.New("mail.server", 25, "user@pangea.ru", "**pass**").AddTo("some.addressee")
+	m := notifyer.(*mailnotify.MailServiceImpl)
+	m.Send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent++
+		return nil
+	}
+	conf := config.Config{Port: 9995, PublicName: "some.host", ExpiryThresholds: []int{30, 7, 1}}
+	err = chkexprd.NotifyExpiring(db, m, &conf)
+	assert.Nil(t, err)
+	// One message for the 1 day threshold, another one for the 7 days threshold
+	assert.Equal(t, 2, sent)
+
+	notifications, err := db.NotificationsSince(time.Now().AddDate(0, 0, -1))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(notifications))
+
+	// Everything has been reported already
+	err = chkexprd.NotifyExpiring(db, m, &conf)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, sent)
+}
+
+func TestRunExpiryChecks(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	db.UpdateLicenseSet("123cbc", []dao.LicenseSetItem{{KeyID: "123cbc", Feature: "F1", Version: 19.0, Count: 1,
+		Start: time.Now().AddDate(-1, 0, 0), End: time.Now().AddDate(0, 0, 3)}})
+	conf := &config.Config{ExpiryThresholds: []int{7}, ExpiredReportDays: 30, CustomerNoticeDays: 7, AdminMail: "admin@pangea.ru"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, chkexprd.RunExpiryChecks(ctx, db, conf))
+
+	assert.Nil(t, chkexprd.RunExpiryChecks(context.Background(), db, conf))
+	// Mail goes to the outbox, it is sent by the outbox worker
+	items, err := db.OutboxItems(10)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, items)
+}
@@ -0,0 +1,100 @@
+package chkexprd
+
+import (
+	"log"
+	"time"
+
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+)
+
+// NotifyCustomers sends expiry notices to the contacts of clients that opted in.
+// Only contacts that want to receive expiry notices are addressed.
+// Each client gets a notice naming only its own keys and features, at most once
+// in conf.CustomerNoticeDays days. newNotifyer should return a notifier without
+// recipients (blind copies aside), contacts of the client are added to it.
+func NotifyCustomers(db *dao.DbConn, newNotifyer func() mailnotify.MailNotifyer, conf *config.Config) error {
+	thresholds := conf.Thresholds()
+	if len(thresholds) == 0 {
+		return nil
+	}
+	settings, err := db.AllClientSettings()
+	if err != nil {
+		return err
+	}
+	features, err := db.WillEndSoon(daysToDuration(thresholds[len(thresholds)-1]))
+	if err != nil {
+		return err
+	}
+	report, err := groupByKey(db, features)
+	if err != nil {
+		return err
+	}
+	// Split the report by clients
+	byClient := map[int]map[string]ExpFeaturesReportElt{}
+	clients := map[int]dao.Organization{}
+	for keyID, elt := range report {
+		cl, err := db.KeyOfWhichOrg(keyID)
+		if err != nil {
+			return err
+		}
+		if !settings.Get(cl.Id).NotifyCustomer {
+			continue
+		}
+		if _, ok := byClient[cl.Id]; !ok {
+			byClient[cl.Id] = map[string]ExpFeaturesReportElt{}
+			clients[cl.Id] = cl
+		}
+		byClient[cl.Id][keyID] = elt
+	}
+	now := time.Now()
+	for clientID, clientReport := range byClient {
+		last, err := db.LastCustomerNotice(clientID)
+		if err != nil {
+			return err
+		}
+		if now.Sub(last) < daysToDuration(conf.CustomerNoticeDays) {
+			continue
+		}
+		recipients, err := expiryRecipients(db, clientID)
+		if err != nil {
+			return err
+		}
+		if len(recipients) == 0 {
+			log.Println("No e-mail addresses in contacts of client", clientID, ", expiry notice skipped")
+			continue
+		}
+		nt := newNotifyer()
+		for _, addr := range recipients {
+			nt.AddTo(addr)
+		}
+		ml, err := MakeCustomerMail(clients[clientID].Name, settings.Get(clientID).Lang, clientReport, conf)
+		if err != nil {
+			return err
+		}
+		log.Println("Sending expiry notice to client", clientID, recipients)
+		if err = nt.SendMail(ml); err != nil {
+			return err
+		}
+		if err = db.AddCustomerNotice(clientID, now, recipients); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiryRecipients returns e-mails of the client contacts that want to receive expiry notices
+func expiryRecipients(db *dao.DbConn, clientID int) ([]string, error) {
+	res := []string{}
+	contacts, err := db.Contacts(clientID)
+	if err != nil {
+		return res, err
+	}
+	for _, c := range contacts {
+		if c.NotifyExpiry && c.Email != "" {
+			res = append(res, c.Email)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,64 @@
+package chkexprd_test
+
+import (
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/chkexprd"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+)
+
+func TestNotifyCustomers(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	db.CreateContact(dao.Contact{OrgId: 1, Name: "Ivanov", Email: "ivanov@org1.ru", Role: dao.RoleLicenseAdmin, NotifyExpiry: true})
+	db.CreateContact(dao.Contact{OrgId: 1, Name: "Sidorov", Email: "sidorov@org1.ru", Role: dao.RoleBilling})
+	db.CreateContact(dao.Contact{OrgId: 2, Name: "Petrov", Email: "petrov@org2.ru", Role: dao.RoleLicenseAdmin, NotifyExpiry: true})
+	for _, keyID := range []string{"123abc", "123bbc"} {
+		licSet, _ := db.LicensesSetByKeyId(keyID)
+		for i := range licSet {
+			licSet[i].End = time.Now().AddDate(0, 0, 3)
+		}
+		if err := db.UpdateLicenseSet(keyID, licSet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.UpdateLicenseSet("123cbc", []dao.LicenseSetItem{{KeyID: "123cbc", Feature: "F1", Version: 19.0, Count: 1,
+		Start: time.Now().AddDate(-1, 0, 0), End: time.Now().AddDate(0, 0, 3)}})
+	// Only Org 1 opted in
+	assert.Nil(t, db.SetClientSettings(dao.ClientSettings{OrgId: 1, NotifyCustomer: true}))
+
+	type sentMessage struct {
+		to   []string
+		body string
+	}
+	sent := []sentMessage{}
+	newNotifyer := func() mailnotify.MailNotifyer {
+		nt := mailnotify.New("mail.server", 25, "user@pangea.ru", "**pass**")
+		nt.(*mailnotify.MailServiceImpl).Send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			sent = append(sent, sentMessage{to: to, body: string(msg)})
+			return nil
+		}
+		return nt.AddBcc("back@pangea.ru")
+	}
+	conf := config.Config{ExpiryThresholds: []int{7}, CustomerNoticeDays: 7}
+	assert.Nil(t, chkexprd.NotifyCustomers(db, newNotifyer, &conf))
+	assert.Equal(t, 1, len(sent))
+	assert.Equal(t, []string{"ivanov@org1.ru", "back@pangea.ru"}, sent[0].to)
+	// The back office gets a blind copy
+	parsed, err := mail.ReadMessage(strings.NewReader(sent[0].body))
+	assert.Nil(t, err)
+	assert.Equal(t, "ivanov@org1.ru", parsed.Header.Get("To"))
+	assert.NotContains(t, sent[0].body, "back@pangea.ru")
+	assert.True(t, strings.Contains(sent[0].body, "123abc") && strings.Contains(sent[0].body, "123bbc"))
+	assert.False(t, strings.Contains(sent[0].body, "123cbc"))
+
+	// Notices are throttled
+	assert.Nil(t, chkexprd.NotifyCustomers(db, newNotifyer, &conf))
+	assert.Equal(t, 1, len(sent))
+}
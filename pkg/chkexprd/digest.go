@@ -0,0 +1,229 @@
+package chkexprd
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+	"github.com/vaefremov/pnglic/pkg/mailtmpl"
+)
+
+// DigestJob is the name of the scheduler job sending the weekly digest
+const DigestJob = "digest"
+
+// digestHorizons are the terms (in days) expiring features are grouped by in the digest
+var digestHorizons = []int{30, 60, 90}
+
+// digestPeriod is the period the digest reports issued files for
+const digestPeriod = 7 * 24 * time.Hour
+
+// Digest is the weekly summary of the licensing situation for management
+type Digest struct {
+	ServerPublicURL string
+	From            time.Time
+	To              time.Time
+	TotalKeys       int
+	Clients         []DigestClient
+	Expiring        []DigestHorizon
+	TotalIssued     int
+	Issued          []DigestClient
+	Lapsed          []DigestClient
+}
+
+// DigestClient is a line of the digest about a client. Count is the number of keys,
+// of issued files or of lapsed features depending on the section.
+type DigestClient struct {
+	ClientName string
+	Count      int
+	Keys       []string
+}
+
+// DigestHorizon lists features that expire in FromDays to Days days grouped by client
+type DigestHorizon struct {
+	FromDays int
+	Days     int
+	Clients  []DigestExpiring
+}
+
+// DigestExpiring is the list of features of the client that expire within the horizon
+type DigestExpiring struct {
+	ClientName string
+	Features   []DigestFeature
+}
+
+// DigestFeature is a feature of the key expiring on End
+type DigestFeature struct {
+	KeyID   string
+	Feature string
+	End     string
+}
+
+// BuildDigest collects the data of the digest for the week before now. Retired keys
+// are not reported.
+func BuildDigest(db *dao.DbConn, now time.Time, conf *config.Config) (res Digest, err error) {
+	res = Digest{ServerPublicURL: serverPublicURL(conf), From: now.Add(-digestPeriod), To: now}
+	retired, err := retiredKeys(db)
+	if err != nil {
+		return
+	}
+	if res.Clients, res.TotalKeys, err = digestKeys(db, retired); err != nil {
+		return
+	}
+	if res.Expiring, err = digestExpiring(db, retired); err != nil {
+		return
+	}
+	issues, err := db.IssuesSince(res.From)
+	if err != nil {
+		return
+	}
+	res.TotalIssued = len(issues)
+	issued := map[string]int{}
+	for _, h := range issues {
+		issued[h.ClientName]++
+	}
+	res.Issued = countsByClient(issued, nil)
+	lapsed, err := FindLapsedFeatures(db, conf)
+	if err != nil {
+		return
+	}
+	lapsedCount := map[string]int{}
+	lapsedKeys := map[string][]string{}
+	for _, f := range lapsed {
+		if retired[f.KeyID] {
+			continue
+		}
+		lapsedCount[f.ClientName]++
+		lapsedKeys[f.ClientName] = appendUnique(lapsedKeys[f.ClientName], f.KeyID)
+	}
+	res.Lapsed = countsByClient(lapsedCount, lapsedKeys)
+	return
+}
+
+func digestKeys(db *dao.DbConn, retired map[string]bool) (res []DigestClient, total int, err error) {
+	clients, err := db.Clients()
+	if err != nil {
+		return
+	}
+	keys, err := db.Keys()
+	if err != nil {
+		return
+	}
+	names := map[int]string{}
+	for _, cl := range clients {
+		names[cl.Id] = cl.Name
+	}
+	counts := map[string]int{}
+	for _, k := range keys {
+		if retired[k.Id] {
+			continue
+		}
+		counts[names[k.OrgId]]++
+		total++
+	}
+	return countsByClient(counts, nil), total, nil
+}
+
+func digestExpiring(db *dao.DbConn, retired map[string]bool) (res []DigestHorizon, err error) {
+	features, err := db.WillEndSoon(daysToDuration(digestHorizons[len(digestHorizons)-1]))
+	if err != nil {
+		return
+	}
+	clients := map[string]string{}
+	byHorizon := make([]map[string][]DigestFeature, len(digestHorizons))
+	for i := range byHorizon {
+		byHorizon[i] = map[string][]DigestFeature{}
+	}
+	for _, f := range features {
+		if retired[f.KeyID] {
+			continue
+		}
+		clientName, ok := clients[f.KeyID]
+		if !ok {
+			cl, err := db.KeyOfWhichOrg(f.KeyID)
+			if err != nil {
+				return nil, err
+			}
+			clientName = cl.Name
+			clients[f.KeyID] = clientName
+		}
+		i := 0
+		for i < len(digestHorizons)-1 && f.ExpTerm > daysToDuration(digestHorizons[i]) {
+			i++
+		}
+		byHorizon[i][clientName] = append(byHorizon[i][clientName],
+			DigestFeature{KeyID: f.KeyID, Feature: f.Feature, End: f.ExpTime.Format("2006-01-02")})
+	}
+	fromDays := 0
+	for i, days := range digestHorizons {
+		h := DigestHorizon{FromDays: fromDays, Days: days, Clients: []DigestExpiring{}}
+		for name, features := range byHorizon[i] {
+			sort.Slice(features, func(a, b int) bool {
+				if features[a].End != features[b].End {
+					return features[a].End < features[b].End
+				}
+				if features[a].KeyID != features[b].KeyID {
+					return features[a].KeyID < features[b].KeyID
+				}
+				return features[a].Feature < features[b].Feature
+			})
+			h.Clients = append(h.Clients, DigestExpiring{ClientName: name, Features: features})
+		}
+		sort.Slice(h.Clients, func(a, b int) bool { return h.Clients[a].ClientName < h.Clients[b].ClientName })
+		res = append(res, h)
+		fromDays = days + 1
+	}
+	return
+}
+
+// countsByClient makes digest lines ordered by client name
+func countsByClient(counts map[string]int, keys map[string][]string) []DigestClient {
+	res := []DigestClient{}
+	for name, n := range counts {
+		clientKeys := keys[name]
+		sort.Strings(clientKeys)
+		res = append(res, DigestClient{ClientName: name, Count: n, Keys: clientKeys})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ClientName < res[j].ClientName })
+	return res
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// MakeDigestMail makes the weekly digest rendered in conf.MailLang
+func MakeDigestMail(digest Digest, conf *config.Config) (mailnotify.Mail, error) {
+	return mailtmpl.New(conf.StaticContent).Render(mailtmpl.Digest, conf.MailLang, digest)
+}
+
+// SendDigest sends the weekly digest to conf.DigestRecipients
+func SendDigest(ctx context.Context, db *dao.DbConn, nt mailnotify.MailNotifyer, conf *config.Config) error {
+	if len(conf.DigestRecipients) == 0 {
+		log.Println("No digest recipients configured, the digest is not sent")
+		return nil
+	}
+	digest, err := BuildDigest(db, time.Now(), conf)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	ml, err := MakeDigestMail(digest, conf)
+	if err != nil {
+		return err
+	}
+	for _, to := range conf.DigestRecipients {
+		nt = nt.AddTo(to)
+	}
+	return nt.SendMail(ml)
+}
@@ -0,0 +1,111 @@
+package chkexprd_test
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/chkexprd"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+)
+
+func digestTestDB(t *testing.T) *dao.DbConn {
+	db := dao.MustInMemoryTestPool()
+	start := time.Now().AddDate(-1, 0, 0)
+	sets := map[string][]dao.LicenseSetItem{
+		"123abc": {{KeyID: "123abc", Feature: "F3", Version: 19.0, Count: 1, Start: start, End: time.Now().AddDate(0, 0, 10)},
+			{KeyID: "123abc", Feature: "P1", Version: 19.0, Count: 1, Start: start, End: time.Now().AddDate(0, 0, 45)}},
+		"123bbc": {{KeyID: "123bbc", Feature: "F1", Version: 19.0, Count: 1, Start: start, End: time.Now().AddDate(0, 0, -20)}},
+		"123cbc": {{KeyID: "123cbc", Feature: "F1", Version: 19.0, Count: 1, Start: start, End: time.Now().AddDate(0, 0, 80)}},
+	}
+	for keyID, licSet := range sets {
+		if err := db.UpdateLicenseSet(keyID, licSet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Nil(t, db.AddToHistory(2, time.Now().Add(-time.Hour), "<license/>"))
+	assert.Nil(t, db.AddToHistory(2, time.Now().AddDate(0, 0, -10), "<license/>"))
+	return db
+}
+
+func TestBuildDigest(t *testing.T) {
+	db := digestTestDB(t)
+	now := time.Now()
+	digest, err := chkexprd.BuildDigest(db, now, &config.Config{ExpiredReportDays: 30})
+	assert.Nil(t, err)
+	assert.Equal(t, now.AddDate(0, 0, -7).Format("2006-01-02"), digest.From.Format("2006-01-02"))
+
+	assert.Equal(t, 3, digest.TotalKeys)
+	assert.Equal(t, []chkexprd.DigestClient{{ClientName: "Org 1", Count: 2}, {ClientName: "Org 2", Count: 1}}, digest.Clients)
+
+	assert.Equal(t, 3, len(digest.Expiring))
+	assert.Equal(t, 0, digest.Expiring[0].FromDays)
+	assert.Equal(t, 30, digest.Expiring[0].Days)
+	assert.Equal(t, []chkexprd.DigestExpiring{{ClientName: "Org 1", Features: []chkexprd.DigestFeature{
+		{KeyID: "123abc", Feature: "F3", End: now.AddDate(0, 0, 10).Format("2006-01-02")}}}}, digest.Expiring[0].Clients)
+	assert.Equal(t, 31, digest.Expiring[1].FromDays)
+	assert.Equal(t, "P1", digest.Expiring[1].Clients[0].Features[0].Feature)
+	assert.Equal(t, "Org 2", digest.Expiring[2].Clients[0].ClientName)
+
+	// The test DB has two more files issued to Org 1 and Org 2 right now
+	assert.Equal(t, 3, digest.TotalIssued)
+	assert.Equal(t, []chkexprd.DigestClient{{ClientName: "Org 1", Count: 1}, {ClientName: "Org 2", Count: 2}}, digest.Issued)
+
+	assert.Equal(t, []chkexprd.DigestClient{{ClientName: "Org 1", Count: 1, Keys: []string{"123bbc"}}}, digest.Lapsed)
+}
+
+func TestBuildDigestRetiredKeys(t *testing.T) {
+	db := digestTestDB(t)
+	revision, _ := db.LicenseSetRevision("123bbc")
+	_, err := db.ReplaceKey("123bbc", "123dbc", "spare", "broken", revision, time.Now())
+	assert.Nil(t, err)
+	// Features left on the retired key are not reported
+	assert.Nil(t, db.UpdateLicenseSet("123abc", []dao.LicenseSetItem{{KeyID: "123abc", Feature: "F3", Version: 19.0, Count: 1,
+		Start: time.Now().AddDate(-1, 0, 0), End: time.Now().AddDate(0, 0, 10)}}))
+	revision, _ = db.LicenseSetRevision("123abc")
+	_, err = db.ReplaceKey("123abc", "123ebc", "spare", "lost", revision, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, db.UpdateLicenseSet("123abc", []dao.LicenseSetItem{{KeyID: "123abc", Feature: "F1", Version: 19.0, Count: 1,
+		Start: time.Now().AddDate(-1, 0, 0), End: time.Now().AddDate(0, 0, 5)}}))
+
+	digest, err := chkexprd.BuildDigest(db, time.Now(), &config.Config{ExpiredReportDays: 30})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, digest.TotalKeys)
+	assert.Equal(t, []chkexprd.DigestClient{{ClientName: "Org 1", Count: 2}, {ClientName: "Org 2", Count: 1}}, digest.Clients)
+	if assert.Equal(t, 1, len(digest.Expiring[0].Clients)) {
+		features := digest.Expiring[0].Clients[0].Features
+		if assert.Equal(t, 1, len(features)) {
+			assert.Equal(t, "123ebc", features[0].KeyID)
+		}
+	}
+	// The lapsed feature has been moved to the new key
+	assert.Equal(t, []chkexprd.DigestClient{{ClientName: "Org 1", Count: 1, Keys: []string{"123dbc"}}}, digest.Lapsed)
+}
+
+func TestSendDigest(t *testing.T) {
+	db := digestTestDB(t)
+	sent := []string{}
+	to := []string{}
+	nt := mailnotify.New("mail.server", 25, "user@pangea.ru", "**pass**")
+	nt.(*mailnotify.MailServiceImpl).Send = func(addr string, a smtp.Auth, from string, recipients []string, msg []byte) error {
+		sent = append(sent, string(msg))
+		to = recipients
+		return nil
+	}
+	conf := &config.Config{ExpiredReportDays: 30, StaticContent: "../../templates"}
+	assert.Nil(t, chkexprd.SendDigest(context.Background(), db, nt, conf))
+	assert.Equal(t, 0, len(sent), "no recipients configured")
+
+	conf.DigestRecipients = []string{"ceo@pangea.ru", "sales@pangea.ru"}
+	assert.Nil(t, chkexprd.SendDigest(context.Background(), db, nt, conf))
+	assert.Equal(t, 1, len(sent))
+	assert.Equal(t, []string{"ceo@pangea.ru", "sales@pangea.ru"}, to)
+	assert.True(t, strings.Contains(sent[0], "Licensing digest"))
+	assert.True(t, strings.Contains(sent[0], "123abc"))
+	assert.True(t, strings.Contains(sent[0], "multipart/alternative"))
+}
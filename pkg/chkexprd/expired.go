@@ -0,0 +1,128 @@
+package chkexprd
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+)
+
+// ExpiredFeature describes a feature that has already expired. The feature is
+// still in grace period until GraceEnd, after that the license is considered lapsed.
+type ExpiredFeature struct {
+	KeyID      string
+	ClientID   int
+	ClientName string
+	Feature    string
+	End        time.Time
+	GraceEnd   time.Time
+	InGrace    bool
+}
+
+// FindExpiredFeatures returns features that expired within expTerm before now,
+// the most recently expired first
+func FindExpiredFeatures(db *dao.DbConn, expTerm time.Duration) ([]ExpiredFeature, error) {
+	res := []ExpiredFeature{}
+	features, err := db.ExpiredWithin(expTerm)
+	if err != nil {
+		return res, err
+	}
+	settings, err := db.AllClientSettings()
+	if err != nil {
+		return res, err
+	}
+	clients := map[string]dao.Organization{}
+	now := time.Now()
+	for _, f := range features {
+		cl, ok := clients[f.KeyID]
+		if !ok {
+			if cl, err = db.KeyOfWhichOrg(f.KeyID); err != nil {
+				return nil, err
+			}
+			clients[f.KeyID] = cl
+		}
+		graceEnd := f.ExpTime.AddDate(0, 0, settings.Get(cl.Id).GraceDays)
+		res = append(res, ExpiredFeature{KeyID: f.KeyID, ClientID: cl.Id, ClientName: cl.Name, Feature: f.Feature,
+			End: f.ExpTime, GraceEnd: graceEnd, InGrace: now.Before(graceEnd)})
+	}
+	return res, nil
+}
+
+// FindLapsedFeatures returns features whose grace period ended within
+// ExpiredReportDays and that have not been renewed
+func FindLapsedFeatures(db *dao.DbConn, conf *config.Config) ([]ExpiredFeature, error) {
+	settings, err := db.AllClientSettings()
+	if err != nil {
+		return nil, err
+	}
+	// Look back far enough to catch the features whose grace period has just ended
+	maxGrace := 0
+	for _, s := range settings {
+		if s.GraceDays > maxGrace {
+			maxGrace = s.GraceDays
+		}
+	}
+	expired, err := FindExpiredFeatures(db, daysToDuration(conf.ExpiredReportDays+maxGrace))
+	if err != nil {
+		return nil, err
+	}
+	res := []ExpiredFeature{}
+	for _, f := range expired {
+		if !f.InGrace {
+			res = append(res, f)
+		}
+	}
+	return res, nil
+}
+
+// NotifyLapsed sends a digest of features whose grace period is over and that have
+// not been renewed. Every lapsed feature is included in the digest only once.
+func NotifyLapsed(db *dao.DbConn, nt mailnotify.MailNotifyer, conf *config.Config) error {
+	expired, err := FindLapsedFeatures(db, conf)
+	if err != nil {
+		return err
+	}
+	lapsed := []ExpiredFeature{}
+	toMark := []dao.KeyFeatureExpiry{}
+	for _, f := range expired {
+		kfe := dao.KeyFeatureExpiry{KeyID: f.KeyID, Feature: f.Feature, ExpTime: f.End}
+		notified, err := db.IsNotified(dao.NotificationLapsed, kfe, 0)
+		if err != nil {
+			return err
+		}
+		if !notified {
+			lapsed = append(lapsed, f)
+			toMark = append(toMark, kfe)
+		}
+	}
+	if len(lapsed) == 0 {
+		return nil
+	}
+	if err = ReportLapsedFeatures(lapsed, nt, conf); err != nil {
+		return err
+	}
+	return db.MarkNotified(dao.NotificationLapsed, toMark, 0, time.Now())
+}
+
+// ReportLapsedFeatures sends the digest of lapsed features
+func ReportLapsedFeatures(features []ExpiredFeature, nt mailnotify.MailNotifyer, conf *config.Config) error {
+	log.Println("Reporting lapsed features: ", len(features))
+	sorted := append([]ExpiredFeature{}, features...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].ClientName != sorted[j].ClientName {
+			return sorted[i].ClientName < sorted[j].ClientName
+		}
+		if sorted[i].KeyID != sorted[j].KeyID {
+			return sorted[i].KeyID < sorted[j].KeyID
+		}
+		return sorted[i].Feature < sorted[j].Feature
+	})
+	ml, err := MakeLapsedMail(sorted, conf)
+	if err != nil {
+		return err
+	}
+	return nt.SendMail(ml)
+}
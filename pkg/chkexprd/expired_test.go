@@ -0,0 +1,60 @@
+package chkexprd_test
+
+import (
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/chkexprd"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+)
+
+func TestNotifyLapsed(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	// Keys of Org 1 expired 3 days ago, key of Org 2 expired 10 days ago
+	for _, keyID := range []string{"123abc", "123cbc"} {
+		licSet, _ := db.LicensesSetByKeyId(keyID)
+		if len(licSet) == 0 {
+			licSet = []dao.LicenseSetItem{{KeyID: keyID, Feature: "F1", Version: 19.0, Count: 1, Start: time.Now().AddDate(-1, 0, 0)}}
+		}
+		for i := range licSet {
+			licSet[i].End = time.Now().AddDate(0, 0, -3)
+			if keyID == "123cbc" {
+				licSet[i].End = time.Now().AddDate(0, 0, -10)
+			}
+		}
+		if err := db.UpdateLicenseSet(keyID, licSet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetClientSettings(dao.ClientSettings{OrgId: 1, GraceDays: 7}); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := chkexprd.FindExpiredFeatures(db, 30*24*time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(expired))
+	for _, f := range expired {
+		assert.Equal(t, f.ClientID == 1, f.InGrace, f)
+	}
+
+	var body []byte
+	sent := 0
+	notifyer := mailnotify.New("mail.server", 25, "user@pangea.ru", "**pass**").AddTo("some.addressee")
+	m := notifyer.(*mailnotify.MailServiceImpl)
+	m.Send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent++
+		body = msg
+		return nil
+	}
+	conf := config.Config{Port: 9995, PublicName: "some.host", ExpiredReportDays: 30}
+	assert.Nil(t, chkexprd.NotifyLapsed(db, m, &conf))
+	assert.Equal(t, 1, sent)
+	assert.Contains(t, string(body), "123cbc")
+	assert.NotContains(t, string(body), "123abc")
+	// Lapsed features are reported only once
+	assert.Nil(t, chkexprd.NotifyLapsed(db, m, &conf))
+	assert.Equal(t, 1, sent)
+}
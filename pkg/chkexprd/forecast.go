@@ -0,0 +1,115 @@
+package chkexprd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// Groupings of the expiry forecast
+const (
+	GroupByClient  = "client"
+	GroupByFeature = "feature"
+	GroupByMonth   = "month"
+)
+
+// IsValidGroupBy checks if the forecast may be grouped so
+func IsValidGroupBy(groupBy string) bool {
+	return groupBy == GroupByClient || groupBy == GroupByFeature || groupBy == GroupByMonth
+}
+
+// ForecastMonth is the number of features (and keys they belong to) ending in the month
+type ForecastMonth struct {
+	Month    string
+	Features int
+	Keys     int
+}
+
+// ForecastGroup aggregates features ending within the forecast period. Group is the
+// client ID, the feature name or the month (YYYY-MM), Label is the name shown to users.
+// Months lists the months the features of the group end in, in ascending order.
+type ForecastGroup struct {
+	Group    string
+	Label    string
+	Features int
+	Keys     int
+	FirstEnd time.Time
+	LastEnd  time.Time
+	Months   []ForecastMonth
+}
+
+// Forecast returns the timeline of features ending within the given dates grouped
+// by client, feature or month. Groups by month are in chronological order, the
+// other ones are ordered by label.
+func Forecast(db *dao.DbConn, from time.Time, to time.Time, groupBy string) ([]ForecastGroup, error) {
+	if !IsValidGroupBy(groupBy) {
+		return nil, fmt.Errorf("invalid grouping %q", groupBy)
+	}
+	features, err := db.EndingBetween(from, to)
+	if err != nil {
+		return nil, err
+	}
+	type acc struct {
+		group  ForecastGroup
+		keys   map[string]bool
+		months map[string]map[string]bool
+		counts map[string]int
+	}
+	groups := map[string]*acc{}
+	order := []string{}
+	for _, f := range features {
+		var id, label string
+		month := f.End.Format("2006-01")
+		switch groupBy {
+		case GroupByClient:
+			id, label = strconv.Itoa(f.ClientID), f.ClientName
+		case GroupByFeature:
+			id, label = f.Feature, f.Feature
+		case GroupByMonth:
+			id, label = month, f.End.Format("January 2006")
+		}
+		g, ok := groups[id]
+		if !ok {
+			g = &acc{group: ForecastGroup{Group: id, Label: label, FirstEnd: f.End}, keys: map[string]bool{},
+				months: map[string]map[string]bool{}, counts: map[string]int{}}
+			groups[id] = g
+			order = append(order, id)
+		}
+		g.group.Features++
+		g.keys[f.KeyID] = true
+		// Features come ordered by the end date
+		g.group.LastEnd = f.End
+		if g.months[month] == nil {
+			g.months[month] = map[string]bool{}
+		}
+		g.months[month][f.KeyID] = true
+		g.counts[month]++
+	}
+	res := []ForecastGroup{}
+	for _, id := range order {
+		g := groups[id]
+		g.group.Keys = len(g.keys)
+		g.group.Months = []ForecastMonth{}
+		for month, keys := range g.months {
+			g.group.Months = append(g.group.Months, ForecastMonth{Month: month, Features: g.counts[month], Keys: len(keys)})
+		}
+		sort.Slice(g.group.Months, func(i, j int) bool { return g.group.Months[i].Month < g.group.Months[j].Month })
+		res = append(res, g.group)
+	}
+	if groupBy != GroupByMonth {
+		sort.SliceStable(res, func(i, j int) bool { return res[i].Label < res[j].Label })
+	}
+	return res, nil
+}
+
+// Months returns all the months (YYYY-MM) of the period
+func Months(from time.Time, to time.Time) []string {
+	res := []string{}
+	for m := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location()); !m.After(to); m = m.AddDate(0, 1, 0) {
+		res = append(res, m.Format("2006-01"))
+	}
+	return res
+}
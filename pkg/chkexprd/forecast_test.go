@@ -0,0 +1,53 @@
+package chkexprd_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/chkexprd"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+func TestForecast(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	// Features of the keys of Org 1 end in July 2008, the key of Org 2 gets a feature ending in September
+	err := db.UpdateLicenseSet("123cbc", []dao.LicenseSetItem{{KeyID: "123cbc", Feature: "F1", Version: 19.0, Count: 1,
+		Start: time.Date(2008, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2008, 9, 15, 0, 0, 0, 0, time.UTC)}})
+	assert.Nil(t, err)
+	from := time.Date(2008, 7, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2008, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	byMonth, err := chkexprd.Forecast(db, from, to, chkexprd.GroupByMonth)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(byMonth)) {
+		assert.Equal(t, "2008-07", byMonth[0].Group)
+		assert.Equal(t, 5, byMonth[0].Features)
+		assert.Equal(t, 2, byMonth[0].Keys)
+		assert.Equal(t, "2008-09", byMonth[1].Group)
+		assert.Equal(t, time.Date(2008, 9, 15, 0, 0, 0, 0, time.UTC), byMonth[1].FirstEnd)
+	}
+
+	byClient, err := chkexprd.Forecast(db, from, to, chkexprd.GroupByClient)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(byClient)) {
+		assert.Equal(t, "Org 1", byClient[0].Label)
+		assert.Equal(t, "1", byClient[0].Group)
+		assert.Equal(t, []chkexprd.ForecastMonth{{Month: "2008-07", Features: 5, Keys: 2}}, byClient[0].Months)
+		assert.Equal(t, "Org 2", byClient[1].Label)
+	}
+
+	byFeature, err := chkexprd.Forecast(db, from, to, chkexprd.GroupByFeature)
+	assert.Nil(t, err)
+	assert.Equal(t, "F1", byFeature[0].Group)
+	assert.Equal(t, 2, byFeature[0].Keys)
+	assert.Equal(t, []chkexprd.ForecastMonth{{Month: "2008-07", Features: 1, Keys: 1}, {Month: "2008-09", Features: 1, Keys: 1}}, byFeature[0].Months)
+
+	_, err = chkexprd.Forecast(db, from, to, "year")
+	assert.NotNil(t, err)
+}
+
+func TestMonths(t *testing.T) {
+	assert.Equal(t, []string{"2019-11", "2019-12", "2020-01"},
+		chkexprd.Months(time.Date(2019, 11, 20, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 5, 0, 0, 0, 0, time.UTC)))
+}
@@ -2,10 +2,13 @@ package chkexprd
 
 import (
 	"fmt"
-	"github.com/vaefremov/pnglic/config"
+	"sort"
 	"strings"
-	"text/template"
 	"time"
+
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+	"github.com/vaefremov/pnglic/pkg/mailtmpl"
 )
 
 type templData struct {
@@ -39,33 +42,48 @@ func MakeSimpleMessage(features map[string]ExpFeaturesReportElt, expTerm time.Du
 	return bld.String(), nil
 }
 
+// MakeMessageFromTemplate makes the text of the warning about features that will expire soon
 func MakeMessageFromTemplate(features map[string]ExpFeaturesReportElt, expTerm time.Duration, conf *config.Config) (res string, err error) {
-	bld := strings.Builder{}
+	ml, err := MakeExpiringMail(features, expTerm, conf)
+	return ml.Text, err
+}
+
+// MakeExpiringMail makes the warning about features that will expire soon, the mail
+// is addressed to administrators and is rendered in conf.MailLang
+func MakeExpiringMail(features map[string]ExpFeaturesReportElt, expTerm time.Duration, conf *config.Config) (res mailnotify.Mail, err error) {
 	data := templData{ExpTerm: expTerm, ExpTermDays: int(expTerm.Hours() / 24),
-		ServerPort: conf.Port, ServerPublicURL: fmt.Sprintf("http://%s:%d", conf.PublicName, conf.Port)}
+		ServerPort: conf.Port, ServerPublicURL: serverPublicURL(conf)}
 	for k, v := range features {
 		tmp := templDataElt{KeyID: k, ExpFeaturesReportElt: v, ExpTermDays: int(v.ExpTerm.Hours() / 24),
 			ExpTimeStr: v.ExpTime.Format("2006-01-02")}
 		data.Keys = append(data.Keys, tmp)
 	}
-	t := template.New("message")
-	t, err = t.Parse(messageTemplate)
-	if err != nil {
-		return "", err
-	}
-	err = t.Execute(&bld, data)
-	return bld.String(), err
+	return mailtmpl.New(conf.StaticContent).Render(mailtmpl.Expiring, conf.MailLang, data)
 }
 
-const messageTemplate = `
-Please, check the following keys for features that will expire soon (in {{.ExpTermDays}} day):
+// MakeLapsedMail makes the digest of lapsed features rendered in conf.MailLang
+func MakeLapsedMail(features []ExpiredFeature, conf *config.Config) (res mailnotify.Mail, err error) {
+	data := struct {
+		ServerPublicURL string
+		Features        []ExpiredFeature
+	}{ServerPublicURL: serverPublicURL(conf), Features: features}
+	return mailtmpl.New(conf.StaticContent).Render(mailtmpl.Lapsed, conf.MailLang, data)
+}
 
-{{ range $index, $key := .Keys }}
-{{$index}} : {{$key.KeyID}} ({{$.ServerPublicURL}}/v1/view/keyfeatures.html?keyId={{$key.KeyID}}&fullPage=true)
-		Client: {{$key.ClientName}}
-		{{range $feature := $key.Features}}{{$feature}} {{end}} will expire in {{$key.ExpTermDays}} day(s)
-		Expiration date: {{$key.ExpTimeStr}}
-{{ end }}
+// MakeCustomerMail makes the expiry notice sent to the client in the client's language
+func MakeCustomerMail(clientName string, lang string, features map[string]ExpFeaturesReportElt, conf *config.Config) (res mailnotify.Mail, err error) {
+	data := struct {
+		ClientName string
+		Keys       []templDataElt
+	}{ClientName: clientName}
+	for k, v := range features {
+		data.Keys = append(data.Keys, templDataElt{KeyID: k, ExpFeaturesReportElt: v, ExpTermDays: int(v.ExpTerm.Hours() / 24),
+			ExpTimeStr: v.ExpTime.Format("2006-01-02")})
+	}
+	sort.Slice(data.Keys, func(i, j int) bool { return data.Keys[i].KeyID < data.Keys[j].KeyID })
+	return mailtmpl.New(conf.StaticContent).Render(mailtmpl.Customer, lang, data)
+}
 
-Hope that helps. Thanks!
-`
+func serverPublicURL(conf *config.Config) string {
+	return fmt.Sprintf("http://%s:%d", conf.PublicName, conf.Port)
+}
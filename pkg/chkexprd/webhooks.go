@@ -0,0 +1,58 @@
+package chkexprd
+
+import (
+	"time"
+
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/webhook"
+)
+
+// EmitExpiryEvents posts feature.expiring for every expiry threshold reached by
+// a feature and feature.expired for features expired within ExpiredReportDays.
+// Like mail warnings, each event is posted only once.
+func EmitExpiryEvents(db *dao.DbConn, hooks *webhook.Dispatcher, conf *config.Config) error {
+	now := time.Now()
+	if thresholds := conf.Thresholds(); len(thresholds) > 0 {
+		features, err := db.WillEndSoon(daysToDuration(thresholds[len(thresholds)-1]))
+		if err != nil {
+			return err
+		}
+		for _, f := range features {
+			threshold := thresholdFor(f.ExpTerm, thresholds)
+			err = emitOnce(db, hooks, webhook.EventFeatureExpiring, dao.NotificationHookExpiring, f, threshold, now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	features, err := db.ExpiredWithin(daysToDuration(conf.ExpiredReportDays))
+	if err != nil {
+		return err
+	}
+	for _, f := range features {
+		err = emitOnce(db, hooks, webhook.EventFeatureExpired, dao.NotificationHookExpired, f, 0, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitOnce emits the event unless it has been emitted for the feature and threshold already
+func emitOnce(db *dao.DbConn, hooks *webhook.Dispatcher, event string, kind string, f dao.KeyFeatureExpiry, threshold int, now time.Time) error {
+	notified, err := db.IsNotified(kind, f, threshold)
+	if err != nil || notified {
+		return err
+	}
+	cl, err := db.KeyOfWhichOrg(f.KeyID)
+	if err != nil {
+		return err
+	}
+	payload := webhook.FeatureExpiry{KeyID: f.KeyID, ClientID: cl.Id, ClientName: cl.Name, Feature: f.Feature,
+		End: f.ExpTime.Format("2006-01-02"), DaysLeft: threshold}
+	if err = hooks.Emit(event, payload); err != nil {
+		return err
+	}
+	return db.MarkNotified(kind, []dao.KeyFeatureExpiry{f}, threshold, now)
+}
@@ -0,0 +1,51 @@
+package chkexprd_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/chkexprd"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/webhook"
+)
+
+func TestEmitExpiryEvents(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	db.UpdateLicenseSet("123abc", []dao.LicenseSetItem{
+		{KeyID: "123abc", Feature: "F1", Version: 19.0, Count: 1, Start: time.Now().AddDate(-1, 0, 0), End: time.Now().AddDate(0, 0, 3)},
+		{KeyID: "123abc", Feature: "F2", Version: 19.0, Count: 1, Start: time.Now().AddDate(-1, 0, 0), End: time.Now().AddDate(0, 0, -3)},
+	})
+	conf := &config.Config{ExpiryThresholds: []int{7}, ExpiredReportDays: 30,
+		Webhooks: []config.Webhook{{URL: "http://ops.example.com/hook"}}}
+	hooks := webhook.New(db, conf)
+	assert.Nil(t, chkexprd.EmitExpiryEvents(db, hooks, conf))
+
+	deliveries, err := db.WebhookDeliveries(100)
+	assert.Nil(t, err)
+	events := map[string]webhook.FeatureExpiry{}
+	for _, d := range deliveries {
+		ev := struct {
+			Data webhook.FeatureExpiry `json:"data"`
+		}{}
+		assert.Nil(t, json.Unmarshal(d.Payload, &ev))
+		if ev.Data.KeyID == "123abc" {
+			events[d.Event+" "+ev.Data.Feature] = ev.Data
+		}
+	}
+	expiring, ok := events[webhook.EventFeatureExpiring+" F1"]
+	assert.True(t, ok)
+	assert.Equal(t, 7, expiring.DaysLeft)
+	assert.Equal(t, 1, expiring.ClientID)
+	expired, ok := events[webhook.EventFeatureExpired+" F2"]
+	assert.True(t, ok)
+	assert.Equal(t, time.Now().AddDate(0, 0, -3).Format("2006-01-02"), expired.End)
+
+	// Events are emitted only once
+	total := len(deliveries)
+	assert.Nil(t, chkexprd.EmitExpiryEvents(db, hooks, conf))
+	deliveries, _ = db.WebhookDeliveries(100)
+	assert.Equal(t, total, len(deliveries))
+}
@@ -0,0 +1,132 @@
+package dao
+
+import "sort"
+
+// Attributes of the features compared by CompareLicenseSets
+const (
+	CompareLicensed = "licensed"
+	CompareVersion  = "version"
+	CompareCount    = "count"
+	CompareEnd      = "end"
+)
+
+// ComparedFeature is the feature as the license file of the key grants it
+type ComparedFeature struct {
+	LicenseSetItem
+	// Package the feature is granted with, empty if it is licensed by itself
+	Package string
+}
+
+// FeatureComparison is the row of the feature-by-key matrix
+type FeatureComparison struct {
+	Feature   string
+	IsPackage bool
+	// Items are in the order of the keys compared, nil if the key has no such feature
+	Items []*ComparedFeature
+	// Differs lists the attributes that are not the same for all the keys
+	Differs []string
+}
+
+// grantedFeatures expands the packages of the license set the way the license file does
+// and returns the features granted by feature name. A feature licensed by itself wins
+// over the packages containing it, of several packages the one ending later wins.
+func (db *DbConn) grantedFeatures(licSet []LicenseSetItem) (res map[string]*ComparedFeature, err error) {
+	res = map[string]*ComparedFeature{}
+	for _, f := range licSet {
+		res[f.Feature] = &ComparedFeature{LicenseSetItem: f}
+	}
+	for _, f := range licSet {
+		isPkg, err := db.IsPackage(f.Feature)
+		if err != nil {
+			return res, err
+		}
+		if !isPkg {
+			continue
+		}
+		content, err := db.PackageContent(f.Feature)
+		if err != nil {
+			return res, err
+		}
+		for _, ff := range content {
+			if prev, ok := res[ff.Feature]; ok && (prev.Package == "" || !f.End.After(prev.End)) {
+				continue
+			}
+			item := f
+			item.Feature = ff.Feature
+			res[ff.Feature] = &ComparedFeature{LicenseSetItem: item, Package: f.Feature}
+		}
+	}
+	return
+}
+
+// CompareLicenseSets returns the features granted to the keys, directly or with the
+// packages, along with the attributes that differ between the keys. Packages go first,
+// then features, both by name.
+func (db *DbConn) CompareLicenseSets(keyIDs []string) (res []FeatureComparison, err error) {
+	res = []FeatureComparison{}
+	granted := []map[string]*ComparedFeature{}
+	names := map[string]bool{}
+	for _, keyID := range keyIDs {
+		licSet, err := db.LicensesSetByKeyId(keyID)
+		if err != nil {
+			return res, err
+		}
+		features, err := db.grantedFeatures(licSet)
+		if err != nil {
+			return res, err
+		}
+		for name := range features {
+			names[name] = true
+		}
+		granted = append(granted, features)
+	}
+	for name := range names {
+		isPkg, err := db.IsPackage(name)
+		if err != nil {
+			return res, err
+		}
+		row := FeatureComparison{Feature: name, IsPackage: isPkg}
+		for _, features := range granted {
+			row.Items = append(row.Items, features[name])
+		}
+		row.Differs = differences(row.Items)
+		res = append(res, row)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].IsPackage != res[j].IsPackage {
+			return res[i].IsPackage
+		}
+		return res[i].Feature < res[j].Feature
+	})
+	return
+}
+
+// differences returns the attributes differing between the items, only the keys having
+// the feature are compared by version, count and end
+func differences(items []*ComparedFeature) []string {
+	res := []string{}
+	var first *ComparedFeature
+	licensed, version, count, end := true, true, true, true
+	for _, it := range items {
+		if it == nil {
+			licensed = false
+			continue
+		}
+		if first == nil {
+			first = it
+			continue
+		}
+		version = version && it.Version == first.Version
+		count = count && it.Count == first.Count
+		end = end && it.End.Equal(first.End)
+	}
+	for _, d := range []struct {
+		same bool
+		attr string
+	}{{licensed, CompareLicensed}, {version, CompareVersion}, {count, CompareCount}, {end, CompareEnd}} {
+		if !d.same {
+			res = append(res, d.attr)
+		}
+	}
+	return res
+}
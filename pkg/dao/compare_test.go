@@ -0,0 +1,44 @@
+package dao_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+func TestCompareLicenseSets(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	res, err := db.CompareLicenseSets([]string{"123abc", "123bbc"})
+	assert.Nil(t, err)
+	names := []string{}
+	for _, row := range res {
+		names = append(names, row.Feature)
+		assert.Equal(t, 2, len(row.Items), row.Feature)
+	}
+	assert.Equal(t, []string{"P1", "F1", "F2", "F3"}, names)
+	assert.True(t, res[0].IsPackage)
+	assert.Equal(t, []string{dao.CompareCount}, res[0].Differs)
+
+	// F1 comes with P1 to 123abc and is licensed by itself to 123bbc
+	f1 := res[1]
+	assert.Equal(t, "P1", f1.Items[0].Package)
+	assert.Equal(t, 10, f1.Items[0].Count)
+	assert.Equal(t, "", f1.Items[1].Package)
+	assert.Equal(t, 30, f1.Items[1].Count)
+	assert.Equal(t, []string{dao.CompareVersion, dao.CompareCount}, f1.Differs)
+
+	res, err = db.CompareLicenseSets([]string{"123abc", "123cbc"})
+	assert.Nil(t, err)
+	for _, row := range res {
+		assert.NotNil(t, row.Items[0])
+		assert.Nil(t, row.Items[1])
+		assert.Equal(t, []string{dao.CompareLicensed}, row.Differs)
+	}
+
+	res, err = db.CompareLicenseSets([]string{"123abc", "123abc"})
+	assert.Nil(t, err)
+	for _, row := range res {
+		assert.Empty(t, row.Differs)
+	}
+}
@@ -0,0 +1,134 @@
+package dao
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Roles of contact persons
+const (
+	RoleTechnical    = "technical"
+	RoleBilling      = "billing"
+	RoleLicenseAdmin = "licadmin"
+)
+
+// Contact is a contact person of an organization. NotifyExpiry and NotifyFiles are
+// notification preferences: whether the person wants to receive expiry notices
+// and license files, respectively.
+type Contact struct {
+	Id           int    `db:"id"`
+	OrgId        int    `db:"orgid"`
+	Name         string `db:"name"`
+	Email        string `db:"email"`
+	Phone        string `db:"phone"`
+	Role         string `db:"role"`
+	NotifyExpiry bool   `db:"notifyexpiry"`
+	NotifyFiles  bool   `db:"notifyfiles"`
+}
+
+// IsValidRole checks if role is one of the known roles of contact persons
+func IsValidRole(role string) bool {
+	return role == RoleTechnical || role == RoleBilling || role == RoleLicenseAdmin
+}
+
+// Contacts returns contact persons of the organization
+func (db *DbConn) Contacts(orgID int) (res []Contact, err error) {
+	res = []Contact{}
+	err = db.conn.Select(&res, "select id, orgid, name, email, phone, role, notifyexpiry, notifyfiles from contacts where orgid=? order by id", orgID)
+	return
+}
+
+// AllContacts returns contact persons of all organizations
+func (db *DbConn) AllContacts() (res []Contact, err error) {
+	res = []Contact{}
+	err = db.conn.Select(&res, "select id, orgid, name, email, phone, role, notifyexpiry, notifyfiles from contacts order by orgid, id")
+	return
+}
+
+// Contact returns the contact person with the given ID
+func (db *DbConn) Contact(id int) (res Contact, err error) {
+	err = db.conn.Get(&res, "select id, orgid, name, email, phone, role, notifyexpiry, notifyfiles from contacts where id=?", id)
+	return
+}
+
+// CreateContact adds a new contact person to the organization, ID of the new contact is returned
+func (db *DbConn) CreateContact(c Contact) (id int, err error) {
+	if _, err = db.ClientNameByID(c.OrgId); err != nil {
+		return 0, fmt.Errorf("invalid org ID %d", c.OrgId)
+	}
+	res, err := db.conn.Exec("insert into contacts (orgid, name, email, phone, role, notifyexpiry, notifyfiles) values (?, ?, ?, ?, ?, ?, ?)",
+		c.OrgId, c.Name, c.Email, c.Phone, c.Role, c.NotifyExpiry, c.NotifyFiles)
+	if err != nil {
+		return 0, errors.Wrap(err, "when inserting new contact:")
+	}
+	id64, err := res.LastInsertId()
+	return int(id64), err
+}
+
+// UpdateContact replaces the contact person data. The contact cannot be moved to another organization.
+func (db *DbConn) UpdateContact(c Contact) (err error) {
+	res, err := db.conn.Exec("update contacts set name=?, email=?, phone=?, role=?, notifyexpiry=?, notifyfiles=? where id=?",
+		c.Name, c.Email, c.Phone, c.Role, c.NotifyExpiry, c.NotifyFiles, c.Id)
+	if err != nil {
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("invalid contact ID %d", c.Id)
+	}
+	return
+}
+
+// DeleteContact deletes the contact person
+func (db *DbConn) DeleteContact(id int) (err error) {
+	res, err := db.conn.Exec("delete from contacts where id=?", id)
+	if err != nil {
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("invalid contact ID %d", id)
+	}
+	return
+}
+
+var addressRe = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+
+// ExtractAddresses picks e-mail addresses out of a free-form text, e.g. the contacts
+// of an organization. Duplicates are skipped.
+func ExtractAddresses(text string) []string {
+	res := []string{}
+	seen := map[string]bool{}
+	for _, addr := range addressRe.FindAllString(text, -1) {
+		if !seen[strings.ToLower(addr)] {
+			seen[strings.ToLower(addr)] = true
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
+// migrateContacts makes structured contacts out of the free-text contacts of organizations.
+// Every e-mail address found becomes a contact person, the text around the address
+// is taken as the name.
+func migrateContacts(tx *sqlx.Tx) (err error) {
+	orgs := []Organization{}
+	if err = tx.Select(&orgs, "select id, name, ifnull(contact, '') as contact, ifnull(comments, '') as comments from organizations"); err != nil {
+		return
+	}
+	for _, o := range orgs {
+		for _, part := range strings.FieldsFunc(o.Contacts, func(r rune) bool { return r == ',' || r == ';' || r == '\n' }) {
+			for _, addr := range ExtractAddresses(part) {
+				name := strings.Trim(strings.Replace(part, addr, "", 1), " \t\r<>():\"")
+				_, err = tx.Exec("insert into contacts (orgid, name, email, phone, role, notifyexpiry, notifyfiles) values (?, ?, ?, '', ?, 1, 0)",
+					o.Id, name, addr, RoleLicenseAdmin)
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
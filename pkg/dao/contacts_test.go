@@ -0,0 +1,81 @@
+package dao_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+func TestContacts(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	id, err := db.CreateContact(dao.Contact{OrgId: 1, Name: "Ivanov", Email: "ivanov@org1.ru", Role: dao.RoleTechnical, NotifyExpiry: true})
+	assert.Nil(t, err)
+	_, err = db.CreateContact(dao.Contact{OrgId: 20, Name: "Nobody", Role: dao.RoleTechnical})
+	assert.NotNil(t, err)
+
+	ct, err := db.Contact(id)
+	assert.Nil(t, err)
+	assert.Equal(t, "ivanov@org1.ru", ct.Email)
+	ct.Phone = "+7 495 1234567"
+	ct.NotifyFiles = true
+	assert.Nil(t, db.UpdateContact(ct))
+	contacts, err := db.Contacts(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []dao.Contact{ct}, contacts)
+
+	assert.Nil(t, db.DeleteContact(id))
+	assert.NotNil(t, db.DeleteContact(id))
+	contacts, _ = db.Contacts(1)
+	assert.Equal(t, 0, len(contacts))
+}
+
+func TestMigrateLegacyContacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pnglic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "legacy.sqlite")
+	legacy := sqlx.MustConnect("sqlite3", dsn)
+	legacy.MustExec(`CREATE TABLE organizations (id integer primary key AUTOINCREMENT, name varchar(40), contact text, comments text);
+	CREATE TABLE keys (id varchar(10) primary key not null, assigned_org integer, comments text);
+	CREATE TABLE history (orgname VARCHAR(40), whenissued VARCHAR(16) DEFAULT CURRENT_TIMESTAMP NOT NULL, xml TEXT);
+	CREATE TABLE features (feat varchar(16) primary key, ispackage integer default 0, description text);
+	CREATE TABLE pkgcontent (pkg varchar(10), feat varchar(10), primary key (pkg, feat));
+	CREATE TABLE licensesets (keyid varchar(10), feat varchar(16), ver float, count integer, start DATE, end DATE, dup varchar(4), primary key (keyid, feat));
+	CREATE TABLE templates (name varchar(10) not null, feat varchar(16), ver float, dup varchar(4), primary key (name, feat));
+	insert into organizations (name, contact, comments) values ('Org 1', 'Ivanov I.I. <ivanov@org1.ru>, tel. 1234567; petrov@org1.ru', '');
+	insert into organizations (name, contact, comments) values ('Org 2', 'Call Sidorov', '');`)
+	legacy.Close()
+
+	db, err := dao.NewPool(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contacts, err := db.Contacts(1)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(contacts)) {
+		assert.Equal(t, "Ivanov I.I.", contacts[0].Name)
+		assert.Equal(t, "ivanov@org1.ru", contacts[0].Email)
+		assert.Equal(t, "petrov@org1.ru", contacts[1].Email)
+		assert.True(t, contacts[1].NotifyExpiry)
+	}
+	contacts, _ = db.Contacts(2)
+	assert.Equal(t, 0, len(contacts))
+	// Migrations are applied only once
+	db, err = dao.NewPool(dsn)
+	assert.Nil(t, err)
+	contacts, _ = db.Contacts(1)
+	assert.Equal(t, 2, len(contacts))
+}
+
+func TestExtractAddresses(t *testing.T) {
+	res := dao.ExtractAddresses("Ivanov I.I. <ivanov@client.ru>, tel. +7 495 1234567; petrov@mail.client.ru, IVANOV@client.ru")
+	assert.Equal(t, []string{"ivanov@client.ru", "petrov@mail.client.ru"}, res)
+	assert.Equal(t, []string{}, dao.ExtractAddresses("Contact 1"))
+}
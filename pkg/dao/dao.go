@@ -1,6 +1,7 @@
 package dao
 
 import (
+	"database/sql"
 	"fmt"
 	"time"
 
@@ -23,13 +24,17 @@ type Organization struct {
 }
 
 type historyItem struct {
-	ClientName string `db:"orgname"`
-	IssueTime  string `db:"whenissued"`
-	ContentXml string `db:"xml"`
+	ClientName string        `db:"orgname"`
+	OrgID      sql.NullInt64 `db:"orgid"`
+	IssueTime  string        `db:"whenissued"`
+	ContentXml string        `db:"xml"`
 }
 
+// HistoryItem is a license file issued. OrgID is -1 for the files issued before
+// the IDs of clients were recorded, or if it is not loaded.
 type HistoryItem struct {
 	ClientName string
+	OrgID      int
 	IssueTime  time.Time
 	ContentXml string
 }
@@ -66,11 +71,21 @@ type DbConn struct {
 	conn *sqlx.DB
 }
 
-func NewPool(dsn string) (conn *DbConn, err error) {
+func connect(dsn string) (conn *DbConn, err error) {
 	c, err := sqlx.Connect("sqlite3", dsn)
 	return &DbConn{conn: c}, err
 }
 
+// NewPool connects to the database and brings its schema up to date
+func NewPool(dsn string) (conn *DbConn, err error) {
+	conn, err = connect(dsn)
+	if err != nil {
+		return
+	}
+	err = conn.Migrate()
+	return
+}
+
 func MustNewPool(dsn string) (conn *DbConn) {
 	conn, err := NewPool(dsn)
 	if err != nil {
@@ -82,10 +97,18 @@ func MustNewPool(dsn string) (conn *DbConn) {
 // MustInMemoryPool creates and initializes in-memory database. The database is populated
 // with test data.
 func MustInMemoryTestPool() (db *DbConn) {
-	db = MustNewPool(":memory:")
+	db, err := connect(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	// Every new connection to :memory: opens an empty database
+	db.conn.SetMaxOpenConns(1)
 	if _, err := db.conn.Exec(schemaSQLNew); err != nil {
 		panic(err)
 	}
+	if err := db.Migrate(); err != nil {
+		panic(err)
+	}
 	if err := populateTestDB(db.conn); err != nil {
 		panic(err)
 	}
@@ -129,6 +152,47 @@ func (db *DbConn) CreateKey(key HWKey) (err error) {
 	return
 }
 
+// CreateKeys creates all the keys in a single transaction, none of them is
+// created if any fails
+func (db *DbConn) CreateKeys(keys []HWKey) (err error) {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "unable to begin transaction in CreateKeys:")
+	}
+	defer tx.Rollback()
+	for _, key := range keys {
+		tmp := Organization{}
+		if err = tx.Get(&tmp, "select id, name, contact, comments from organizations where id=?", key.OrgId); err != nil {
+			return fmt.Errorf("invalid org ID %d", key.OrgId)
+		}
+		if _, err = tx.Exec("insert into keys (id, assigned_org, comments) values (?, ?, ?)", key.Id, key.OrgId, key.Comments); err != nil {
+			return errors.Wrapf(err, "when inserting key ID %s:", key.Id)
+		}
+	}
+	return tx.Commit()
+}
+
+// TransferKey assigns the key to another organization, the ID of the previous owner is returned
+func (db *DbConn) TransferKey(keyID string, orgID int) (prevOrgID int, err error) {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to begin transaction in TransferKey:")
+	}
+	defer tx.Rollback()
+	if err = tx.Get(&prevOrgID, "select assigned_org from keys where id=?", keyID); err != nil {
+		return 0, fmt.Errorf("invalid key ID %s", keyID)
+	}
+	tmp := Organization{}
+	if err = tx.Get(&tmp, "select id, name, contact, comments from organizations where id=?", orgID); err != nil {
+		return 0, fmt.Errorf("invalid org ID %d", orgID)
+	}
+	if _, err = tx.Exec("update keys set assigned_org=? where id=?", orgID, keyID); err != nil {
+		return 0, errors.Wrap(err, "when transferring key:")
+	}
+	err = tx.Commit()
+	return
+}
+
 func (db *DbConn) Clients() (res []Organization, err error) {
 	res = []Organization{}
 	err = db.conn.Select(&res, "select id, name, contact, comments from organizations")
@@ -159,6 +223,46 @@ func (db *DbConn) HistoryForClientId(id int) (res []HistoryItem, err error) {
 	return
 }
 
+// IssuesSince returns license files issued to all clients after the given moment,
+// the most recent first. Content of the files is not loaded.
+func (db *DbConn) IssuesSince(since time.Time) (res []HistoryItem, err error) {
+	tmp := []historyItem{}
+	res = []HistoryItem{}
+	err = db.conn.Select(&tmp, "select orgname, cast(whenissued as text) as whenissued, '' as xml from history where whenissued >= ? order by whenissued desc",
+		since.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return
+	}
+	for _, h := range tmp {
+		newH, err := convertTimeInHistory(h)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, newH)
+	}
+	return
+}
+
+// IssuesBetween returns license files issued to all clients within the given moments,
+// inclusive, the most recent first, along with the IDs of the clients if known
+func (db *DbConn) IssuesBetween(from time.Time, to time.Time) (res []HistoryItem, err error) {
+	tmp := []historyItem{}
+	res = []HistoryItem{}
+	err = db.conn.Select(&tmp, "select orgname, orgid, cast(whenissued as text) as whenissued, xml from history where whenissued >= ? and whenissued <= ? order by whenissued desc",
+		from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return
+	}
+	for _, h := range tmp {
+		newH, err := convertTimeInHistory(h)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, newH)
+	}
+	return
+}
+
 // KeyOfWhichOrg returns organization the key belongs to
 func (db *DbConn) KeyOfWhichOrg(keyID string) (res Organization, err error) {
 	res = Organization{}
@@ -183,9 +287,18 @@ func (db *DbConn) IsKeyBelongsToOrg(keyID string, orgID int) (res bool, err erro
 }
 
 func (db *DbConn) LicensesSetByKeyId(keyId string) (res []LicenseSetItem, err error) {
+	return db.selectLicenseSet("select keyid, feat, ver, count, cast(start as varchar) as start, cast(end as varchar) as end, dup from licensesets where keyid=?", keyId)
+}
+
+// LicenseSets returns license sets of all keys ordered by key ID and feature
+func (db *DbConn) LicenseSets() (res []LicenseSetItem, err error) {
+	return db.selectLicenseSet("select keyid, feat, ver, count, cast(start as varchar) as start, cast(end as varchar) as end, dup from licensesets order by keyid, feat")
+}
+
+func (db *DbConn) selectLicenseSet(query string, args ...interface{}) (res []LicenseSetItem, err error) {
 	tmp := []licenseSetItem{}
 	res = []LicenseSetItem{}
-	err = db.conn.Select(&tmp, "select keyid, feat, ver, count, cast(start as varchar) as start, cast(end as varchar) as end, dup from licensesets where keyid=?", keyId)
+	err = db.conn.Select(&tmp, query, args...)
 	if err == nil {
 		for _, lsi := range tmp {
 			tmpLsi := LicenseSetItem{KeyID: lsi.KeyID, Feature: lsi.Feature, Version: lsi.Version, Count: lsi.Count, DupGroup: lsi.DupGroup}
@@ -295,31 +408,106 @@ func (db *DbConn) IsPackage(pkg string) (res bool, err error) {
 }
 
 func (db *DbConn) UpdateLicenseSet(keyId string, newLicensesSet []LicenseSetItem) (err error) {
+	return db.UpdateLicenseSets(map[string][]LicenseSetItem{keyId: newLicensesSet})
+}
+
+// UpdateLicenseSetAtRevision replaces the license set of the key unless it has been
+// changed since the given revision, ErrStaleRevision is returned then.
+func (db *DbConn) UpdateLicenseSetAtRevision(keyID string, revision int, newLicensesSet []LicenseSetItem) (err error) {
+	return db.updateLicenseSets(map[string][]LicenseSetItem{keyID: newLicensesSet}, map[string]int{keyID: revision})
+}
+
+// UpdateLicenseSets replaces license sets of several keys in a single transaction.
+// The sets are not checked here, callers validate them with ValidateLicenseSets.
+func (db *DbConn) UpdateLicenseSets(sets map[string][]LicenseSetItem) (err error) {
+	return db.updateLicenseSets(sets, nil)
+}
+
+// UpdateLicenseSetsAtRevision replaces license sets of several keys in a single
+// transaction unless any of them has been changed since the revision given in
+// revisions, ErrStaleRevision is returned then and nothing is saved. Every key of
+// sets must have its revision given.
+func (db *DbConn) UpdateLicenseSetsAtRevision(sets map[string][]LicenseSetItem, revisions map[string]int) (err error) {
+	for keyID := range sets {
+		if revision, ok := revisions[keyID]; !ok || revision == AnyRevision {
+			return ErrStaleRevision
+		}
+	}
+	return db.updateLicenseSets(sets, revisions)
+}
+
+// updateLicenseSets replaces the license sets of the keys and bumps their revisions,
+// the keys found in revisions must still be at the revision given.
+func (db *DbConn) updateLicenseSets(sets map[string][]LicenseSetItem, revisions map[string]int) (err error) {
 	tx, err := db.conn.Beginx()
 	if err != nil {
 		return
 	}
-	// TODO: We should check if key is valid
-	// tx.Query(...)
-	_, err = tx.Exec("delete from licensesets where keyid=?", keyId)
+	defer tx.Rollback()
+	for keyId, newLicensesSet := range sets {
+		revision, ok := revisions[keyId]
+		if !ok {
+			revision = AnyRevision
+		}
+		if err = bumpRevision(tx, keyId, revision); err != nil {
+			return
+		}
+		if _, err = tx.Exec("delete from licensesets where keyid=?", keyId); err != nil {
+			return
+		}
+		for _, i := range newLicensesSet {
+			_, err = tx.Exec("insert into licensesets (keyid, feat, ver, count, start, end, dup) values (?, ?, ?, ?, ?, ?, ?) ",
+				i.KeyID, i.Feature, i.Version, i.Count, i.Start.Format("02/01/2006"), i.End.Format("02/01/2006"), i.DupGroup)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// ChangeLicenseSetItems saves and removes the given features of the key in a single
+// transaction leaving the other features intact. The features saved are inserted
+// unless the key already has them. ErrStaleRevision is returned if the license set
+// has been changed since the revision given, use AnyRevision to skip the check.
+func (db *DbConn) ChangeLicenseSetItems(keyID string, revision int, save []LicenseSetItem, remove []string) (err error) {
+	tx, err := db.conn.Beginx()
 	if err != nil {
-		tx.Rollback()
 		return
 	}
-	for _, i := range newLicensesSet {
+	defer tx.Rollback()
+	if err = bumpRevision(tx, keyID, revision); err != nil {
+		return
+	}
+	for _, feature := range remove {
+		if _, err = tx.Exec("delete from licensesets where keyid=? and feat=?", keyID, feature); err != nil {
+			return
+		}
+	}
+	for _, i := range save {
+		res, err := tx.Exec("update licensesets set ver=?, count=?, start=?, end=?, dup=? where keyid=? and feat=?",
+			i.Version, i.Count, i.Start.Format("02/01/2006"), i.End.Format("02/01/2006"), i.DupGroup, keyID, i.Feature)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
 		_, err = tx.Exec("insert into licensesets (keyid, feat, ver, count, start, end, dup) values (?, ?, ?, ?, ?, ?, ?) ",
-			i.KeyID, i.Feature, i.Version, i.Count, i.Start.Format("02/01/2006"), i.End.Format("02/01/2006"), i.DupGroup)
+			keyID, i.Feature, i.Version, i.Count, i.Start.Format("02/01/2006"), i.End.Format("02/01/2006"), i.DupGroup)
 		if err != nil {
-			tx.Rollback()
-			return
+			return err
 		}
 	}
-	err = tx.Commit()
-	return
+	return tx.Commit()
 }
 
 // AddToHistory adds license file to the history track.Client name is deduced from the
-// ID
+// ID, both are stored
 func (db *DbConn) AddToHistory(orgID int, when time.Time, fileContent string) (err error) {
 	tx, err := db.conn.Beginx()
 	if err != nil {
@@ -334,7 +522,7 @@ func (db *DbConn) AddToHistory(orgID int, when time.Time, fileContent string) (e
 		tx.Rollback()
 		return
 	}
-	_, err = tx.Exec("insert into history (orgname, whenissued, xml) values (?, ?, ?)", orgName.Name, when.Format("2006-01-02 15:04:05"), fileContent)
+	_, err = tx.Exec("insert into history (orgname, orgid, whenissued, xml) values (?, ?, ?, ?)", orgName.Name, orgID, when.Format("2006-01-02 15:04:05"), fileContent)
 	if err != nil {
 		tx.Rollback()
 		return
@@ -375,8 +563,74 @@ func (db *DbConn) WillEndSoon(intervalFromNow time.Duration) (features []KeyFeat
 	return features, nil
 }
 
+// ExpiredWithin finds all features that have expired within the given interval
+// before now. ExpTerm of the result is the time passed since the expiry.
+func (db *DbConn) ExpiredWithin(intervalBeforeNow time.Duration) (features []KeyFeatureExpiry, err error) {
+	type featureAndEnd struct {
+		KeyID   string `db:"keyid"`
+		Feature string `db:"feat"`
+		ExpTime string `db:"end"`
+		NDays   int    `db:"ndays"`
+	}
+	daysBeforeNow := int(intervalBeforeNow.Hours() / 24)
+	tmp := []featureAndEnd{}
+	err = db.conn.Select(&tmp, sqlFeaturesExpiredWithinNDays, daysBeforeNow)
+	if err != nil {
+		return nil, err
+	}
+	features = []KeyFeatureExpiry{}
+	for _, f := range tmp {
+		expTime, err := time.Parse("02/01/2006", f.ExpTime)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid end date of feature %s of key %s", f.Feature, f.KeyID)
+		}
+		expTerm := time.Duration(f.NDays) * 24 * time.Hour
+		features = append(features, KeyFeatureExpiry{KeyID: f.KeyID, Feature: f.Feature,
+			ExpTime: expTime, ExpTerm: expTerm})
+	}
+	return features, nil
+}
+
+// FeatureEnd is the end of the licensed feature together with the owner of the key
+type FeatureEnd struct {
+	KeyID      string
+	Feature    string
+	Count      int
+	ClientID   int
+	ClientName string
+	End        time.Time
+}
+
+// EndingBetween finds features that end within the given dates, inclusive,
+// ordered by the end date
+func (db *DbConn) EndingBetween(from time.Time, to time.Time) (res []FeatureEnd, err error) {
+	tmp := []struct {
+		KeyID      string `db:"keyid"`
+		Feature    string `db:"feat"`
+		Count      int    `db:"count"`
+		ClientID   int    `db:"orgid"`
+		ClientName string `db:"orgname"`
+		End        string `db:"end"`
+	}{}
+	res = []FeatureEnd{}
+	if err = db.conn.Select(&tmp, sqlFeaturesEndingBetween, from.Format("2006-01-02"), to.Format("2006-01-02")); err != nil {
+		return
+	}
+	for _, f := range tmp {
+		end, err := time.Parse("02/01/2006", f.End)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, FeatureEnd{KeyID: f.KeyID, Feature: f.Feature, Count: f.Count, ClientID: f.ClientID, ClientName: f.ClientName, End: end})
+	}
+	return
+}
+
 func convertTimeInHistory(h historyItem) (res HistoryItem, err error) {
-	res = HistoryItem{ClientName: h.ClientName, ContentXml: h.ContentXml}
+	res = HistoryItem{ClientName: h.ClientName, OrgID: -1, ContentXml: h.ContentXml}
+	if h.OrgID.Valid {
+		res.OrgID = int(h.OrgID.Int64)
+	}
 	res.IssueTime, err = time.Parse("2006-01-02 15:04:05", h.IssueTime)
 	return
 }
@@ -453,6 +707,16 @@ const (
 	from licensesets 
 	where date(substr(end, 7, 4)||'-'||substr(end, 4, 2)||'-'||substr(end, 1, 2)) > date('now')
 	and ndays <= ?`
+	sqlFeaturesExpiredWithinNDays = `select keyid, feat, cast(end as varchar) as end, julianday(date('now'))-julianday(date(substr(end, 7, 4)||'-'||substr(end, 4, 2)||'-'||substr(end, 1, 2))) as ndays 
+	from licensesets 
+	where date(substr(end, 7, 4)||'-'||substr(end, 4, 2)||'-'||substr(end, 1, 2)) <= date('now')
+	and ndays <= ?
+	order by ndays, keyid, feat`
+	sqlFeaturesEndingBetween = `select l.keyid, l.feat, l.count, o.id as orgid, o.name as orgname, cast(l.end as varchar) as end
+	from licensesets l, keys k, organizations o
+	where l.keyid = k.id and k.assigned_org = o.id
+	and date(substr(l.end, 7, 4)||'-'||substr(l.end, 4, 2)||'-'||substr(l.end, 1, 2)) between date(?) and date(?)
+	order by substr(l.end, 7, 4)||substr(l.end, 4, 2)||substr(l.end, 1, 2), l.keyid, l.feat`
 )
 
 func populateTestDB(conn *sqlx.DB) (err error) {
@@ -1,6 +1,7 @@
 package dao_test
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
 	"reflect"
@@ -214,6 +215,21 @@ func TestCreateKey(t *testing.T) {
 	// t.Error()
 }
 
+func TestTransferKey(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	prev, err := db.TransferKey("123abc", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, prev)
+	org, _ := db.KeyOfWhichOrg("123abc")
+	assert.Equal(t, 2, org.Id)
+	_, err = db.TransferKey("123abc", 20)
+	assert.NotNil(t, err)
+	_, err = db.TransferKey("nokey", 1)
+	assert.NotNil(t, err)
+	org, _ = db.KeyOfWhichOrg("123abc")
+	assert.Equal(t, 2, org.Id)
+}
+
 func TestAddToHistory(t *testing.T) {
 	db := testDB
 	tIssue := time.Now()
@@ -228,6 +244,80 @@ func TestAddToHistory(t *testing.T) {
 	}
 }
 
+func TestCreateKeys(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	assert.Nil(t, db.CreateKeys([]dao.HWKey{{Id: "a1", OrgId: 1}, {Id: "a2", OrgId: 2, Comments: "HASP"}}))
+	keys, _ := db.KeysOfOrg(2)
+	assert.Equal(t, 2, len(keys))
+	// Nothing is created if some key fails
+	assert.NotNil(t, db.CreateKeys([]dao.HWKey{{Id: "a3", OrgId: 1}, {Id: "a1", OrgId: 1}}))
+	assert.NotNil(t, db.CreateKeys([]dao.HWKey{{Id: "a4", OrgId: 1}, {Id: "a5", OrgId: 20}}))
+	keys, _ = db.KeysOfOrg(1)
+	assert.Equal(t, 3, len(keys))
+}
+
+func TestUpdateLicenseSets(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	end := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	sets := map[string][]dao.LicenseSetItem{}
+	for _, keyID := range []string{"123abc", "123bbc"} {
+		licSet, _ := db.LicensesSetByKeyId(keyID)
+		for i := range licSet {
+			licSet[i].End = end
+		}
+		sets[keyID] = licSet
+	}
+	assert.Nil(t, db.UpdateLicenseSets(sets))
+	all, _ := db.LicenseSets()
+	assert.Equal(t, 5, len(all))
+	for _, item := range all {
+		assert.Equal(t, end, item.End)
+	}
+}
+
+func TestChangeLicenseSetItems(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+	end := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	changed := licSet[0]
+	changed.End = end
+	added := dao.LicenseSetItem{KeyID: "123abc", Feature: "F4", Version: 20, Count: 2, Start: licSet[0].Start, End: end}
+	assert.Nil(t, db.ChangeLicenseSetItems("123abc", dao.AnyRevision, []dao.LicenseSetItem{changed, added}, []string{licSet[1].Feature}))
+	res, _ := db.LicensesSetByKeyId("123abc")
+	assert.ElementsMatch(t, []dao.LicenseSetItem{changed, added}, res)
+	other, _ := db.LicensesSetByKeyId("123bbc")
+	assert.Equal(t, 3, len(other))
+}
+
+func TestIssuesBetween(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	issued := time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC)
+	assert.Nil(t, db.AddToHistory(1, issued, mockLicenseFile))
+	assert.Nil(t, db.AddToHistory(2, issued.AddDate(0, 0, 10), "<license/>"))
+	res, err := db.IssuesBetween(issued.AddDate(0, 0, -1), issued.AddDate(0, 0, 1))
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(res)) {
+		assert.Equal(t, "Org 1", res[0].ClientName)
+		assert.Equal(t, issued, res[0].IssueTime)
+		assert.Equal(t, mockLicenseFile, res[0].ContentXml)
+	}
+	res, err = db.IssuesBetween(issued, issued.AddDate(1, 0, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res))
+	assert.Equal(t, "Org 2", res[0].ClientName)
+}
+
+func TestLicenseSets(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	res, err := db.LicenseSets()
+	assert.Nil(t, err)
+	own, _ := db.LicensesSetByKeyId("123abc")
+	assert.True(t, len(res) >= len(own))
+	for i := 1; i < len(res); i++ {
+		assert.True(t, res[i-1].KeyID < res[i].KeyID || res[i-1].KeyID == res[i].KeyID && res[i-1].Feature < res[i].Feature)
+	}
+}
+
 func TestCreateOrUpdateFeature(t *testing.T) {
 	db := testDB
 	newFeature := "NEW1"
@@ -347,6 +437,72 @@ func TestWillEndSoon(t *testing.T) {
 	// t.Error(endingFeat)
 }
 
+func TestExpiredWithin(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	ended := time.Now().AddDate(0, 0, -3)
+	assert.Nil(t, db.UpdateLicenseSet("123abc", []dao.LicenseSetItem{{KeyID: "123abc", Feature: "F1", Version: 19.0, Count: 1,
+		Start: ended.AddDate(-1, 0, 0), End: ended}}))
+	expired, err := db.ExpiredWithin(10 * 24 * time.Hour)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(expired)) {
+		assert.Equal(t, ended.Format("2006-01-02"), expired[0].ExpTime.Format("2006-01-02"))
+		assert.Equal(t, 3*24*time.Hour, expired[0].ExpTerm)
+	}
+
+	// The malformed end date is reported instead of being read as the year 1
+	_, err = db.Connx().Exec("update licensesets set end='31/02/2020' where keyid='123abc'")
+	assert.Nil(t, err)
+	_, err = db.ExpiredWithin(100 * 365 * 24 * time.Hour)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "123abc")
+	}
+}
+
+func TestMarkNotified(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	end := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+	f := dao.KeyFeatureExpiry{KeyID: "123abc", Feature: "F3", ExpTime: end}
+	notified, err := db.IsNotified(dao.NotificationExpiry, f, 7)
+	assert.Nil(t, err)
+	assert.False(t, notified)
+	err = db.MarkNotified(dao.NotificationExpiry, []dao.KeyFeatureExpiry{f}, 7, time.Now())
+	assert.Nil(t, err)
+	notified, _ = db.IsNotified(dao.NotificationExpiry, f, 7)
+	assert.True(t, notified)
+	notified, _ = db.IsNotified(dao.NotificationExpiry, f, 1)
+	assert.False(t, notified)
+	// Prolonged feature should be reported again
+	f.ExpTime = end.AddDate(1, 0, 0)
+	notified, _ = db.IsNotified(dao.NotificationExpiry, f, 7)
+	assert.False(t, notified)
+	res, err := db.NotificationsSince(time.Now().AddDate(0, 0, -1))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res))
+	assert.Equal(t, end, res[0].End)
+}
+
+func TestClientSettings(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	s, err := db.ClientSettings(1)
+	assert.Nil(t, err)
+	assert.Equal(t, dao.ClientSettings{OrgId: 1, Lang: dao.DefaultLang}, s)
+	assert.Nil(t, db.SetClientSettings(dao.ClientSettings{OrgId: 1, GraceDays: 14}))
+	s, err = db.ClientSettings(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 14, s.GraceDays)
+	assert.Equal(t, dao.DefaultLang, s.Lang)
+	assert.Nil(t, db.SetClientSettings(dao.ClientSettings{OrgId: 1, GraceDays: 14, Lang: "ru", Manager: "ivanov@pangea.ru"}))
+	s, _ = db.ClientSettings(1)
+	assert.Equal(t, "ru", s.Lang)
+	assert.Equal(t, "ivanov@pangea.ru", s.Manager)
+	assert.NotNil(t, db.SetClientSettings(dao.ClientSettings{OrgId: 20, GraceDays: 14}))
+	all, err := db.AllClientSettings()
+	assert.Nil(t, err)
+	assert.Equal(t, 14, all.Get(1).GraceDays)
+	assert.Equal(t, 0, all.Get(2).GraceDays)
+	assert.Equal(t, dao.DefaultLang, all.Get(2).Lang)
+}
+
 func TestMain(m *testing.M) {
 	testDB = dao.MustInMemoryTestPool()
 	os.Exit(m.Run())
@@ -369,3 +525,36 @@ const mockLicenseFile = `<?xml version="1.0"?>
 </package>
 </license_server>
 `
+
+func TestLeases(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	now := time.Date(2020, 3, 10, 9, 0, 0, 0, time.UTC)
+	_, err := db.Lease("background")
+	assert.Equal(t, sql.ErrNoRows, err)
+	ok, err := db.AcquireLease("background", "host1", now, now.Add(time.Second))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, _ = db.AcquireLease("background", "host2", now.Add(500*time.Millisecond), now.Add(1500*time.Millisecond))
+	assert.False(t, ok)
+	lease, err := db.Lease("background")
+	assert.Nil(t, err)
+	assert.Equal(t, dao.Lease{Name: "background", Holder: "host1", Expires: now.Add(time.Second)}, lease)
+	assert.Nil(t, db.ReleaseLease("background", "host2"))
+	_, err = db.Lease("background")
+	assert.Nil(t, err)
+	assert.Nil(t, db.ReleaseLease("background", "host1"))
+	ok, _ = db.AcquireLease("background", "host2", now.Add(500*time.Millisecond), now.Add(1500*time.Millisecond))
+	assert.True(t, ok)
+}
+
+func TestEndingBetween(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	res, err := db.EndingBetween(time.Date(2008, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2008, 7, 8, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(res))
+	assert.Equal(t, dao.FeatureEnd{KeyID: "123abc", Feature: "F3", Count: 10, ClientID: 1, ClientName: "Org 1",
+		End: time.Date(2008, 7, 8, 0, 0, 0, 0, time.UTC)}, res[0])
+	res, err = db.EndingBetween(time.Date(2008, 7, 9, 0, 0, 0, 0, time.UTC), time.Date(2009, 7, 8, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(res))
+}
@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Lease is a named lock held by one of the server instances sharing the database
+// until it expires. Times of leases are kept in UTC since the instances may run
+// in different time zones.
+type Lease struct {
+	Name    string
+	Holder  string
+	Expires time.Time
+}
+
+// Leases are short, times are kept with milliseconds
+const leaseTimeFormat = "2006-01-02 15:04:05.000"
+
+// AcquireLease takes the lease for the holder till expires, or prolongs it if the
+// holder has it already. The lease is not taken if another holder has it and it
+// has not expired by now.
+func (db *DbConn) AcquireLease(name string, holder string, now time.Time, expires time.Time) (ok bool, err error) {
+	exp := expires.UTC().Format(leaseTimeFormat)
+	res, err := db.conn.Exec("insert or ignore into leases (name, holder, expires) values (?, ?, ?)", name, holder, exp)
+	if err != nil {
+		return false, errors.Wrap(err, "when acquiring lease:")
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return true, nil
+	}
+	res, err = db.conn.Exec("update leases set holder=?, expires=? where name=? and (holder=? or expires<?)",
+		holder, exp, name, holder, now.UTC().Format(leaseTimeFormat))
+	if err != nil {
+		return false, errors.Wrap(err, "when acquiring lease:")
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ReleaseLease gives the lease up if it is held by the holder
+func (db *DbConn) ReleaseLease(name string, holder string) error {
+	_, err := db.conn.Exec("delete from leases where name=? and holder=?", name, holder)
+	return err
+}
+
+// Lease returns the current state of the lease, sql.ErrNoRows if nobody has taken it
+func (db *DbConn) Lease(name string) (res Lease, err error) {
+	tmp := struct {
+		Name    string `db:"name"`
+		Holder  string `db:"holder"`
+		Expires string `db:"expires"`
+	}{}
+	if err = db.conn.Get(&tmp, "select name, holder, cast(expires as text) as expires from leases where name=?", name); err != nil {
+		return
+	}
+	res = Lease{Name: tmp.Name, Holder: tmp.Holder}
+	res.Expires, err = time.ParseInLocation(leaseTimeFormat, tmp.Expires, time.UTC)
+	return
+}
@@ -0,0 +1,145 @@
+package dao
+
+import (
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// migration brings the schema one step forward. Migrations are applied in order,
+// the number of applied steps is kept in the user_version pragma of the database.
+type migration func(tx *sqlx.Tx) error
+
+// execSQL makes a migration from a plain SQL script
+func execSQL(script string) migration {
+	return func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(script)
+		return err
+	}
+}
+
+var migrations = []migration{
+	execSQL(`CREATE TABLE IF NOT EXISTS notifications (
+		kind VARCHAR(16) NOT NULL,
+		keyid VARCHAR(10) NOT NULL,
+		feat VARCHAR(16) NOT NULL,
+		threshold INTEGER NOT NULL,
+		"end" DATE NOT NULL,
+		sent TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		PRIMARY KEY (kind, keyid, feat, threshold, "end")
+	);`),
+	execSQL(`CREATE TABLE IF NOT EXISTS clientsettings (
+		orgid INTEGER NOT NULL,
+		gracedays INTEGER DEFAULT 0 NOT NULL,
+		PRIMARY KEY (orgid),
+		FOREIGN KEY(orgid) REFERENCES organizations (id)
+	);`),
+	execSQL(`ALTER TABLE clientsettings ADD COLUMN notifycustomer BOOLEAN DEFAULT 0 NOT NULL;
+	CREATE TABLE IF NOT EXISTS customernotices (
+		orgid INTEGER NOT NULL,
+		sent TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		recipients VARCHAR,
+		FOREIGN KEY(orgid) REFERENCES organizations (id)
+	);`),
+	execSQL(`CREATE TABLE IF NOT EXISTS contacts (
+		id INTEGER NOT NULL,
+		orgid INTEGER NOT NULL,
+		name VARCHAR DEFAULT '' NOT NULL,
+		email VARCHAR DEFAULT '' NOT NULL,
+		phone VARCHAR DEFAULT '' NOT NULL,
+		role VARCHAR(16) DEFAULT 'licadmin' NOT NULL,
+		notifyexpiry BOOLEAN DEFAULT 1 NOT NULL,
+		notifyfiles BOOLEAN DEFAULT 0 NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY(orgid) REFERENCES organizations (id)
+	);`),
+	migrateContacts,
+	execSQL(`CREATE TABLE IF NOT EXISTS outbox (
+		id INTEGER NOT NULL,
+		sender VARCHAR NOT NULL,
+		recipients VARCHAR NOT NULL,
+		subject VARCHAR DEFAULT '' NOT NULL,
+		message BLOB NOT NULL,
+		status VARCHAR(8) DEFAULT 'pending' NOT NULL,
+		attempts INTEGER DEFAULT 0 NOT NULL,
+		lasterror VARCHAR DEFAULT '' NOT NULL,
+		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		nextattempt TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		sent TIMESTAMP,
+		PRIMARY KEY (id)
+	);
+	CREATE INDEX IF NOT EXISTS outbox_due ON outbox (status, nextattempt);`),
+	execSQL(`ALTER TABLE clientsettings ADD COLUMN lang VARCHAR(2) DEFAULT 'en' NOT NULL;`),
+	execSQL(`CREATE TABLE IF NOT EXISTS webhookdeliveries (
+		id INTEGER NOT NULL,
+		url VARCHAR NOT NULL,
+		event VARCHAR(32) NOT NULL,
+		payload BLOB NOT NULL,
+		status VARCHAR(8) DEFAULT 'pending' NOT NULL,
+		attempts INTEGER DEFAULT 0 NOT NULL,
+		responsecode INTEGER DEFAULT 0 NOT NULL,
+		lasterror VARCHAR DEFAULT '' NOT NULL,
+		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		nextattempt TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		delivered TIMESTAMP,
+		PRIMARY KEY (id)
+	);
+	CREATE INDEX IF NOT EXISTS webhookdeliveries_due ON webhookdeliveries (status, nextattempt);`),
+	execSQL(`CREATE TABLE IF NOT EXISTS leases (
+		name VARCHAR(32) NOT NULL,
+		holder VARCHAR NOT NULL,
+		expires TIMESTAMP NOT NULL,
+		PRIMARY KEY (name)
+	);`),
+	execSQL(`ALTER TABLE clientsettings ADD COLUMN manager VARCHAR DEFAULT '' NOT NULL;`),
+	execSQL(`CREATE TABLE IF NOT EXISTS licensesetrevisions (
+		keyid VARCHAR(10) NOT NULL,
+		revision INTEGER DEFAULT 0 NOT NULL,
+		PRIMARY KEY (keyid)
+	);`),
+	execSQL(`CREATE TABLE IF NOT EXISTS keyreplacements (
+		keyid VARCHAR(10) NOT NULL,
+		replacedby VARCHAR(10) NOT NULL,
+		orgid INTEGER NOT NULL,
+		replaced TIMESTAMP NOT NULL,
+		reason VARCHAR DEFAULT '' NOT NULL,
+		PRIMARY KEY (keyid)
+	);
+	CREATE INDEX IF NOT EXISTS keyreplacements_replacedby ON keyreplacements (replacedby);`),
+	// Names of organizations are not unique, the files issued before are left without ID
+	execSQL(`ALTER TABLE history ADD COLUMN orgid INTEGER;`),
+}
+
+// SchemaVersion returns the number of migrations applied to the database
+func (db *DbConn) SchemaVersion() (version int, err error) {
+	err = db.conn.Get(&version, "pragma user_version")
+	return
+}
+
+// Migrate applies the migrations that have not been applied to the database yet
+func (db *DbConn) Migrate() (err error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return errors.Wrap(err, "unable to get schema version:")
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.conn.Beginx()
+		if err != nil {
+			return errors.Wrap(err, "unable to begin transaction in Migrate:")
+		}
+		if err = migrations[i](tx); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "migration %d failed:", i+1)
+		}
+		// pragma does not accept placeholders
+		if _, err = tx.Exec("pragma user_version = " + strconv.Itoa(i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
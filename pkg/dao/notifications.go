@@ -0,0 +1,83 @@
+package dao
+
+import (
+	"time"
+)
+
+// Kinds of notifications tracked in the notifications log
+const (
+	NotificationExpiry = "expiry"
+	NotificationLapsed = "lapsed"
+	// Features reported to webhooks
+	NotificationHookExpiring = "hook-expiring"
+	NotificationHookExpired  = "hook-expired"
+)
+
+type notification struct {
+	Kind      string `db:"kind"`
+	KeyID     string `db:"keyid"`
+	Feature   string `db:"feat"`
+	Threshold int    `db:"threshold"`
+	End       string `db:"end"`
+	Sent      string `db:"sent"`
+}
+
+// Notification is a record of the notifications log: the feature of the key was
+// reported when the term left till its end reached the threshold (in days)
+type Notification struct {
+	Kind      string
+	KeyID     string
+	Feature   string
+	Threshold int
+	End       time.Time
+	Sent      time.Time
+}
+
+// IsNotified checks if the feature has been already reported for the given threshold.
+// The end date is a part of the check, so that a prolonged feature is reported anew.
+func (db *DbConn) IsNotified(kind string, f KeyFeatureExpiry, threshold int) (res bool, err error) {
+	var n int
+	err = db.conn.Get(&n, `select count(*) from notifications where kind=? and keyid=? and feat=? and threshold=? and "end"=?`,
+		kind, f.KeyID, f.Feature, threshold, f.ExpTime.Format("02/01/2006"))
+	return n > 0, err
+}
+
+// MarkNotified stores the fact that features have been reported for the given threshold
+func (db *DbConn) MarkNotified(kind string, features []KeyFeatureExpiry, threshold int, when time.Time) (err error) {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return
+	}
+	for _, f := range features {
+		_, err = tx.Exec(`insert or replace into notifications (kind, keyid, feat, threshold, "end", sent) values (?, ?, ?, ?, ?, ?)`,
+			kind, f.KeyID, f.Feature, threshold, f.ExpTime.Format("02/01/2006"), when.Format("2006-01-02 15:04:05"))
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	err = tx.Commit()
+	return
+}
+
+// NotificationsSince returns notifications sent after the given moment, the most recent first
+func (db *DbConn) NotificationsSince(since time.Time) (res []Notification, err error) {
+	tmp := []notification{}
+	res = []Notification{}
+	err = db.conn.Select(&tmp, `select kind, keyid, feat, threshold, cast("end" as varchar) as "end", cast(sent as text) as sent
+	from notifications where sent >= ? order by sent desc, keyid, feat`, since.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return
+	}
+	for _, n := range tmp {
+		item := Notification{Kind: n.Kind, KeyID: n.KeyID, Feature: n.Feature, Threshold: n.Threshold}
+		if item.End, err = time.Parse("02/01/2006", n.End); err != nil {
+			return
+		}
+		if item.Sent, err = time.Parse("2006-01-02 15:04:05", n.Sent); err != nil {
+			return
+		}
+		res = append(res, item)
+	}
+	return
+}
@@ -0,0 +1,145 @@
+package dao
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Statuses of messages in the outbox
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+type outboxItem struct {
+	Id          int            `db:"id"`
+	Sender      string         `db:"sender"`
+	Recipients  string         `db:"recipients"`
+	Subject     string         `db:"subject"`
+	Message     []byte         `db:"message"`
+	Status      string         `db:"status"`
+	Attempts    int            `db:"attempts"`
+	LastError   string         `db:"lasterror"`
+	Created     string         `db:"created"`
+	NextAttempt string         `db:"nextattempt"`
+	Sent        sql.NullString `db:"sent"`
+}
+
+// OutboxItem is a mail message waiting for delivery or already delivered.
+// Message keeps the complete message as it is sent to the mail server.
+type OutboxItem struct {
+	Id          int
+	Sender      string
+	Recipients  []string
+	Subject     string
+	Message     []byte
+	Status      string
+	Attempts    int
+	LastError   string
+	Created     time.Time
+	NextAttempt time.Time
+	Sent        time.Time
+}
+
+const sqlOutboxColumns = `id, sender, recipients, subject, message, status, attempts, lasterror,
+	cast(created as text) as created, cast(nextattempt as text) as nextattempt, cast(sent as text) as sent`
+
+func (it outboxItem) toOutboxItem() (res OutboxItem, err error) {
+	res = OutboxItem{Id: it.Id, Sender: it.Sender, Recipients: strings.Split(it.Recipients, ","), Subject: it.Subject,
+		Message: it.Message, Status: it.Status, Attempts: it.Attempts, LastError: it.LastError}
+	if res.Created, err = time.Parse("2006-01-02 15:04:05", it.Created); err != nil {
+		return
+	}
+	if res.NextAttempt, err = time.Parse("2006-01-02 15:04:05", it.NextAttempt); err != nil {
+		return
+	}
+	if it.Sent.Valid {
+		res.Sent, err = time.Parse("2006-01-02 15:04:05", it.Sent.String)
+	}
+	return
+}
+
+func (db *DbConn) selectOutbox(query string, args ...interface{}) (res []OutboxItem, err error) {
+	tmp := []outboxItem{}
+	res = []OutboxItem{}
+	if err = db.conn.Select(&tmp, query, args...); err != nil {
+		return
+	}
+	for _, it := range tmp {
+		item, err := it.toOutboxItem()
+		if err != nil {
+			return res, err
+		}
+		res = append(res, item)
+	}
+	return
+}
+
+// Enqueue stores the message in the outbox, the message is to be delivered as soon as possible
+func (db *DbConn) Enqueue(from string, to []string, subject string, msg []byte) (err error) {
+	if len(to) == 0 {
+		return errors.New("message has no recipients")
+	}
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err = db.conn.Exec(`insert into outbox (sender, recipients, subject, message, status, created, nextattempt)
+	values (?, ?, ?, ?, ?, ?, ?)`, from, strings.Join(to, ","), subject, msg, OutboxPending, now, now)
+	return
+}
+
+// OutboxItem returns the outbox message by its ID
+func (db *DbConn) OutboxItem(id int) (res OutboxItem, err error) {
+	tmp := outboxItem{}
+	if err = db.conn.Get(&tmp, "select "+sqlOutboxColumns+" from outbox where id=?", id); err != nil {
+		return
+	}
+	return tmp.toOutboxItem()
+}
+
+// OutboxItems returns at most limit of the most recent outbox messages
+func (db *DbConn) OutboxItems(limit int) (res []OutboxItem, err error) {
+	return db.selectOutbox("select "+sqlOutboxColumns+" from outbox order by id desc limit ?", limit)
+}
+
+// DueOutboxItems returns pending messages whose next delivery attempt is due by now, the oldest first
+func (db *DbConn) DueOutboxItems(now time.Time, limit int) (res []OutboxItem, err error) {
+	return db.selectOutbox("select "+sqlOutboxColumns+" from outbox where status=? and nextattempt <= ? order by id limit ?",
+		OutboxPending, now.Format("2006-01-02 15:04:05"), limit)
+}
+
+// MarkOutboxSent registers successful delivery of the message
+func (db *DbConn) MarkOutboxSent(id int, when time.Time) (err error) {
+	_, err = db.conn.Exec("update outbox set status=?, attempts=attempts+1, lasterror='', sent=? where id=?",
+		OutboxSent, when.Format("2006-01-02 15:04:05"), id)
+	return
+}
+
+// MarkOutboxRetry registers a failed delivery attempt and schedules the next one
+func (db *DbConn) MarkOutboxRetry(id int, lastError string, next time.Time) (err error) {
+	_, err = db.conn.Exec("update outbox set attempts=attempts+1, lasterror=?, nextattempt=? where id=?",
+		lastError, next.Format("2006-01-02 15:04:05"), id)
+	return
+}
+
+// MarkOutboxFailed registers a failed delivery attempt after which no more attempts are made
+func (db *DbConn) MarkOutboxFailed(id int, lastError string) (err error) {
+	_, err = db.conn.Exec("update outbox set status=?, attempts=attempts+1, lasterror=? where id=?",
+		OutboxFailed, lastError, id)
+	return
+}
+
+// ResendOutboxItem puts the message back to the queue, the count of attempts is reset
+func (db *DbConn) ResendOutboxItem(id int, when time.Time) (err error) {
+	res, err := db.conn.Exec("update outbox set status=?, attempts=0, lasterror='', nextattempt=? where id=?",
+		OutboxPending, when.Format("2006-01-02 15:04:05"), id)
+	if err != nil {
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return
+}
@@ -0,0 +1,163 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type keyReplacement struct {
+	KeyID      string `db:"keyid"`
+	ReplacedBy string `db:"replacedby"`
+	OrgID      int    `db:"orgid"`
+	Replaced   string `db:"replaced"`
+	Reason     string `db:"reason"`
+}
+
+// KeyReplacement links the retired key to the key that replaced it. The license set of
+// the retired key has been moved to the new one.
+type KeyReplacement struct {
+	KeyID      string
+	ReplacedBy string
+	OrgID      int
+	Replaced   time.Time
+	Reason     string
+}
+
+const sqlKeyReplacementColumns = `keyid, replacedby, orgid, cast(replaced as text) as replaced, reason`
+
+func (db *DbConn) selectKeyReplacements(query string, args ...interface{}) (res []KeyReplacement, err error) {
+	tmp := []keyReplacement{}
+	res = []KeyReplacement{}
+	if err = db.conn.Select(&tmp, query, args...); err != nil {
+		return
+	}
+	for _, it := range tmp {
+		replaced, err := time.Parse("2006-01-02 15:04:05", it.Replaced)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, KeyReplacement{KeyID: it.KeyID, ReplacedBy: it.ReplacedBy, OrgID: it.OrgID, Replaced: replaced, Reason: it.Reason})
+	}
+	return
+}
+
+// ReplaceKey moves the license set of the key to the new key and retires the key. The
+// new key is registered with the client of the key unless it exists, an existing one
+// must belong to the same client and have no licensed features. ErrStaleRevision is
+// returned if the license set of the key has been changed since the revision given.
+// Created tells if the new key has been registered.
+func (db *DbConn) ReplaceKey(keyID string, newKeyID string, comments string, reason string, revision int, when time.Time) (created bool, err error) {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to begin transaction in ReplaceKey:")
+	}
+	defer tx.Rollback()
+	if keyID == newKeyID {
+		return false, fmt.Errorf("key %s cannot replace itself", keyID)
+	}
+	var orgID int
+	if err = tx.Get(&orgID, "select assigned_org from keys where id=?", keyID); err != nil {
+		return false, fmt.Errorf("invalid key ID %s", keyID)
+	}
+	for _, id := range []string{keyID, newKeyID} {
+		var replacedBy string
+		err = tx.Get(&replacedBy, "select replacedby from keyreplacements where keyid=?", id)
+		if err == nil {
+			return false, fmt.Errorf("key %s is retired, replaced by %s", id, replacedBy)
+		}
+		if err != sql.ErrNoRows {
+			return
+		}
+	}
+	var newOrgID int
+	err = tx.Get(&newOrgID, "select assigned_org from keys where id=?", newKeyID)
+	switch {
+	case err == sql.ErrNoRows:
+		if _, err = tx.Exec("insert into keys (id, assigned_org, comments) values (?, ?, ?)", newKeyID, orgID, comments); err != nil {
+			return false, errors.Wrap(err, "when inserting new key ID:")
+		}
+		created = true
+	case err != nil:
+		return
+	case newOrgID != orgID:
+		return false, fmt.Errorf("key %s belongs to another client", newKeyID)
+	default:
+		var count int
+		if err = tx.Get(&count, "select count(*) from licensesets where keyid=?", newKeyID); err != nil {
+			return
+		}
+		if count > 0 {
+			return false, fmt.Errorf("key %s already has licensed features", newKeyID)
+		}
+	}
+	if err = bumpRevision(tx, keyID, revision); err != nil {
+		return
+	}
+	if err = bumpRevision(tx, newKeyID, AnyRevision); err != nil {
+		return
+	}
+	if _, err = tx.Exec("update licensesets set keyid=? where keyid=?", newKeyID, keyID); err != nil {
+		return
+	}
+	_, err = tx.Exec("insert into keyreplacements (keyid, replacedby, orgid, replaced, reason) values (?, ?, ?, ?, ?)",
+		keyID, newKeyID, orgID, when.UTC().Format("2006-01-02 15:04:05"), reason)
+	if err != nil {
+		return
+	}
+	return created, tx.Commit()
+}
+
+// KeyRetirement returns the replacement of the key, false if the key is not retired
+func (db *DbConn) KeyRetirement(keyID string) (res KeyReplacement, retired bool, err error) {
+	tmp, err := db.selectKeyReplacements("select "+sqlKeyReplacementColumns+" from keyreplacements where keyid=?", keyID)
+	if err != nil || len(tmp) == 0 {
+		return
+	}
+	return tmp[0], true, nil
+}
+
+// KeyReplacements returns the replacements of all the keys, or of the keys of the client
+// if orgID is positive, the most recent first
+func (db *DbConn) KeyReplacements(orgID int) (res []KeyReplacement, err error) {
+	if orgID > 0 {
+		return db.selectKeyReplacements("select "+sqlKeyReplacementColumns+" from keyreplacements where orgid=? order by replaced desc", orgID)
+	}
+	return db.selectKeyReplacements("select " + sqlKeyReplacementColumns + " from keyreplacements order by replaced desc")
+}
+
+// ReplacementChain returns the replacements the key takes part in, from the replacement
+// of the first key of the chain to the replacement of its last retired key
+func (db *DbConn) ReplacementChain(keyID string) (res []KeyReplacement, err error) {
+	res = []KeyReplacement{}
+	// A key is retired once, but several keys may have been replaced by the same one,
+	// the earliest of them is followed then; seen guards against loops
+	seen := map[string]bool{keyID: true}
+	for id := keyID; ; {
+		tmp, err := db.selectKeyReplacements("select "+sqlKeyReplacementColumns+" from keyreplacements where replacedby=? order by replaced", id)
+		if err != nil {
+			return res, err
+		}
+		if len(tmp) == 0 || seen[tmp[0].KeyID] {
+			break
+		}
+		res = append([]KeyReplacement{tmp[0]}, res...)
+		id = tmp[0].KeyID
+		seen[id] = true
+	}
+	for id := keyID; ; {
+		tmp, retired, err := db.KeyRetirement(id)
+		if err != nil {
+			return res, err
+		}
+		if !retired || seen[tmp.ReplacedBy] {
+			break
+		}
+		res = append(res, tmp)
+		id = tmp.ReplacedBy
+		seen[id] = true
+	}
+	return
+}
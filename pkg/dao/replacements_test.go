@@ -0,0 +1,75 @@
+package dao_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+func TestReplaceKey(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	when := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+
+	created, err := db.ReplaceKey("123abc", "new1", "HASP HL", "broken", 0, when)
+	assert.Nil(t, err)
+	assert.True(t, created)
+	org, _ := db.KeyOfWhichOrg("new1")
+	assert.Equal(t, 1, org.Id)
+	moved, _ := db.LicensesSetByKeyId("new1")
+	assert.Equal(t, len(licSet), len(moved))
+	old, _ := db.LicensesSetByKeyId("123abc")
+	assert.Empty(t, old)
+	r, retired, err := db.KeyRetirement("123abc")
+	assert.Nil(t, err)
+	assert.True(t, retired)
+	assert.Equal(t, dao.KeyReplacement{KeyID: "123abc", ReplacedBy: "new1", OrgID: 1, Replaced: when, Reason: "broken"}, r)
+	_, retired, _ = db.KeyRetirement("new1")
+	assert.False(t, retired)
+
+	// Retired keys keep no features
+	violations, _ := db.ValidateLicenseSets(map[string][]dao.LicenseSetItem{"123abc": licSet}, nil)
+	assert.Equal(t, dao.FieldError{KeyID: "123abc", Field: "keyId", Message: "key is retired, replaced by new1"}, violations[0])
+
+	for _, tc := range []struct {
+		keyID    string
+		newKeyID string
+		revision int
+		msg      string
+	}{
+		{"123abc", "new2", dao.AnyRevision, "key 123abc is retired, replaced by new1"},
+		{"new1", "123abc", dao.AnyRevision, "key 123abc is retired, replaced by new1"},
+		{"new1", "new1", dao.AnyRevision, "key new1 cannot replace itself"},
+		{"new1", "123cbc", dao.AnyRevision, "key 123cbc belongs to another client"},
+		{"new1", "123bbc", dao.AnyRevision, "key 123bbc already has licensed features"},
+		{"nokey", "new2", dao.AnyRevision, "invalid key ID nokey"},
+		{"new1", "new2", 0, dao.ErrStaleRevision.Error()},
+	} {
+		_, err := db.ReplaceKey(tc.keyID, tc.newKeyID, "", "", tc.revision, when)
+		if assert.NotNil(t, err, tc) {
+			assert.Equal(t, tc.msg, err.Error(), tc)
+		}
+	}
+	_, err = db.LicensesSetByKeyId("new2")
+	assert.Nil(t, err)
+	keys, _ := db.KeysOfOrg(1)
+	assert.Equal(t, 3, len(keys), "nothing is created by the failed replacements")
+
+	revision, _ := db.LicenseSetRevision("new1")
+	_, err = db.ReplaceKey("new1", "new2", "", "lost", revision, when.AddDate(0, 1, 0))
+	assert.Nil(t, err)
+	chain, err := db.ReplacementChain("new1")
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(chain)) {
+		assert.Equal(t, "123abc", chain[0].KeyID)
+		assert.Equal(t, "new2", chain[1].ReplacedBy)
+	}
+	chain, _ = db.ReplacementChain("123cbc")
+	assert.Empty(t, chain)
+	all, _ := db.KeyReplacements(1)
+	assert.Equal(t, 2, len(all))
+	all, _ = db.KeyReplacements(2)
+	assert.Empty(t, all)
+}
@@ -0,0 +1,58 @@
+package dao
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// AnyRevision makes the license set updates skip the revision check
+const AnyRevision = -1
+
+// ErrStaleRevision is returned when the license set has been changed since the
+// revision the update was based on
+var ErrStaleRevision = errors.New("license set has been changed by someone else")
+
+// LicenseSetRevision returns the revision of the license set of the key, it grows by
+// one with every change of the set. Keys whose sets have never been changed here are
+// at revision 0.
+func (db *DbConn) LicenseSetRevision(keyID string) (revision int, err error) {
+	err = db.conn.Get(&revision, "select revision from licensesetrevisions where keyid=?", keyID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return
+}
+
+// bumpRevision moves the license set of the key to the next revision provided it is
+// still at the given one
+func bumpRevision(tx *sqlx.Tx, keyID string, revision int) error {
+	var res sql.Result
+	var err error
+	if revision == AnyRevision {
+		res, err = tx.Exec("update licensesetrevisions set revision=revision+1 where keyid=?", keyID)
+	} else {
+		res, err = tx.Exec("update licensesetrevisions set revision=revision+1 where keyid=? and revision=?", keyID, revision)
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	if revision > 0 {
+		return ErrStaleRevision
+	}
+	var current int
+	err = tx.Get(&current, "select revision from licensesetrevisions where keyid=?", keyID)
+	if err == nil {
+		return ErrStaleRevision
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	_, err = tx.Exec("insert into licensesetrevisions (keyid, revision) values (?, 1)", keyID)
+	return err
+}
@@ -0,0 +1,50 @@
+package dao_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+func TestLicenseSetRevision(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	revision, err := db.LicenseSetRevision("123abc")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, revision)
+
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+	assert.Nil(t, db.UpdateLicenseSetAtRevision("123abc", 0, licSet))
+	assert.Equal(t, dao.ErrStaleRevision, db.UpdateLicenseSetAtRevision("123abc", 0, licSet[:1]))
+	assert.Nil(t, db.ChangeLicenseSetItems("123abc", 1, nil, []string{licSet[0].Feature}))
+	assert.Equal(t, dao.ErrStaleRevision, db.ChangeLicenseSetItems("123abc", 1, licSet, nil))
+	revision, _ = db.LicenseSetRevision("123abc")
+	assert.Equal(t, 2, revision)
+	res, _ := db.LicensesSetByKeyId("123abc")
+	assert.Equal(t, 1, len(res), "the stale updates must not change the license set")
+
+	// The unconditional updates bump the revision too
+	assert.Nil(t, db.UpdateLicenseSets(map[string][]dao.LicenseSetItem{"123abc": licSet, "123cbc": {}}))
+	revision, _ = db.LicenseSetRevision("123abc")
+	assert.Equal(t, 3, revision)
+	revision, _ = db.LicenseSetRevision("123cbc")
+	assert.Equal(t, 1, revision)
+}
+
+func TestUpdateLicenseSetsAtRevision(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	abc, _ := db.LicensesSetByKeyId("123abc")
+	bbc, _ := db.LicensesSetByKeyId("123bbc")
+	assert.Nil(t, db.UpdateLicenseSetAtRevision("123bbc", 0, bbc))
+	sets := map[string][]dao.LicenseSetItem{"123abc": abc[:1], "123bbc": bbc[:1]}
+	assert.Equal(t, dao.ErrStaleRevision, db.UpdateLicenseSetsAtRevision(sets, map[string]int{"123abc": 0, "123bbc": 0}))
+	assert.Equal(t, dao.ErrStaleRevision, db.UpdateLicenseSetsAtRevision(sets, map[string]int{"123abc": 0}))
+	res, _ := db.LicensesSetByKeyId("123abc")
+	assert.Equal(t, len(abc), len(res), "no set is changed if any of them is stale")
+	revision, _ := db.LicenseSetRevision("123abc")
+	assert.Equal(t, 0, revision)
+
+	assert.Nil(t, db.UpdateLicenseSetsAtRevision(sets, map[string]int{"123abc": 0, "123bbc": 1}))
+	revision, _ = db.LicenseSetRevision("123bbc")
+	assert.Equal(t, 2, revision)
+}
@@ -0,0 +1,88 @@
+package dao
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// ClientSettings keeps per-client parameters of license management
+type ClientSettings struct {
+	OrgId          int    `db:"orgid"`
+	GraceDays      int    `db:"gracedays"`
+	NotifyCustomer bool   `db:"notifycustomer"`
+	Lang           string `db:"lang"`
+	Manager        string `db:"manager"`
+}
+
+// DefaultLang is the language of mail sent to clients that have no settings stored
+const DefaultLang = "en"
+
+func defaultClientSettings(orgID int) ClientSettings {
+	return ClientSettings{OrgId: orgID, Lang: DefaultLang}
+}
+
+// ClientSettings returns settings of the client. Default settings are returned
+// for clients that have no settings stored.
+func (db *DbConn) ClientSettings(orgID int) (res ClientSettings, err error) {
+	err = db.conn.Get(&res, "select orgid, gracedays, notifycustomer, lang, manager from clientsettings where orgid=?", orgID)
+	if err == sql.ErrNoRows {
+		return defaultClientSettings(orgID), nil
+	}
+	return
+}
+
+// ClientSettingsMap maps client IDs to their settings
+type ClientSettingsMap map[int]ClientSettings
+
+// Get returns settings of the client, or default settings if none are stored
+func (m ClientSettingsMap) Get(orgID int) ClientSettings {
+	if s, ok := m[orgID]; ok {
+		return s
+	}
+	return defaultClientSettings(orgID)
+}
+
+// AllClientSettings returns all the stored client settings
+func (db *DbConn) AllClientSettings() (res ClientSettingsMap, err error) {
+	tmp := []ClientSettings{}
+	res = ClientSettingsMap{}
+	err = db.conn.Select(&tmp, "select orgid, gracedays, notifycustomer, lang, manager from clientsettings")
+	for _, s := range tmp {
+		res[s.OrgId] = s
+	}
+	return
+}
+
+// SetClientSettings creates or replaces settings of the client
+func (db *DbConn) SetClientSettings(s ClientSettings) (err error) {
+	if _, err = db.ClientNameByID(s.OrgId); err != nil {
+		return err
+	}
+	if s.Lang == "" {
+		s.Lang = DefaultLang
+	}
+	_, err = db.conn.Exec("insert or replace into clientsettings (orgid, gracedays, notifycustomer, lang, manager) values (?, ?, ?, ?, ?)",
+		s.OrgId, s.GraceDays, s.NotifyCustomer, s.Lang, s.Manager)
+	return
+}
+
+// LastCustomerNotice returns the time the client was sent an expiry notice the last time,
+// zero time if the client has never been notified
+func (db *DbConn) LastCustomerNotice(orgID int) (res time.Time, err error) {
+	var last sql.NullString
+	if err = db.conn.Get(&last, "select cast(max(sent) as text) from customernotices where orgid=?", orgID); err != nil {
+		return
+	}
+	if !last.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02 15:04:05", last.String)
+}
+
+// AddCustomerNotice registers an expiry notice sent to the client
+func (db *DbConn) AddCustomerNotice(orgID int, when time.Time, recipients []string) (err error) {
+	_, err = db.conn.Exec("insert into customernotices (orgid, sent, recipients) values (?, ?, ?)",
+		orgID, when.Format("2006-01-02 15:04:05"), strings.Join(recipients, ", "))
+	return
+}
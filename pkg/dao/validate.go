@@ -0,0 +1,164 @@
+package dao
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Limits of feature versions, both exclusive
+const (
+	MinFeatureVersion = 0.0
+	MaxFeatureVersion = 100.0
+)
+
+// FieldError is a violation of the license set constraints. Field is the path of the
+// offending value in the license set of the key, e.g. features[1].end, or keyId.
+type FieldError struct {
+	KeyID   string
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.KeyID, e.Field, e.Message)
+}
+
+// ValidateLicenseSets checks the license sets before they replace the current ones and
+// returns all the violations found: the key must exist and not be retired, the features
+// must exist and be listed once, neither be covered by a package of the same set, start
+// must be before end, count must be positive and not exceed maxCount of the feature
+// (unless it is nil or returns 0) and version must be within the limits. Violations the
+// current license set of the key already has are not reported, so that the keys having
+// them can still be changed otherwise. The error is only returned if the checks cannot
+// be made.
+func (db *DbConn) ValidateLicenseSets(sets map[string][]LicenseSetItem, maxCount func(feature string) int) (res []FieldError, err error) {
+	res = []FieldError{}
+	keys, err := db.Keys()
+	if err != nil {
+		return
+	}
+	knownKeys := map[string]bool{}
+	for _, k := range keys {
+		knownKeys[k.Id] = true
+	}
+	retired := map[string]string{}
+	replacements, err := db.KeyReplacements(0)
+	if err != nil {
+		return
+	}
+	for _, r := range replacements {
+		retired[r.KeyID] = r.ReplacedBy
+	}
+	features, err := db.Features()
+	if err != nil {
+		return
+	}
+	v := &licenseSetValidator{db: db, isPackage: map[string]bool{}, packageContent: map[string][]PackageContentItem{}, maxCount: maxCount}
+	for _, f := range features {
+		v.isPackage[f.Feature] = f.IsPackage
+	}
+
+	keyIDs := []string{}
+	for keyID := range sets {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	for _, keyID := range keyIDs {
+		licSet := sets[keyID]
+		if !knownKeys[keyID] {
+			res = append(res, FieldError{KeyID: keyID, Field: "keyId", Message: "key is not registered"})
+		} else if replacedBy, ok := retired[keyID]; ok && len(licSet) > 0 {
+			res = append(res, FieldError{KeyID: keyID, Field: "keyId", Message: "key is retired, replaced by " + replacedBy})
+		}
+		current, err := db.LicensesSetByKeyId(keyID)
+		if err != nil {
+			return res, err
+		}
+		existing := map[string]bool{}
+		found, err := v.check(keyID, current)
+		if err != nil {
+			return res, err
+		}
+		for _, f := range found {
+			existing[f.id()] = true
+		}
+		if found, err = v.check(keyID, licSet); err != nil {
+			return res, err
+		}
+		for _, f := range found {
+			if !existing[f.id()] {
+				res = append(res, f.FieldError)
+			}
+		}
+	}
+	return
+}
+
+// violation is the field error of the feature, attr is the name of the offending value
+type violation struct {
+	FieldError
+	feature string
+	attr    string
+}
+
+// id identifies the violation regardless of the position of the feature in the set
+func (v violation) id() string {
+	return v.feature + "\x00" + v.attr + "\x00" + v.Message
+}
+
+type licenseSetValidator struct {
+	db             *DbConn
+	isPackage      map[string]bool
+	packageContent map[string][]PackageContentItem
+	maxCount       func(feature string) int
+}
+
+// check returns the violations of the features of the license set
+func (v *licenseSetValidator) check(keyID string, licSet []LicenseSetItem) (res []violation, err error) {
+	seen := map[string]bool{}
+	coveredBy := map[string]string{}
+	for _, f := range licSet {
+		if !v.isPackage[f.Feature] {
+			continue
+		}
+		if _, ok := v.packageContent[f.Feature]; !ok {
+			if v.packageContent[f.Feature], err = v.db.PackageContent(f.Feature); err != nil {
+				return
+			}
+		}
+		for _, item := range v.packageContent[f.Feature] {
+			coveredBy[item.Feature] = f.Feature
+		}
+	}
+	for i, f := range licSet {
+		add := func(attr string, msg string) {
+			res = append(res, violation{FieldError: FieldError{KeyID: keyID, Field: fmt.Sprintf("features[%d].%s", i, attr), Message: msg},
+				feature: f.Feature, attr: attr})
+		}
+		if _, ok := v.isPackage[f.Feature]; !ok {
+			add("feature", fmt.Sprintf("feature %s does not exist", f.Feature))
+		} else if seen[f.Feature] {
+			add("feature", fmt.Sprintf("feature %s is listed more than once", f.Feature))
+		} else if pkg, ok := coveredBy[f.Feature]; ok {
+			add("feature", fmt.Sprintf("feature %s is also covered by package %s", f.Feature, pkg))
+		}
+		seen[f.Feature] = true
+		if f.KeyID != keyID {
+			add("keyId", fmt.Sprintf("feature belongs to key %s", f.KeyID))
+		}
+		if !f.Start.Before(f.End) {
+			add("end", "end must be after start")
+		}
+		if f.Count <= 0 {
+			add("count", "count must be positive")
+		} else if v.maxCount != nil {
+			if max := v.maxCount(f.Feature); max > 0 && f.Count > max {
+				add("count", fmt.Sprintf("count %d exceeds the maximum of %d", f.Count, max))
+			}
+		}
+		if f.Version <= MinFeatureVersion || f.Version >= MaxFeatureVersion {
+			add("version", fmt.Sprintf("version must be greater than %.0f and less than %.0f", MinFeatureVersion, MaxFeatureVersion))
+		}
+	}
+	return
+}
@@ -0,0 +1,98 @@
+package dao_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+func TestValidateLicenseSets(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	res, err := db.ValidateLicenseSets(map[string][]dao.LicenseSetItem{
+		"123abc": {
+			{KeyID: "123abc", Feature: "P1", Version: 19, Count: 1, Start: start, End: end},
+			{KeyID: "123abc", Feature: "F3", Version: 19, Count: 1, Start: start, End: end},
+		},
+		"123cbc": {},
+	}, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+
+	res, err = db.ValidateLicenseSets(map[string][]dao.LicenseSetItem{
+		"123abc": {
+			{KeyID: "123abc", Feature: "P1", Version: 19, Count: 1, Start: start, End: end},
+			{KeyID: "123abc", Feature: "F1", Version: 19, Count: 1, Start: start, End: end},
+			{KeyID: "123abc", Feature: "F3", Version: 0, Count: 0, Start: end, End: start},
+			{KeyID: "123abc", Feature: "F3", Version: 100, Count: 1, Start: start, End: end},
+			{KeyID: "123bbc", Feature: "NOPE", Version: 19, Count: 1, Start: start, End: end},
+		},
+		"nokey": {},
+	}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []dao.FieldError{
+		{KeyID: "123abc", Field: "features[1].feature", Message: "feature F1 is also covered by package P1"},
+		{KeyID: "123abc", Field: "features[2].end", Message: "end must be after start"},
+		{KeyID: "123abc", Field: "features[2].count", Message: "count must be positive"},
+		{KeyID: "123abc", Field: "features[2].version", Message: "version must be greater than 0 and less than 100"},
+		{KeyID: "123abc", Field: "features[3].feature", Message: "feature F3 is listed more than once"},
+		{KeyID: "123abc", Field: "features[3].version", Message: "version must be greater than 0 and less than 100"},
+		{KeyID: "123abc", Field: "features[4].feature", Message: "feature NOPE does not exist"},
+		{KeyID: "123abc", Field: "features[4].keyId", Message: "feature belongs to key 123bbc"},
+		{KeyID: "nokey", Field: "keyId", Message: "key is not registered"},
+	}, res)
+}
+
+func TestValidateLicenseSetsExisting(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	// F1 of 123bbc is already covered by P1, the key still may be changed otherwise
+	licSet, _ := db.LicensesSetByKeyId("123bbc")
+	for i := range licSet {
+		licSet[i].Count = 25
+		licSet[i].End = licSet[i].End.AddDate(1, 0, 0)
+	}
+	res, err := db.ValidateLicenseSets(map[string][]dao.LicenseSetItem{"123bbc": licSet}, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+
+	// The new violations are reported
+	licSet = append(licSet, dao.LicenseSetItem{KeyID: "123bbc", Feature: "F2", Version: 19, Count: 0, Start: licSet[0].Start, End: licSet[0].End})
+	res, err = db.ValidateLicenseSets(map[string][]dao.LicenseSetItem{"123bbc": licSet}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []dao.FieldError{
+		{KeyID: "123bbc", Field: "features[3].feature", Message: "feature F2 is also covered by package P1"},
+		{KeyID: "123bbc", Field: "features[3].count", Message: "count must be positive"},
+	}, res)
+}
+
+func TestValidateLicenseSetsMaxCount(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	maxCount := func(feature string) int {
+		if feature == "P1" {
+			return 25
+		}
+		return 0
+	}
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+	for i := range licSet {
+		licSet[i].Count = 26
+	}
+	res, err := db.ValidateLicenseSets(map[string][]dao.LicenseSetItem{"123abc": licSet}, maxCount)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(res)) {
+		assert.Equal(t, "count 26 exceeds the maximum of 25", res[0].Message)
+	}
+
+	// The counts exceeding the maximum already are only checked if they are changed
+	assert.Nil(t, db.UpdateLicenseSet("123abc", licSet))
+	for i := range licSet {
+		licSet[i].End = licSet[i].End.AddDate(1, 0, 0)
+	}
+	res, err = db.ValidateLicenseSets(map[string][]dao.LicenseSetItem{"123abc": licSet}, maxCount)
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+}
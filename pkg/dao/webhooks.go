@@ -0,0 +1,132 @@
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+type webhookDelivery struct {
+	Id           int            `db:"id"`
+	URL          string         `db:"url"`
+	Event        string         `db:"event"`
+	Payload      []byte         `db:"payload"`
+	Status       string         `db:"status"`
+	Attempts     int            `db:"attempts"`
+	ResponseCode int            `db:"responsecode"`
+	LastError    string         `db:"lasterror"`
+	Created      string         `db:"created"`
+	NextAttempt  string         `db:"nextattempt"`
+	Delivered    sql.NullString `db:"delivered"`
+}
+
+// WebhookDelivery is an event to be posted to the webhook URL. The statuses are
+// the same as the ones of the outbox messages.
+type WebhookDelivery struct {
+	Id           int
+	URL          string
+	Event        string
+	Payload      []byte
+	Status       string
+	Attempts     int
+	ResponseCode int
+	LastError    string
+	Created      time.Time
+	NextAttempt  time.Time
+	Delivered    time.Time
+}
+
+const sqlWebhookColumns = `id, url, event, payload, status, attempts, responsecode, lasterror,
+	cast(created as text) as created, cast(nextattempt as text) as nextattempt, cast(delivered as text) as delivered`
+
+func (d webhookDelivery) toWebhookDelivery() (res WebhookDelivery, err error) {
+	res = WebhookDelivery{Id: d.Id, URL: d.URL, Event: d.Event, Payload: d.Payload, Status: d.Status, Attempts: d.Attempts,
+		ResponseCode: d.ResponseCode, LastError: d.LastError}
+	if res.Created, err = time.Parse("2006-01-02 15:04:05", d.Created); err != nil {
+		return
+	}
+	if res.NextAttempt, err = time.Parse("2006-01-02 15:04:05", d.NextAttempt); err != nil {
+		return
+	}
+	if d.Delivered.Valid {
+		res.Delivered, err = time.Parse("2006-01-02 15:04:05", d.Delivered.String)
+	}
+	return
+}
+
+func (db *DbConn) selectWebhookDeliveries(query string, args ...interface{}) (res []WebhookDelivery, err error) {
+	tmp := []webhookDelivery{}
+	res = []WebhookDelivery{}
+	if err = db.conn.Select(&tmp, query, args...); err != nil {
+		return
+	}
+	for _, d := range tmp {
+		item, err := d.toWebhookDelivery()
+		if err != nil {
+			return res, err
+		}
+		res = append(res, item)
+	}
+	return
+}
+
+// EnqueueWebhook stores the event to be posted to the URL as soon as possible
+func (db *DbConn) EnqueueWebhook(url string, event string, payload []byte) (err error) {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err = db.conn.Exec(`insert into webhookdeliveries (url, event, payload, status, created, nextattempt)
+	values (?, ?, ?, ?, ?, ?)`, url, event, payload, OutboxPending, now, now)
+	return
+}
+
+// WebhookDelivery returns the delivery by its ID
+func (db *DbConn) WebhookDelivery(id int) (res WebhookDelivery, err error) {
+	tmp := webhookDelivery{}
+	if err = db.conn.Get(&tmp, "select "+sqlWebhookColumns+" from webhookdeliveries where id=?", id); err != nil {
+		return
+	}
+	return tmp.toWebhookDelivery()
+}
+
+// WebhookDeliveries returns at most limit of the most recent deliveries
+func (db *DbConn) WebhookDeliveries(limit int) (res []WebhookDelivery, err error) {
+	return db.selectWebhookDeliveries("select "+sqlWebhookColumns+" from webhookdeliveries order by id desc limit ?", limit)
+}
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is due by now, the oldest first
+func (db *DbConn) DueWebhookDeliveries(now time.Time, limit int) (res []WebhookDelivery, err error) {
+	return db.selectWebhookDeliveries("select "+sqlWebhookColumns+" from webhookdeliveries where status=? and nextattempt <= ? order by id limit ?",
+		OutboxPending, now.Format("2006-01-02 15:04:05"), limit)
+}
+
+// MarkWebhookDelivered registers successful delivery of the event
+func (db *DbConn) MarkWebhookDelivered(id int, responseCode int, when time.Time) (err error) {
+	_, err = db.conn.Exec("update webhookdeliveries set status=?, attempts=attempts+1, responsecode=?, lasterror='', delivered=? where id=?",
+		OutboxSent, responseCode, when.Format("2006-01-02 15:04:05"), id)
+	return
+}
+
+// MarkWebhookRetry registers a failed delivery attempt and schedules the next one
+func (db *DbConn) MarkWebhookRetry(id int, responseCode int, lastError string, next time.Time) (err error) {
+	_, err = db.conn.Exec("update webhookdeliveries set attempts=attempts+1, responsecode=?, lasterror=?, nextattempt=? where id=?",
+		responseCode, lastError, next.Format("2006-01-02 15:04:05"), id)
+	return
+}
+
+// MarkWebhookFailed registers a failed delivery attempt after which no more attempts are made
+func (db *DbConn) MarkWebhookFailed(id int, responseCode int, lastError string) (err error) {
+	_, err = db.conn.Exec("update webhookdeliveries set status=?, attempts=attempts+1, responsecode=?, lasterror=? where id=?",
+		OutboxFailed, responseCode, lastError, id)
+	return
+}
+
+// RedeliverWebhook puts the delivery back to the queue, the count of attempts is reset
+func (db *DbConn) RedeliverWebhook(id int, when time.Time) (err error) {
+	res, err := db.conn.Exec("update webhookdeliveries set status=?, attempts=0, lasterror='', nextattempt=? where id=?",
+		OutboxPending, when.Format("2006-01-02 15:04:05"), id)
+	if err != nil {
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return
+}
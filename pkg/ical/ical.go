@@ -0,0 +1,84 @@
+// Package ical writes iCalendar (RFC 5545) feeds of all-day events
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ContentType is the MIME type of iCalendar feeds
+const ContentType = "text/calendar; charset=utf-8"
+
+// maxLineLen is the maximum length of a content line in octets, longer lines are folded
+const maxLineLen = 75
+
+// Event is an all-day event
+type Event struct {
+	UID         string
+	Date        time.Time
+	Summary     string
+	Description string
+	URL         string
+}
+
+// Calendar is a named list of events
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// WriteTo writes the calendar in the iCalendar format, stamp is the time the feed is made at
+func (cal Calendar) WriteTo(w io.Writer, stamp time.Time) error {
+	buf := &bytes.Buffer{}
+	writeLine(buf, "BEGIN:VCALENDAR")
+	writeLine(buf, "VERSION:2.0")
+	writeLine(buf, "PRODID:-//Pangea//PNG License Manager//EN")
+	writeLine(buf, "CALSCALE:GREGORIAN")
+	writeLine(buf, "METHOD:PUBLISH")
+	if cal.Name != "" {
+		writeLine(buf, "X-WR-CALNAME:"+Escape(cal.Name))
+	}
+	for _, e := range cal.Events {
+		writeLine(buf, "BEGIN:VEVENT")
+		writeLine(buf, "UID:"+e.UID)
+		writeLine(buf, "DTSTAMP:"+stamp.UTC().Format("20060102T150405Z"))
+		writeLine(buf, "DTSTART;VALUE=DATE:"+e.Date.Format("20060102"))
+		writeLine(buf, "DTEND;VALUE=DATE:"+e.Date.AddDate(0, 0, 1).Format("20060102"))
+		writeLine(buf, "SUMMARY:"+Escape(e.Summary))
+		if e.Description != "" {
+			writeLine(buf, "DESCRIPTION:"+Escape(e.Description))
+		}
+		if e.URL != "" {
+			writeLine(buf, "URL:"+e.URL)
+		}
+		writeLine(buf, "TRANSP:TRANSPARENT")
+		writeLine(buf, "END:VEVENT")
+	}
+	writeLine(buf, "END:VCALENDAR")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Escape escapes the special characters of a text value
+func Escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// writeLine writes the content line terminated with CRLF, folding it so
+// that no line is longer than 75 octets and no UTF-8 sequence is split
+func writeLine(buf *bytes.Buffer, line string) {
+	lineLen := 0
+	for _, r := range line {
+		l := len(string(r))
+		if lineLen+l > maxLineLen {
+			buf.WriteString("\r\n ")
+			lineLen = 1
+		}
+		buf.WriteRune(r)
+		lineLen += l
+	}
+	fmt.Fprint(buf, "\r\n")
+}
@@ -0,0 +1,38 @@
+package ical_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/ical"
+)
+
+func TestWriteTo(t *testing.T) {
+	cal := ical.Calendar{Name: "Expirations", Events: []ical.Event{{
+		UID:         "123abc-20080708@localhost",
+		Date:        time.Date(2008, 7, 8, 0, 0, 0, 0, time.UTC),
+		Summary:     "Org 1: key 123abc, features F1, F2",
+		Description: "Features:\n" + strings.Repeat("ОПЦИЯ; ", 20),
+	}}}
+	buf := &bytes.Buffer{}
+	assert.Nil(t, cal.WriteTo(buf, time.Date(2008, 6, 1, 10, 0, 0, 0, time.UTC)))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\n"))
+	assert.True(t, strings.HasSuffix(out, "END:VCALENDAR\r\n"))
+	assert.Contains(t, out, "DTSTAMP:20080601T100000Z\r\n")
+	assert.Contains(t, out, "DTSTART;VALUE=DATE:20080708\r\nDTEND;VALUE=DATE:20080709\r\n")
+	assert.Contains(t, out, `SUMMARY:Org 1: key 123abc\, features F1\, F2`)
+	assert.Contains(t, out, `DESCRIPTION:Features:\nОПЦИЯ\; `)
+	for _, line := range strings.Split(out, "\r\n") {
+		assert.True(t, len(line) <= 75, line)
+	}
+	// Folded lines are unfolded by removing CRLF followed by a space
+	assert.Contains(t, strings.Replace(out, "\r\n ", "", -1), strings.Repeat(`ОПЦИЯ\; `, 20))
+}
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, `a\\b\;c\,d\ne`, ical.Escape("a\\b;c,d\ne"))
+}
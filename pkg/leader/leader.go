@@ -0,0 +1,179 @@
+// Package leader elects one of the server instances sharing the database to run
+// background work (scheduled jobs, mail and webhook delivery). The leader holds a
+// lease in the database and renews it periodically. If the leader disappears the
+// lease expires and another instance takes over.
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// DefaultTTL is the time the lease is taken for
+const DefaultTTL = 30 * time.Second
+
+// Elector takes part in the election of the leader
+type Elector struct {
+	db   *dao.DbConn
+	name string
+	// ID identifies the instance in the lease
+	ID string
+	// TTL is the time the lease is taken for, it is renewed every TTL/3
+	TTL time.Duration
+
+	mu     sync.Mutex
+	leader bool
+}
+
+// New returns elector for the lease of the given name. The instance is identified
+// by the host name and the process ID.
+func New(db *dao.DbConn, name string) *Elector {
+	return &Elector{db: db, name: name, ID: instanceID(), TTL: DefaultTTL}
+}
+
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	// A random suffix tells apart processes that got the same PID in containers
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// IsLeader tells if the instance holds the lease now
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Leader returns the ID of the instance holding the lease, sql.ErrNoRows if nobody has taken it
+func (e *Elector) Leader() (string, error) {
+	lease, err := e.db.Lease(e.name)
+	return lease.Holder, err
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leader = leader
+}
+
+// TryAcquire takes or renews the lease
+func (e *Elector) TryAcquire(now time.Time) (bool, error) {
+	return e.db.AcquireLease(e.name, e.ID, now, now.Add(e.TTL))
+}
+
+// Release gives the lease up if the instance holds it
+func (e *Elector) Release() error {
+	e.setLeader(false)
+	return e.db.ReleaseLease(e.name, e.ID)
+}
+
+// Run takes part in the election until the context is canceled. Every time the
+// instance becomes the leader, lead is called with a context that is canceled as
+// soon as the lease is lost. Run waits for lead to return before trying to take
+// the lease again, and releases the lease when it returns.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	ticker := time.NewTicker(e.TTL / 3)
+	defer ticker.Stop()
+	var stopLeading context.CancelFunc
+	var done chan struct{}
+	step := func() {
+		if stopLeading == nil {
+			return
+		}
+		stopLeading()
+		<-done
+		stopLeading = nil
+		e.setLeader(false)
+		log.Printf("Instance %s is not the leader of %s any more", e.ID, e.name)
+	}
+	for {
+		ok, err := e.TryAcquire(time.Now())
+		if err != nil {
+			// The lease may expire before the database is back, step down to be safe
+			log.Println("Error when renewing the lease: ", err)
+			ok = false
+		}
+		switch {
+		case ok && stopLeading == nil:
+			log.Printf("Instance %s is the leader of %s", e.ID, e.name)
+			e.setLeader(true)
+			var leaderCtx context.Context
+			leaderCtx, stopLeading = context.WithCancel(ctx)
+			done = make(chan struct{})
+			go func() {
+				defer close(done)
+				lead(leaderCtx)
+			}()
+		case !ok:
+			step()
+		}
+		select {
+		case <-ctx.Done():
+			wasLeader := stopLeading != nil
+			step()
+			if wasLeader {
+				if err := e.Release(); err != nil {
+					log.Println("Error when releasing the lease: ", err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ErrLeaseLost is returned by RunOnce when the lease is lost before the work is done
+var ErrLeaseLost = errors.New("the lease has been lost")
+
+// RunOnce takes the lease and calls work while holding it, the lease is renewed
+// every TTL/3 until work returns and is released then. The context passed to work
+// is canceled as soon as the lease is lost. False is returned and work is not
+// called if another instance holds the lease.
+func (e *Elector) RunOnce(ctx context.Context, work func(ctx context.Context)) (bool, error) {
+	ok, err := e.TryAcquire(time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+	e.setLeader(true)
+	workCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		work(workCtx)
+	}()
+	ticker := time.NewTicker(e.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return true, e.Release()
+		case <-ticker.C:
+			ok, err := e.TryAcquire(time.Now())
+			if err != nil {
+				log.Println("Error when renewing the lease: ", err)
+			}
+			if err != nil || !ok {
+				stop()
+				<-done
+				e.setLeader(false)
+				log.Printf("Instance %s is not the leader of %s any more", e.ID, e.name)
+				return true, ErrLeaseLost
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package leader_test
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/leader"
+)
+
+func TestTryAcquire(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	a := leader.New(db, "background")
+	b := leader.New(db, "background")
+	assert.NotEqual(t, a.ID, b.ID)
+	now := time.Now()
+	ok, err := a.TryAcquire(now)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, _ = b.TryAcquire(now.Add(a.TTL / 2))
+	assert.False(t, ok)
+	// Renewed by the holder
+	ok, _ = a.TryAcquire(now.Add(a.TTL / 2))
+	assert.True(t, ok)
+	ok, _ = b.TryAcquire(now.Add(a.TTL))
+	assert.False(t, ok)
+	// The holder has disappeared, the lease expires
+	ok, _ = b.TryAcquire(now.Add(2 * a.TTL))
+	assert.True(t, ok)
+	lease, err := db.Lease("background")
+	assert.Nil(t, err)
+	assert.Equal(t, b.ID, lease.Holder)
+	ok, _ = a.TryAcquire(now.Add(2 * a.TTL))
+	assert.False(t, ok)
+	// Other leases are independent
+	ok, _ = leader.New(db, "other").TryAcquire(now)
+	assert.True(t, ok)
+}
+
+func TestRun(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	a := leader.New(db, "background")
+	a.TTL = 30 * time.Millisecond
+	b := leader.New(db, "background")
+	b.TTL = 30 * time.Millisecond
+	var leading int32
+	lead := func(ctx context.Context) {
+		if n := atomic.AddInt32(&leading, 1); n > 1 {
+			t.Error("two leaders at once")
+		}
+		<-ctx.Done()
+		atomic.AddInt32(&leading, -1)
+	}
+
+	ctxA, stopA := context.WithCancel(context.Background())
+	doneA := make(chan struct{})
+	go func() { a.Run(ctxA, lead); close(doneA) }()
+	waitFor(t, a.IsLeader)
+
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	go b.Run(ctxB, lead)
+	time.Sleep(3 * b.TTL)
+	assert.False(t, b.IsLeader())
+
+	// A stops and releases the lease, B takes over
+	stopA()
+	<-doneA
+	assert.False(t, a.IsLeader())
+	waitFor(t, b.IsLeader)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&leading))
+}
+
+func TestRunOnce(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	a := leader.New(db, "background")
+	a.TTL = 30 * time.Millisecond
+	b := leader.New(db, "background")
+	b.TTL = 30 * time.Millisecond
+
+	// The work outlasts the TTL, the lease is renewed meanwhile
+	ok, err := a.RunOnce(context.Background(), func(ctx context.Context) {
+		assert.True(t, a.IsLeader())
+		for i := 0; i < 5; i++ {
+			time.Sleep(a.TTL / 2)
+			taken, _ := b.TryAcquire(time.Now())
+			assert.False(t, taken)
+		}
+		assert.Nil(t, ctx.Err())
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.False(t, a.IsLeader())
+	_, err = a.Leader()
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// The lease is busy, the work is not done
+	ok, _ = b.TryAcquire(time.Now())
+	assert.True(t, ok)
+	ok, err = a.RunOnce(context.Background(), func(ctx context.Context) {
+		t.Error("work done without the lease")
+	})
+	assert.False(t, ok)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Release())
+
+	// The lease is taken over, the work is canceled
+	ok, err = a.RunOnce(context.Background(), func(ctx context.Context) {
+		ok, _ := b.TryAcquire(time.Now().Add(2 * a.TTL))
+		assert.True(t, ok)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Error("work has not been canceled")
+		}
+	})
+	assert.True(t, ok)
+	assert.Equal(t, leader.ErrLeaseLost, err)
+	assert.False(t, a.IsLeader())
+	holder, _ := a.Leader()
+	assert.Equal(t, b.ID, holder)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition has not been met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
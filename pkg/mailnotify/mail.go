@@ -0,0 +1,145 @@
+package mailnotify
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mail is a message with plain text and, optionally, HTML body and attachments
+type Mail struct {
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to the mail
+type Attachment struct {
+	FileName string
+	Data     []byte
+}
+
+// SendMail sends the mail. A mail having HTML body is sent as multipart/alternative,
+// so that mail clients that do not display HTML show the plain text.
+func (m *MailServiceImpl) SendMail(ml Mail) error {
+	if ml.HTML == "" && len(ml.Attachments) == 0 {
+		return m.SendMessage(ml.Subject, ml.Text)
+	}
+	msg, err := m.buildMail(ml)
+	if err != nil {
+		return err
+	}
+	return m.Send(m.MailServPort, m.A, m.From, m.recipients(), msg)
+}
+
+func (m *MailServiceImpl) buildMail(ml Mail) ([]byte, error) {
+	buf := bytes.Buffer{}
+	from := m.fromAddress()
+	buf.WriteString("From: " + from.String() + "\r\n")
+	buf.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("To: " + strings.Join(m.To, ",") + "\r\n")
+	buf.WriteString("Subject: " + mime.BEncoding.Encode("utf-8", ml.Subject) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	body := bytes.Buffer{}
+	var contentType string
+	var err error
+	if len(ml.Attachments) == 0 {
+		contentType, err = writeAlternative(&body, ml)
+	} else {
+		contentType, err = writeMixed(&body, ml)
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString("Content-Type: " + contentType + "\r\n\r\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// writeMixed writes the body and the attachments as multipart/mixed
+func writeMixed(buf *bytes.Buffer, ml Mail) (string, error) {
+	w := multipart.NewWriter(buf)
+	if ml.HTML != "" {
+		alt := bytes.Buffer{}
+		contentType, err := writeAlternative(&alt, ml)
+		if err != nil {
+			return "", err
+		}
+		part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+		if err != nil {
+			return "", err
+		}
+		part.Write(alt.Bytes())
+	} else if err := writeTextPart(w, "text/plain", ml.Text); err != nil {
+		return "", err
+	}
+	for _, a := range ml.Attachments {
+		contentType := mime.TypeByExtension(filepath.Ext(a.FileName))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.FileName})},
+		})
+		if err != nil {
+			return "", err
+		}
+		writeBase64(part, a.Data)
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return "multipart/mixed; boundary=" + w.Boundary(), nil
+}
+
+// writeAlternative writes the plain text and HTML bodies as multipart/alternative
+func writeAlternative(buf *bytes.Buffer, ml Mail) (string, error) {
+	w := multipart.NewWriter(buf)
+	if err := writeTextPart(w, "text/plain", ml.Text); err != nil {
+		return "", err
+	}
+	if err := writeTextPart(w, "text/html", ml.HTML); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return "multipart/alternative; boundary=" + w.Boundary(), nil
+}
+
+func writeTextPart(w *multipart.Writer, contentType string, text string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err = qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64 writes base64 encoded data in lines of up to 76 chars
+func writeBase64(w io.Writer, data []byte) {
+	b := []byte(base64.StdEncoding.EncodeToString(data))
+	for len(b) > 76 {
+		w.Write(b[:76])
+		w.Write([]byte("\r\n"))
+		b = b[76:]
+	}
+	w.Write(b)
+}
@@ -1,30 +1,39 @@
 package mailnotify
 
 import (
+	"bytes"
 	"fmt"
+	"log"
+	"mime"
 	"net/mail"
 	"net/smtp"
 
 	"github.com/scorredoira/email"
 	"github.com/vaefremov/cyr2volapiuk"
+	"github.com/vaefremov/pnglic/config"
 )
 
 // MailNotifyer is the interface implemented by mail notifier
 type MailNotifyer interface {
-	SendFile(clientName string, keyId string, fileBody []byte) error
 	SendMessage(subj string, message string) error
+	SendMail(ml Mail) error
 	AddTo(addr string) MailNotifyer
+	AddBcc(addr string) MailNotifyer
 }
 
 type MailServiceImpl struct {
 	From         string
+	FromName     string
 	To           []string
+	Bcc          []string
 	Serv         string
 	MailServPort string
 	A            smtp.Auth
 	Send         func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
 }
 
+// unencryptedAuth makes net/smtp send credentials over unencrypted connection,
+// used only if TransportPlain is set explicitly
 type unencryptedAuth struct {
 	smtp.Auth
 }
@@ -39,6 +48,8 @@ func (a *unencryptedAuth) Next(fromServer []byte, more bool) ([]byte, error) {
 }
 
 // New constructs a new notifier to send license files ny e-mail.
+// The connection to the server is secured with STARTTLS if the server offers it,
+// credentials are not sent if it does not.
 func New(serv string, port int, username string, password string) MailNotifyer {
 	var a smtp.Auth
 	if username != "" {
@@ -46,30 +57,33 @@ func New(serv string, port int, username string, password string) MailNotifyer {
 	}
 	newM := MailServiceImpl{
 		Serv: serv,
-		A:    a, 
-		From: username, MailServPort: serv + fmt.Sprintf(":%d", port),
+		A:    a,
+		From: username, FromName: config.DefaultMailFromName, MailServPort: serv + fmt.Sprintf(":%d", port),
 		To:   []string{},
-		Send: smtp.SendMail,
+		Send: (&Transport{Mode: TransportAuto}).Send,
 	}
 	return &newM
 }
 
-// SendFile sends the license file comprized of fileBoby. A short text and a subject
-// is added to the letter, the subect is constructed using client's name and key ID.
-func (m MailServiceImpl) SendFile(clientName string, keyID string, fileBody []byte) error {
-	subj := "License file key " + keyID + " for " + clientName
-	msg := email.NewMessage(subj, "Pls find the license file in the attachment.")
-	msg.From = mail.Address{Name: "Pangea License Generator", Address: m.From}
-	msg.To = m.To
-	// msg.AddCc(mail.Address{Name: "Vladimir A. Efremov", Address: "budwe1ser@yandex.ru"})
-	fileName := MakeLicenseFileName(clientName, keyID)
-	msg.AttachBuffer(fileName, fileBody, false)
-	var s smtp.Auth
-	if m.A != nil {
-		s = &unencryptedAuth{m.A}
+// NewFromConfig constructs a notifier using the mail server, transport and sender
+// parameters from config
+func NewFromConfig(conf *config.Config) (MailNotifyer, error) {
+	t, err := NewTransport(conf.MailTransport, conf.MailCA, conf.MailCert, conf.MailKey, conf.MailInsecureSkipVerify)
+	if err != nil {
+		return nil, err
 	}
-	err := m.Send(m.MailServPort, s, m.From, m.To, msg.Bytes())
-	return err
+	if t.Mode == TransportPlain && conf.MailUser != "" {
+		log.Printf("WARNING: mail transport is %s, the password of %s is sent to %s in clear text", TransportPlain, conf.MailUser, conf.MailServer)
+	}
+	m := New(conf.MailServer, conf.MailPort, conf.MailUser, conf.MailPass).(*MailServiceImpl)
+	m.From = conf.MailSender()
+	m.FromName = conf.MailSenderName()
+	m.Send = t.Send
+	return m, nil
+}
+
+func (m *MailServiceImpl) fromAddress() mail.Address {
+	return mail.Address{Name: m.FromName, Address: m.From}
 }
 
 // AddTo adds address to the list of recipients
@@ -81,17 +95,27 @@ func (m *MailServiceImpl) AddTo(addr string) MailNotifyer {
 	return m
 }
 
+// AddBcc adds address to the list of blind copy recipients, they are not shown
+// in the message headers
+func (m *MailServiceImpl) AddBcc(addr string) MailNotifyer {
+	if addr != "" {
+		m.Bcc = append(m.Bcc, addr)
+	}
+	return m
+}
+
+// recipients returns the envelope recipients of the messages: To and Bcc
+func (m *MailServiceImpl) recipients() []string {
+	return append(append([]string{}, m.To...), m.Bcc...)
+}
+
 // SendMessage sends a free-form message without attachments
 func (m *MailServiceImpl) SendMessage(subj string, message string) (err error) {
 	msg := email.NewMessage(subj, message)
-	msg.From = mail.Address{Name: "Pangea License Generator", Address: m.From}
+	msg.From = m.fromAddress()
 	msg.To = m.To
 	// msg.AddCc(mail.Address{Name: "Vladimir A. Efremov", Address: "budwe1ser@yandex.ru"})
-	var s smtp.Auth
-	if m.A != nil {
-		s = &unencryptedAuth{m.A}
-	}
-	err = m.Send(m.MailServPort, s, m.From, m.To, msg.Bytes())
+	err = m.Send(m.MailServPort, m.A, m.From, m.recipients(), msg.Bytes())
 	return err
 }
 
@@ -101,3 +125,37 @@ func MakeLicenseFileName(clientName string, keyID string) string {
 	nm := cyr2volapiuk.FileName(clientName)
 	return fmt.Sprintf("license_%s_%s.xml", keyID, nm)
 }
+
+// Enqueuer stores prepared messages for later delivery, e.g. in the outbox table
+type Enqueuer interface {
+	Enqueue(from string, to []string, subject string, msg []byte) error
+}
+
+// Queued makes the notifier put messages to the queue instead of sending them
+// directly. The messages are delivered later with SendRaw.
+func Queued(nt MailNotifyer, q Enqueuer) MailNotifyer {
+	m := nt.(*MailServiceImpl)
+	m.Send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		return q.Enqueue(from, to, messageSubject(msg), msg)
+	}
+	return m
+}
+
+// SendRaw sends the message prepared earlier (e.g. taken from the outbox) as is
+func (m *MailServiceImpl) SendRaw(from string, to []string, msg []byte) error {
+	return m.Send(m.MailServPort, m.A, from, to, msg)
+}
+
+// messageSubject returns the decoded subject of the message, empty if the
+// message cannot be parsed
+func messageSubject(msg []byte) string {
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return ""
+	}
+	subj := parsed.Header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subj); err == nil {
+		return decoded
+	}
+	return subj
+}
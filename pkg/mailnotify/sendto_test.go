@@ -2,8 +2,12 @@ package mailnotify_test
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/mail"
 	"net/smtp"
 	"strings"
@@ -36,7 +40,8 @@ func TestNotify(t *testing.T) {
 	mi := mailnotify.New("mail.server", 25, "user@pangea.ru", "**pass**").AddTo("some.addressee").AddTo("some.addressee2").AddTo("")
 	m := mi.(*mailnotify.MailServiceImpl)
 	m.Send = n.Send
-	err := m.SendFile("VIP Client", "1234abc", []byte("test body"))
+	err := m.SendMail(mailnotify.Mail{Subject: "License file", Text: "Body",
+		Attachments: []mailnotify.Attachment{{FileName: "license.xml", Data: []byte("test body")}}})
 	if err != nil {
 		t.Error(err)
 	}
@@ -68,3 +73,82 @@ func TestSendMessage(t *testing.T) {
 	assert.Equal(t, expBody+"\r\n", res)
 	// t.Error(mess.Header.Get("Subject"))
 }
+
+type mockQueue struct {
+	subject string
+	to      []string
+}
+
+func (q *mockQueue) Enqueue(from string, to []string, subject string, msg []byte) error {
+	q.subject = subject
+	q.to = to
+	return nil
+}
+
+func TestQueued(t *testing.T) {
+	q := mockQueue{}
+	m := mailnotify.Queued(mailnotify.New("mail.server", 25, "user@pangea.ru", "**pass**"), &q).AddTo("some.addressee")
+	err := m.SendMessage("Срок действия лицензий", "Body")
+	assert.Nil(t, err)
+	assert.Equal(t, "Срок действия лицензий", q.subject)
+	assert.Equal(t, []string{"some.addressee"}, q.to)
+}
+
+func TestAddBcc(t *testing.T) {
+	n := mockNotifyer{}
+	mi := mailnotify.New("mail.server", 25, "user@pangea.ru", "**pass**").AddTo("some.addressee").AddBcc("back.office").AddBcc("")
+	m := mi.(*mailnotify.MailServiceImpl)
+	m.Send = n.Send
+	for _, send := range []func() error{
+		func() error { return m.SendMessage("Subj", "Body") },
+		func() error { return m.SendMail(mailnotify.Mail{Subject: "Subj", Text: "Body", HTML: "<p>Body</p>"}) },
+	} {
+		assert.Nil(t, send())
+		assert.Equal(t, "[some.addressee back.office]", n.to)
+		mess, err := mail.ReadMessage(bytes.NewReader(n.body))
+		assert.Nil(t, err)
+		assert.Equal(t, "some.addressee", mess.Header.Get("To"))
+		assert.NotContains(t, string(n.body), "back.office")
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	n := mockNotifyer{}
+	mi := mailnotify.New("mail.server", 25, "user@pangea.ru", "**pass**").AddTo("some.addressee")
+	m := mi.(*mailnotify.MailServiceImpl)
+	m.Send = n.Send
+	err := m.SendMail(mailnotify.Mail{Subject: "Файл лицензий", Text: "Текст", HTML: "<p>Текст</p>",
+		Attachments: []mailnotify.Attachment{{FileName: "license.xml", Data: []byte("<license/>")}}})
+	assert.Nil(t, err)
+	mess, err := mail.ReadMessage(bytes.NewReader(n.body))
+	assert.Nil(t, err)
+	subj, _ := new(mime.WordDecoder).DecodeHeader(mess.Header.Get("Subject"))
+	assert.Equal(t, "Файл лицензий", subj)
+	mediaType, params, err := mime.ParseMediaType(mess.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+
+	mixed := multipart.NewReader(mess.Body, params["boundary"])
+	alt, err := mixed.NextPart()
+	assert.Nil(t, err)
+	mediaType, params, _ = mime.ParseMediaType(alt.Header.Get("Content-Type"))
+	assert.Equal(t, "multipart/alternative", mediaType)
+	altReader := multipart.NewReader(alt, params["boundary"])
+	bodies := []string{}
+	for {
+		part, err := altReader.NextPart()
+		if err != nil {
+			break
+		}
+		// quoted-printable is decoded by the reader
+		b, _ := ioutil.ReadAll(part)
+		bodies = append(bodies, part.Header.Get("Content-Type")+": "+string(b))
+	}
+	assert.Equal(t, []string{"text/plain; charset=utf-8: Текст", "text/html; charset=utf-8: <p>Текст</p>"}, bodies)
+
+	att, err := mixed.NextPart()
+	assert.Nil(t, err)
+	assert.Equal(t, "license.xml", att.FileName())
+	b, _ := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, att))
+	assert.Equal(t, "<license/>", string(b))
+}
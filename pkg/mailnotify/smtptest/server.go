@@ -0,0 +1,320 @@
+// Package smtptest provides a fake SMTP server for tests of mail delivery.
+// The server accepts all messages and keeps them in memory. It may be configured
+// to support STARTTLS, implicit TLS and authentication.
+package smtptest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Message is a message received by the fake server
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+	// TLS is true if the message was received over encrypted connection
+	TLS bool
+	// AuthUser is the name of the user authenticated before sending the message
+	AuthUser string
+}
+
+// Parse parses the message data
+func (m Message) Parse() (*mail.Message, error) {
+	return mail.ReadMessage(bytes.NewReader(m.Data))
+}
+
+// Option configures the fake server
+type Option func(s *Server)
+
+// WithSTARTTLS makes the server support the STARTTLS extension
+func WithSTARTTLS() Option {
+	return func(s *Server) {
+		s.startTLS = true
+	}
+}
+
+// WithImplicitTLS makes the server accept TLS connections only (like SMTPS on port 465)
+func WithImplicitTLS() Option {
+	return func(s *Server) {
+		s.implicitTLS = true
+	}
+}
+
+// WithAuth makes the server require PLAIN authentication with the given credentials
+func WithAuth(username string, password string) Option {
+	return func(s *Server) {
+		s.username = username
+		s.password = password
+	}
+}
+
+// Server is a fake SMTP server listening on a local port
+type Server struct {
+	Addr string
+
+	startTLS    bool
+	implicitTLS bool
+	username    string
+	password    string
+	tlsConfig   *tls.Config
+	certPEM     []byte
+
+	ln       net.Listener
+	mu       sync.Mutex
+	messages []Message
+	reject   int
+	authCmds int
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a fake SMTP server on a random local port
+func NewServer(opts ...Option) (*Server, error) {
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.startTLS || s.implicitTLS {
+		cert, certPEM, err := selfSignedCert()
+		if err != nil {
+			return nil, err
+		}
+		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		s.certPEM = certPEM
+	}
+	var ln net.Listener
+	var err error
+	if s.implicitTLS {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.ln = ln
+	s.Addr = ln.Addr().String()
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Host returns the host the server listens on
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr)
+	return host
+}
+
+// Port returns the port the server listens on
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.Addr)
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// CertPEM returns the self-signed certificate of the server in PEM format,
+// nil if the server does not support TLS
+func (s *Server) CertPEM() []byte {
+	return s.certPEM
+}
+
+// Messages returns the messages received so far
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message{}, s.messages...)
+}
+
+// RequireMessages fails the test unless exactly n messages have been received
+func (s *Server) RequireMessages(t testing.TB, n int) []Message {
+	t.Helper()
+	res := s.Messages()
+	if len(res) != n {
+		t.Fatalf("expected %d message(s) received by SMTP server, got %d", n, len(res))
+	}
+	return res
+}
+
+// AuthAttempts returns the number of AUTH commands received so far
+func (s *Server) AuthAttempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authCmds
+}
+
+// RejectNext makes the server reject the next n messages with a temporary failure
+func (s *Server) RejectNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject = n
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// session is the state of a single SMTP connection
+type session struct {
+	tp       *textproto.Conn
+	conn     net.Conn
+	tls      bool
+	authUser string
+	msg      Message
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	ss := &session{tp: textproto.NewConn(conn), conn: conn, tls: s.implicitTLS}
+	ss.tp.PrintfLine("220 smtptest ESMTP")
+	for {
+		line, err := ss.tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO":
+			ext := []string{"smtptest", "8BITMIME"}
+			if s.startTLS && !ss.tls {
+				ext = append(ext, "STARTTLS")
+			}
+			if s.username != "" {
+				ext = append(ext, "AUTH PLAIN")
+			}
+			for i, e := range ext {
+				if i < len(ext)-1 {
+					ss.tp.PrintfLine("250-%s", e)
+				} else {
+					ss.tp.PrintfLine("250 %s", e)
+				}
+			}
+		case "HELO":
+			ss.tp.PrintfLine("250 smtptest")
+		case "STARTTLS":
+			if !s.startTLS || ss.tls {
+				ss.tp.PrintfLine("502 command not implemented")
+				continue
+			}
+			ss.tp.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(ss.conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			// The client starts from scratch after the handshake
+			ss.conn = tlsConn
+			ss.tp = textproto.NewConn(tlsConn)
+			ss.tls = true
+			ss.authUser = ""
+		case "AUTH":
+			s.mu.Lock()
+			s.authCmds++
+			s.mu.Unlock()
+			s.auth(ss, line)
+		case "MAIL":
+			if s.username != "" && ss.authUser == "" {
+				ss.tp.PrintfLine("530 authentication required")
+				continue
+			}
+			if s.takeReject() {
+				ss.tp.PrintfLine("451 temporary failure, try again later")
+				continue
+			}
+			ss.msg = Message{From: extractAddr(line), TLS: ss.tls, AuthUser: ss.authUser}
+			ss.tp.PrintfLine("250 OK")
+		case "RCPT":
+			ss.msg.To = append(ss.msg.To, extractAddr(line))
+			ss.tp.PrintfLine("250 OK")
+		case "DATA":
+			ss.tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := ss.tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			ss.msg.Data = bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+			s.mu.Lock()
+			s.messages = append(s.messages, ss.msg)
+			s.mu.Unlock()
+			ss.tp.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			ss.tp.PrintfLine("250 OK")
+		case "QUIT":
+			ss.tp.PrintfLine("221 bye")
+			return
+		default:
+			ss.tp.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+// auth handles "AUTH PLAIN [initial-response]"
+func (s *Server) auth(ss *session, line string) {
+	parts := strings.Fields(line)
+	if s.username == "" || len(parts) < 2 || strings.ToUpper(parts[1]) != "PLAIN" {
+		ss.tp.PrintfLine("504 unrecognized authentication type")
+		return
+	}
+	resp := ""
+	if len(parts) > 2 {
+		resp = parts[2]
+	} else {
+		ss.tp.PrintfLine("334 ")
+		var err error
+		if resp, err = ss.tp.ReadLine(); err != nil {
+			return
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(resp)
+	creds := bytes.Split(decoded, []byte{0})
+	if err != nil || len(creds) != 3 || string(creds[1]) != s.username || string(creds[2]) != s.password {
+		ss.tp.PrintfLine("535 authentication failed")
+		return
+	}
+	ss.authUser = s.username
+	ss.tp.PrintfLine("235 authentication successful")
+}
+
+func (s *Server) takeReject() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reject > 0 {
+		s.reject--
+		return true
+	}
+	return false
+}
+
+// extractAddr extracts the address from "MAIL FROM:<addr>" or "RCPT TO:<addr>"
+func extractAddr(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
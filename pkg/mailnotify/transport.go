@@ -0,0 +1,154 @@
+package mailnotify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// Transport modes, i.e. how the connection to the mail server is secured
+const (
+	// TransportPlain sends mail over unencrypted connection, e.g. to a local relay.
+	// Note that credentials, if any, are sent in clear text. This is the only mode
+	// that does so, and it must be set explicitly.
+	TransportPlain = "plain"
+	// TransportSTARTTLS upgrades the connection with STARTTLS, the server must support it
+	TransportSTARTTLS = "starttls"
+	// TransportTLS uses implicit TLS (SMTPS, usually port 465)
+	TransportTLS = "tls"
+	// TransportAuto upgrades the connection with STARTTLS if the server offers it and
+	// goes on unencrypted otherwise. Credentials are never sent over unencrypted
+	// connection in this mode: sending fails if AUTH is needed and STARTTLS is not offered.
+	TransportAuto = "auto"
+)
+
+const dialTimeout = 30 * time.Second
+
+// Transport delivers messages to the mail server. Its Send method may be used as
+// the Send field of MailServiceImpl.
+type Transport struct {
+	Mode      string
+	TLSConfig *tls.Config
+}
+
+// NewTransport constructs the transport. caFile is a PEM bundle of CAs to verify the server
+// certificate against (system CAs are used if empty), certFile and keyFile specify
+// the client certificate, both are optional. The empty mode is TransportAuto.
+func NewTransport(mode string, caFile string, certFile string, keyFile string, insecureSkipVerify bool) (*Transport, error) {
+	switch mode {
+	case "":
+		mode = TransportAuto
+	case TransportPlain, TransportSTARTTLS, TransportTLS, TransportAuto:
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q, expected one of %s, %s, %s, %s", mode, TransportAuto, TransportPlain, TransportSTARTTLS, TransportTLS)
+	}
+	tlsConf := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	return &Transport{Mode: mode, TLSConfig: tlsConf}, nil
+}
+
+// Send connects to the mail server at addr and sends the message
+func (t *Transport) Send(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	tlsConf := &tls.Config{}
+	if t.TLSConfig != nil {
+		tlsConf = t.TLSConfig.Clone()
+	}
+	if tlsConf.ServerName == "" {
+		tlsConf.ServerName = host
+	}
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if t.Mode == TransportTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConf)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	mode := t.Mode
+	if mode == TransportAuto {
+		ok, _ := c.Extension("STARTTLS")
+		if !ok && a != nil {
+			return fmt.Errorf("mail server %s does not offer STARTTLS, refusing to send credentials over unencrypted connection (set mail transport to %s to allow it)", addr, TransportPlain)
+		}
+		if ok {
+			mode = TransportSTARTTLS
+		}
+	}
+	switch mode {
+	case TransportSTARTTLS:
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("mail server %s does not support STARTTLS", addr)
+		}
+		if err = c.StartTLS(tlsConf); err != nil {
+			return err
+		}
+	case TransportPlain:
+		if a != nil {
+			// The operator has chosen plain transport explicitly, net/smtp would
+			// refuse to send credentials over unencrypted connection otherwise
+			a = &unencryptedAuth{a}
+		}
+	case TransportTLS, TransportAuto:
+	default:
+		return fmt.Errorf("unknown mail transport %q", t.Mode)
+	}
+	if a != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("mail server %s does not support AUTH", addr)
+		}
+		if err = c.Auth(a); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err = c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,169 @@
+package mailnotify_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+	"github.com/vaefremov/pnglic/pkg/mailnotify/smtptest"
+)
+
+func startServer(t *testing.T, opts ...smtptest.Option) *smtptest.Server {
+	srv, err := smtptest.NewServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+// writeCA stores the server certificate to a temp file to be used as mailCA
+func writeCA(t *testing.T, srv *smtptest.Server) string {
+	dir, err := ioutil.TempDir("", "pnglic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "ca.pem")
+	if err = ioutil.WriteFile(caFile, srv.CertPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return caFile
+}
+
+func TestTransportPlainRelay(t *testing.T) {
+	srv := startServer(t)
+	defer srv.Close()
+	conf := &config.Config{MailServer: srv.Host(), MailPort: srv.Port(), MailTransport: mailnotify.TransportPlain,
+		MailFrom: "lic@pangea.ru", MailFromName: "License Desk"}
+	nt, err := mailnotify.NewFromConfig(conf)
+	assert.Nil(t, err)
+	assert.Nil(t, nt.AddTo("client@org1.ru").SendMessage("Subj", "Body"))
+	msgs := srv.RequireMessages(t, 1)
+	assert.False(t, msgs[0].TLS)
+	assert.Equal(t, "lic@pangea.ru", msgs[0].From)
+	assert.Equal(t, []string{"client@org1.ru"}, msgs[0].To)
+	parsed, err := msgs[0].Parse()
+	assert.Nil(t, err)
+	assert.Equal(t, `"License Desk" <lic@pangea.ru>`, parsed.Header.Get("From"))
+}
+
+func TestTransportSTARTTLS(t *testing.T) {
+	srv := startServer(t, smtptest.WithSTARTTLS(), smtptest.WithAuth("lic@pangea.ru", "secret"))
+	defer srv.Close()
+	caFile := writeCA(t, srv)
+	defer os.RemoveAll(filepath.Dir(caFile))
+	conf := &config.Config{MailServer: srv.Host(), MailPort: srv.Port(), MailTransport: mailnotify.TransportSTARTTLS,
+		MailUser: "lic@pangea.ru", MailPass: "secret", MailCA: caFile}
+	nt, err := mailnotify.NewFromConfig(conf)
+	assert.Nil(t, err)
+	assert.Nil(t, nt.AddTo("client@org1.ru").SendMail(mailnotify.Mail{Subject: "License file", Text: "Body",
+		Attachments: []mailnotify.Attachment{{FileName: "license.xml", Data: []byte("<license/>")}}}))
+	msgs := srv.RequireMessages(t, 1)
+	assert.True(t, msgs[0].TLS)
+	assert.Equal(t, "lic@pangea.ru", msgs[0].AuthUser)
+	parsed, _ := msgs[0].Parse()
+	assert.Equal(t, `"Pangea License Generator" <lic@pangea.ru>`, parsed.Header.Get("From"))
+
+	// The self-signed certificate is not trusted unless the CA is given
+	conf.MailCA = ""
+	nt, _ = mailnotify.NewFromConfig(conf)
+	assert.NotNil(t, nt.AddTo("client@org1.ru").SendMessage("Subj", "Body"))
+	conf.MailInsecureSkipVerify = true
+	nt, _ = mailnotify.NewFromConfig(conf)
+	assert.Nil(t, nt.AddTo("client@org1.ru").SendMessage("Subj", "Body"))
+	srv.RequireMessages(t, 2)
+}
+
+func TestTransportSTARTTLSRequired(t *testing.T) {
+	srv := startServer(t)
+	defer srv.Close()
+	conf := &config.Config{MailServer: srv.Host(), MailPort: srv.Port(), MailTransport: mailnotify.TransportSTARTTLS}
+	nt, err := mailnotify.NewFromConfig(conf)
+	assert.Nil(t, err)
+	err = nt.AddTo("client@org1.ru").SendMessage("Subj", "Body")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "STARTTLS")
+	srv.RequireMessages(t, 0)
+}
+
+// The default transport upgrades the connection only if the server offers STARTTLS
+func TestTransportAuto(t *testing.T) {
+	relay := startServer(t)
+	defer relay.Close()
+	conf := &config.Config{MailServer: relay.Host(), MailPort: relay.Port(), MailFrom: "lic@pangea.ru"}
+	nt, err := mailnotify.NewFromConfig(conf)
+	assert.Nil(t, err)
+	assert.Nil(t, nt.AddTo("client@org1.ru").SendMessage("Subj", "Body"))
+	msgs := relay.RequireMessages(t, 1)
+	assert.False(t, msgs[0].TLS)
+
+	srv := startServer(t, smtptest.WithSTARTTLS())
+	defer srv.Close()
+	caFile := writeCA(t, srv)
+	defer os.RemoveAll(filepath.Dir(caFile))
+	conf = &config.Config{MailServer: srv.Host(), MailPort: srv.Port(), MailTransport: mailnotify.TransportAuto,
+		MailFrom: "lic@pangea.ru", MailCA: caFile}
+	nt, err = mailnotify.NewFromConfig(conf)
+	assert.Nil(t, err)
+	assert.Nil(t, nt.AddTo("client@org1.ru").SendMessage("Subj", "Body"))
+	msgs = srv.RequireMessages(t, 1)
+	assert.True(t, msgs[0].TLS)
+}
+
+// Credentials are not sent unencrypted unless the plain transport is set explicitly
+func TestTransportAutoNoAuthWithoutSTARTTLS(t *testing.T) {
+	srv := startServer(t, smtptest.WithAuth("lic@pangea.ru", "secret"))
+	defer srv.Close()
+	conf := &config.Config{MailServer: srv.Host(), MailPort: srv.Port(), MailTransport: mailnotify.TransportAuto,
+		MailUser: "lic@pangea.ru", MailPass: "secret"}
+	nt, err := mailnotify.NewFromConfig(conf)
+	assert.Nil(t, err)
+	err = nt.AddTo("client@org1.ru").SendMessage("Subj", "Body")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "STARTTLS")
+	assert.Equal(t, 0, srv.AuthAttempts())
+	srv.RequireMessages(t, 0)
+
+	// The default transport behaves the same way
+	conf.MailTransport = ""
+	nt, _ = mailnotify.NewFromConfig(conf)
+	assert.NotNil(t, nt.AddTo("client@org1.ru").SendMessage("Subj", "Body"))
+	nt = mailnotify.New(srv.Host(), srv.Port(), "lic@pangea.ru", "secret").AddTo("client@org1.ru")
+	assert.NotNil(t, nt.SendMessage("Subj", "Body"))
+	assert.Equal(t, 0, srv.AuthAttempts())
+
+	conf.MailTransport = mailnotify.TransportPlain
+	nt, _ = mailnotify.NewFromConfig(conf)
+	assert.Nil(t, nt.AddTo("client@org1.ru").SendMessage("Subj", "Body"))
+	assert.Equal(t, 1, srv.AuthAttempts())
+	msgs := srv.RequireMessages(t, 1)
+	assert.False(t, msgs[0].TLS)
+	assert.Equal(t, "lic@pangea.ru", msgs[0].AuthUser)
+}
+
+func TestTransportImplicitTLS(t *testing.T) {
+	srv := startServer(t, smtptest.WithImplicitTLS(), smtptest.WithAuth("lic@pangea.ru", "secret"))
+	defer srv.Close()
+	caFile := writeCA(t, srv)
+	defer os.RemoveAll(filepath.Dir(caFile))
+	conf := &config.Config{MailServer: srv.Host(), MailPort: srv.Port(), MailTransport: mailnotify.TransportTLS,
+		MailUser: "lic@pangea.ru", MailPass: "wrong", MailCA: caFile}
+	nt, err := mailnotify.NewFromConfig(conf)
+	assert.Nil(t, err)
+	assert.NotNil(t, nt.AddTo("client@org1.ru").SendMessage("Subj", "Body"))
+	conf.MailPass = "secret"
+	nt, _ = mailnotify.NewFromConfig(conf)
+	assert.Nil(t, nt.AddTo("client@org1.ru").SendMessage("Subj", "Body"))
+	msgs := srv.RequireMessages(t, 1)
+	assert.True(t, msgs[0].TLS)
+}
+
+func TestNewTransport(t *testing.T) {
+	_, err := mailnotify.NewTransport("ssl", "", "", "", false)
+	assert.NotNil(t, err)
+	_, err = mailnotify.NewTransport(mailnotify.TransportTLS, "/no/such/ca.pem", "", "", false)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,71 @@
+package mailtmpl
+
+// builtinTemplates are used when there are no template files
+var builtinTemplates = map[string]string{
+	Expiring:    expiringTemplate,
+	Lapsed:      lapsedTemplate,
+	Customer:    customerTemplate,
+	LicenseFile: licenseFileTemplate,
+	Digest:      digestTemplate,
+}
+
+const expiringTemplate = `
+Please, check the following keys for features that will expire soon (in {{.ExpTermDays}} day):
+
+{{ range $index, $key := .Keys }}
+{{$index}} : {{$key.KeyID}} ({{$.ServerPublicURL}}/v1/view/keyfeatures.html?keyId={{$key.KeyID}}&fullPage=true)
+		Client: {{$key.ClientName}}
+		{{range $feature := $key.Features}}{{$feature}} {{end}} will expire in {{$key.ExpTermDays}} day(s)
+		Expiration date: {{$key.ExpTimeStr}}
+{{ end }}
+
+Hope that helps. Thanks!
+{{define "subject"}}Warning: some licenses will expire in {{.ExpTermDays}} days{{end}}`
+
+const lapsedTemplate = `
+The following licenses have expired, their grace period is over and nobody renewed them:
+
+{{ range $f := .Features }}
+{{$f.ClientName}}, key {{$f.KeyID}} ({{$.ServerPublicURL}}/v1/view/keyfeatures.html?keyId={{$f.KeyID}}&fullPage=true)
+		{{$f.Feature}} expired on {{$f.End.Format "2006-01-02"}}, grace period ended on {{$f.GraceEnd.Format "2006-01-02"}}
+{{ end }}
+
+Hope that helps. Thanks!
+{{define "subject"}}Lapsed licenses: {{len .Features}} feature(s) were not renewed{{end}}`
+
+const customerTemplate = `
+Dear {{.ClientName}},
+
+Some of your Pangea licenses will expire soon:
+{{ range $key := .Keys }}
+Key {{$key.KeyID}}:
+		{{range $feature := $key.Features}}{{$feature}} {{end}}
+		will expire on {{$key.ExpTimeStr}} (in {{$key.ExpTermDays}} day(s))
+{{ end }}
+Please contact us to renew the licenses.
+
+Best regards,
+Pangea License Management
+{{define "subject"}}Your Pangea licenses will expire soon{{end}}`
+
+const licenseFileTemplate = `Pls find the license file in the attachment.{{define "subject"}}License file key {{.KeyID}} for {{.ClientName}}{{end}}`
+
+const digestTemplate = `
+Licensing digest for {{.From.Format "2006-01-02"}} - {{.To.Format "2006-01-02"}}
+
+Keys: {{.TotalKeys}}
+{{ range .Clients }}		{{.ClientName}}: {{.Count}}
+{{ end }}
+{{ range $h := .Expiring }}
+Expiring in {{$h.FromDays}}-{{$h.Days}} days:{{ if not $h.Clients }} none{{ end }}
+{{ range $h.Clients }}		{{.ClientName}}:
+{{ range .Features }}			{{.KeyID}} {{.Feature}} {{.End}}
+{{ end }}{{ end }}{{ end }}
+License files issued this week: {{.TotalIssued}}
+{{ range .Issued }}		{{.ClientName}}: {{.Count}}
+{{ end }}
+Clients with lapsed licenses:{{ if not .Lapsed }} none{{ end }}
+{{ range .Lapsed }}		{{.ClientName}}: {{.Count}} feature(s), keys {{ range $i, $k := .Keys }}{{ if $i }}, {{ end }}{{$k}}{{ end }}
+{{ end }}
+{{.ServerPublicURL}}/v1/view/
+{{define "subject"}}Licensing digest {{.From.Format "2006-01-02"}} - {{.To.Format "2006-01-02"}}{{end}}`
@@ -0,0 +1,152 @@
+// Package mailtmpl renders mail messages from templates. Templates are loaded from
+// the mail subdirectory of the static content directory, files are named
+// <name>.<lang>.txt (plain text, text/template syntax) and <name>.<lang>.html
+// (HTML, html/template syntax). The subject is defined in the template as
+// {{define "subject"}}...{{end}}. The English template is used if there is no template
+// for the requested language, built-in plain text templates are used if there are
+// no template files at all.
+package mailtmpl
+
+import (
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+)
+
+// Names of templates
+const (
+	Expiring    = "expiring"
+	Lapsed      = "lapsed"
+	Customer    = "customer"
+	LicenseFile = "licensefile"
+	Digest      = "digest"
+)
+
+// Supported languages
+const (
+	LangEnglish = "en"
+	LangRussian = "ru"
+	DefaultLang = LangEnglish
+)
+
+// Names returns the names of all the templates
+func Names() []string {
+	return []string{Expiring, Lapsed, Customer, LicenseFile, Digest}
+}
+
+// Langs returns the supported languages
+func Langs() []string {
+	return []string{LangEnglish, LangRussian}
+}
+
+// IsValidLang checks if the language is supported
+func IsValidLang(lang string) bool {
+	return lang == LangEnglish || lang == LangRussian
+}
+
+// IsValidName checks if the template name is known
+func IsValidName(name string) bool {
+	_, ok := builtinTemplates[name]
+	return ok
+}
+
+// LicenseFileData is the data the licensefile template is rendered with
+type LicenseFileData struct {
+	ClientName string
+	KeyID      string
+	FileName   string
+}
+
+// Renderer renders mail templates stored in Dir
+type Renderer struct {
+	Dir string
+}
+
+// New constructs renderer of the templates stored in the mail subdirectory
+// of the static content directory
+func New(staticContent string) *Renderer {
+	return &Renderer{Dir: filepath.Join(staticContent, "mail")}
+}
+
+// Render renders the template in the given language. The HTML body of the result
+// is empty if there is no HTML template.
+func (r *Renderer) Render(name string, lang string, data interface{}) (res mailnotify.Mail, err error) {
+	builtin, ok := builtinTemplates[name]
+	if !ok {
+		return res, errors.Errorf("unknown mail template %q", name)
+	}
+	if !IsValidLang(lang) {
+		lang = DefaultLang
+	}
+	textSrc, err := r.load(name, lang, "txt")
+	if err != nil {
+		return
+	}
+	if textSrc == "" {
+		textSrc = builtin
+	}
+	textTempl, err := template.New(name).Parse(textSrc)
+	if err != nil {
+		return res, errors.Wrapf(err, "template %s.%s.txt", name, lang)
+	}
+	if res.Text, err = executeText(textTempl, data); err != nil {
+		return
+	}
+	if textTempl.Lookup("subject") != nil {
+		if res.Subject, err = executeText(textTempl.Lookup("subject"), data); err != nil {
+			return
+		}
+	}
+
+	htmlSrc, err := r.load(name, lang, "html")
+	if err != nil || htmlSrc == "" {
+		return
+	}
+	htmlTempl, err := htmltemplate.New(name).Parse(htmlSrc)
+	if err != nil {
+		return res, errors.Wrapf(err, "template %s.%s.html", name, lang)
+	}
+	bld := strings.Builder{}
+	if err = htmlTempl.Execute(&bld, data); err != nil {
+		return
+	}
+	res.HTML = bld.String()
+	if res.Subject == "" && htmlTempl.Lookup("subject") != nil {
+		bld.Reset()
+		if err = htmlTempl.ExecuteTemplate(&bld, "subject", data); err != nil {
+			return
+		}
+		res.Subject = strings.TrimSpace(bld.String())
+	}
+	return
+}
+
+// load reads the template file for the language, the English one if there is none,
+// empty string if there are no template files
+func (r *Renderer) load(name string, lang string, ext string) (string, error) {
+	for _, l := range []string{lang, DefaultLang} {
+		src, err := ioutil.ReadFile(filepath.Join(r.Dir, name+"."+l+"."+ext))
+		if err == nil {
+			return string(src), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func executeText(t *template.Template, data interface{}) (string, error) {
+	bld := strings.Builder{}
+	err := t.Execute(&bld, data)
+	if t.Name() == "subject" {
+		return strings.TrimSpace(bld.String()), err
+	}
+	return bld.String(), err
+}
@@ -0,0 +1,65 @@
+package mailtmpl_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/mailtmpl"
+)
+
+func TestRenderShippedTemplates(t *testing.T) {
+	r := mailtmpl.New("../../templates")
+	for _, name := range mailtmpl.Names() {
+		data, err := mailtmpl.Sample(name, "http://some.host:9995")
+		assert.Nil(t, err)
+		for _, lang := range mailtmpl.Langs() {
+			ml, err := r.Render(name, lang, data)
+			assert.Nil(t, err, name, lang)
+			assert.NotEqual(t, "", ml.Subject, name, lang)
+			assert.Contains(t, ml.Text, "1234abcd", name, lang)
+			assert.Contains(t, ml.HTML, "1234abcd", name, lang)
+		}
+	}
+	data, _ := mailtmpl.Sample(mailtmpl.LicenseFile, "")
+	ml, _ := r.Render(mailtmpl.LicenseFile, mailtmpl.LangRussian, data)
+	assert.Equal(t, "Файл лицензий для ключа 1234abcd, Sample Client", ml.Subject)
+}
+
+func TestRenderFallbacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pnglic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := mailtmpl.New(dir)
+	data := mailtmpl.LicenseFileData{ClientName: "Org 1", KeyID: "123abc"}
+
+	// No template files: the built-in plain text template is used
+	ml, err := r.Render(mailtmpl.LicenseFile, mailtmpl.LangRussian, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "License file key 123abc for Org 1", ml.Subject)
+	assert.Equal(t, "Pls find the license file in the attachment.", ml.Text)
+	assert.Equal(t, "", ml.HTML)
+
+	// No template for the language: the English one is used
+	os.Mkdir(filepath.Join(dir, "mail"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "mail", "licensefile.en.txt"), []byte(`{{define "subject"}}Key {{.KeyID}}{{end}}Text`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "mail", "licensefile.en.html"), []byte(`<p>{{.ClientName}}</p>`), 0644)
+	ml, err = r.Render(mailtmpl.LicenseFile, "de", data)
+	assert.Nil(t, err)
+	assert.Equal(t, "Key 123abc", ml.Subject)
+	assert.Equal(t, "Text", ml.Text)
+	assert.Equal(t, "<p>Org 1</p>", ml.HTML)
+
+	// HTML is escaped
+	data.ClientName = "<Org>"
+	ml, _ = r.Render(mailtmpl.LicenseFile, mailtmpl.LangEnglish, data)
+	assert.True(t, strings.Contains(ml.HTML, "&lt;Org&gt;"))
+
+	_, err = r.Render("nosuchtemplate", mailtmpl.LangEnglish, data)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,73 @@
+package mailtmpl
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sample returns sample data to preview the template with. The data has the same
+// fields as the data the template is rendered with when the mail is sent.
+func Sample(name string, serverPublicURL string) (interface{}, error) {
+	end := time.Now().AddDate(0, 0, 7)
+	switch name {
+	case Expiring:
+		return map[string]interface{}{
+			"ExpTerm":         7 * 24 * time.Hour,
+			"ExpTermDays":     7,
+			"ServerPublicURL": serverPublicURL,
+			"Keys": []map[string]interface{}{
+				{"KeyID": "1234abcd", "ClientName": "Sample Client", "Features": []string{"PANGEA_BASE", "LM_CONSOLE"},
+					"ExpTime": end, "ExpTermDays": 7, "ExpTimeStr": end.Format("2006-01-02")},
+			},
+		}, nil
+	case Lapsed:
+		lapsedEnd := time.Now().AddDate(0, 0, -40)
+		return map[string]interface{}{
+			"ServerPublicURL": serverPublicURL,
+			"Features": []map[string]interface{}{
+				{"KeyID": "1234abcd", "ClientID": 1, "ClientName": "Sample Client", "Feature": "PANGEA_BASE",
+					"End": lapsedEnd, "GraceEnd": lapsedEnd.AddDate(0, 0, 10), "InGrace": false},
+			},
+		}, nil
+	case Customer:
+		return map[string]interface{}{
+			"ClientName": "Sample Client",
+			"Keys": []map[string]interface{}{
+				{"KeyID": "1234abcd", "ClientName": "Sample Client", "Features": []string{"PANGEA_BASE", "LM_CONSOLE"},
+					"ExpTime": end, "ExpTermDays": 7, "ExpTimeStr": end.Format("2006-01-02")},
+			},
+		}, nil
+	case Digest:
+		now := time.Now()
+		return map[string]interface{}{
+			"ServerPublicURL": serverPublicURL,
+			"From":            now.AddDate(0, 0, -7),
+			"To":              now,
+			"TotalKeys":       3,
+			"Clients": []map[string]interface{}{
+				{"ClientName": "Sample Client", "Count": 2},
+				{"ClientName": "Other Client", "Count": 1},
+			},
+			"Expiring": []map[string]interface{}{
+				{"FromDays": 0, "Days": 30, "Clients": []map[string]interface{}{
+					{"ClientName": "Sample Client", "Features": []map[string]interface{}{
+						{"KeyID": "1234abcd", "Feature": "PANGEA_BASE", "End": end.Format("2006-01-02")},
+					}},
+				}},
+				{"FromDays": 31, "Days": 60, "Clients": []map[string]interface{}{}},
+				{"FromDays": 61, "Days": 90, "Clients": []map[string]interface{}{}},
+			},
+			"TotalIssued": 1,
+			"Issued": []map[string]interface{}{
+				{"ClientName": "Sample Client", "Count": 1},
+			},
+			"Lapsed": []map[string]interface{}{
+				{"ClientName": "Other Client", "Count": 1, "Keys": []string{"5678abcd"}},
+			},
+		}, nil
+	case LicenseFile:
+		return LicenseFileData{ClientName: "Sample Client", KeyID: "1234abcd", FileName: "license_1234abcd_Sample_Client.xml"}, nil
+	}
+	return nil, errors.Errorf("unknown mail template %q", name)
+}
@@ -13,11 +13,36 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// BulkProlong - Prolongs the selected features of many keys in a single transaction
+func BulkProlong(c *gin.Context) {
+	BulkProlongImpl(c)
+}
+
 // ChangeLicensesCount - Update license features for the given key ID, set counts of all features to the value of the setCount parameter
 func ChangeLicensesCount(c *gin.Context) {
 	ChangeLicensesCountImpl(c)
 }
 
+// GetClientSettings - Returns settings of the client
+func GetClientSettings(c *gin.Context) {
+	GetClientSettingsImpl(c)
+}
+
+// CompareKeys - Returns the feature-by-key matrix of the license sets of the keys
+func CompareKeys(c *gin.Context) {
+	CompareKeysImpl(c)
+}
+
+// CopyLicenseSet - Copies the features of the source key to the key
+func CopyLicenseSet(c *gin.Context) {
+	CopyLicenseSetImpl(c)
+}
+
+// CreateContact - Adds a contact person to the client
+func CreateContact(c *gin.Context) {
+	CreateContactImpl(c)
+}
+
 // CreateFeature - Creates a new feature
 func CreateFeature(c *gin.Context) {
 	CreateFeatureImpl(c)
@@ -28,16 +53,76 @@ func CreateKey(c *gin.Context) {
 	CreateKeyImpl(c)
 }
 
+// DeleteContact - Deletes the contact person
+func DeleteContact(c *gin.Context) {
+	DeleteContactImpl(c)
+}
+
 // DeleteFeature - Deletes a nfeature
 func DeleteFeature(c *gin.Context) {
 	DeleteFeatureImpl(c)
 }
 
+// DeleteKeyFeature - Removes the feature from the license set of the key
+func DeleteKeyFeature(c *gin.Context) {
+	DeleteKeyFeatureImpl(c)
+}
+
+// ExpirationsForecast - Returns features ending within the period aggregated by client, feature or month
+func ExpirationsForecast(c *gin.Context) {
+	ExpirationsForecastImpl(c)
+}
+
+// ExpiredFeatures - Returns features that expired within the given number of days
+func ExpiredFeatures(c *gin.Context) {
+	ExpiredFeaturesImpl(c)
+}
+
+// ExpiryCalendar - Returns the iCalendar feed of license expirations
+func ExpiryCalendar(c *gin.Context) {
+	ExpiryCalendarImpl(c)
+}
+
+// ExportClients - Returns the list of clients with their settings as CSV
+func ExportClients(c *gin.Context) {
+	ExportClientsImpl(c)
+}
+
+// ExportHistory - Returns the list of issued license files as CSV
+func ExportHistory(c *gin.Context) {
+	ExportHistoryImpl(c)
+}
+
+// ExportKeys - Returns the list of keys as CSV
+func ExportKeys(c *gin.Context) {
+	ExportKeysImpl(c)
+}
+
+// ExportLicenseSets - Returns license sets of all keys as CSV
+func ExportLicenseSets(c *gin.Context) {
+	ExportLicenseSetsImpl(c)
+}
+
 // HistoryLicenseFile - Get license file by client id and timestamp of issue
 func HistoryLicenseFile(c *gin.Context) {
 	HistoryLicenseFileImpl(c)
 }
 
+// ImportKeys - Creates keys listed in CSV or JSON in a single transaction
+func ImportKeys(c *gin.Context) {
+	ImportKeysImpl(c)
+}
+
+// KeyFeatureRules - Explains which feature rules fire when the license file of the key is made
+func KeyFeatureRules(c *gin.Context) {
+	KeyFeatureRulesImpl(c)
+}
+
+// KeyReplacements - Returns the chain of replacements the key takes part in
+func KeyReplacements(c *gin.Context) {
+	KeyReplacementsImpl(c)
+}
+
 // LicensedFeaturesForKey - Returns list of all license features related to a given key
 func LicensedFeaturesForKey(c *gin.Context) {
 	LicensedFeaturesForKeyImpl(c)
@@ -48,6 +133,16 @@ func ListClients(c *gin.Context) {
 	ListClientsImpl(c)
 }
 
+// ListContacts - Returns contact persons of the client
+func ListContacts(c *gin.Context) {
+	ListContactsImpl(c)
+}
+
+// ListFeatureRules - Returns the policy rules of features
+func ListFeatureRules(c *gin.Context) {
+	ListFeatureRulesImpl(c)
+}
+
 // ListFeatures - Returns list of features
 func ListFeatures(c *gin.Context) {
 	ListFeaturesImpl(c)
@@ -58,11 +153,26 @@ func ListHistoryItems(c *gin.Context) {
 	ListHistoryItemsImpl(c)
 }
 
+// ListJobs - Returns the scheduled jobs and results of their last runs
+func ListJobs(c *gin.Context) {
+	ListJobsImpl(c)
+}
+
 // ListKeys - Returns general list of keys
 func ListKeys(c *gin.Context) {
 	ListKeysImpl(c)
 }
 
+// ListOutbox - Returns the most recent messages of the mail outbox
+func ListOutbox(c *gin.Context) {
+	ListOutboxImpl(c)
+}
+
+// ListWebhookDeliveries - Returns the most recent webhook deliveries
+func ListWebhookDeliveries(c *gin.Context) {
+	ListWebhookDeliveriesImpl(c)
+}
+
 // MakeLicenseFile - Generate license file from the current set of licenses related to key ID and store it in the history
 func MakeLicenseFile(c *gin.Context) {
 	MakeLicenseFileImpl(c)
@@ -78,11 +188,61 @@ func PackageContent(c *gin.Context) {
 	PackageContentImpl(c)
 }
 
+// PatchKeyFeature - Changes the given fields of the feature licensed to the key
+func PatchKeyFeature(c *gin.Context) {
+	PatchKeyFeatureImpl(c)
+}
+
+// PreviewMailTemplate - Renders the mail template with sample data
+func PreviewMailTemplate(c *gin.Context) {
+	PreviewMailTemplateImpl(c)
+}
+
 // ProlongLicensedFeaturesForKey - Update license features for the given key ID, replace the previousely defined ones
 func ProlongLicensedFeaturesForKey(c *gin.Context) {
 	ProlongLicensedFeaturesForKeyImpl(c)
 }
 
+// PutKeyFeature - Adds the feature to the license set of the key or replaces it
+func PutKeyFeature(c *gin.Context) {
+	PutKeyFeatureImpl(c)
+}
+
+// RedeliverWebhook - Puts the webhook delivery back to the queue
+func RedeliverWebhook(c *gin.Context) {
+	RedeliverWebhookImpl(c)
+}
+
+// ReplaceKey - Moves the license set of the key to the new key and retires the key
+func ReplaceKey(c *gin.Context) {
+	ReplaceKeyImpl(c)
+}
+
+// ResendMessage - Puts the message of the outbox back to the delivery queue
+func ResendMessage(c *gin.Context) {
+	ResendMessageImpl(c)
+}
+
+// RunJob - Runs the job immediately and waits for it to finish
+func RunJob(c *gin.Context) {
+	RunJobImpl(c)
+}
+
+// TransferKey - Assigns the key to another client
+func TransferKey(c *gin.Context) {
+	TransferKeyImpl(c)
+}
+
+// UpdateClientSettings - Replaces settings of the client
+func UpdateClientSettings(c *gin.Context) {
+	UpdateClientSettingsImpl(c)
+}
+
+// UpdateContact - Replaces data of the contact person
+func UpdateContact(c *gin.Context) {
+	UpdateContactImpl(c)
+}
+
 // UpdateLicensedFeaturesForKey - Update license features for the given key ID, replace the previousely defined ones
 func UpdateLicensedFeaturesForKey(c *gin.Context) {
 	UpdateLicensedFeaturesForKeyImpl(c)
@@ -1,12 +1,16 @@
 package openapi
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailtmpl"
+	"github.com/vaefremov/pnglic/pkg/webhook"
 )
 
 // PingImpl actually implements the logic behind ping request
@@ -65,9 +69,37 @@ func CreateKeyImpl(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
 		return
 	}
+	emit(c, webhook.EventKeyCreated, webhook.KeyCreated{KeyID: newKey.Id, ClientID: int(newKey.CurrentOwnerId), Comments: newKey.Comments})
 	c.JSON(http.StatusCreated, newKey)
 }
 
+// TransferKeyImpl - Assigns the key to another client
+func TransferKeyImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyID := c.Param("keyId")
+	req := KeyTransfer{}
+	if err := c.BindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	if req.ClientId == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: "Client ID must be specified"})
+		return
+	}
+	if abortRetiredKey(c, db, keyID) {
+		return
+	}
+	prevClientID, err := db.TransferKey(keyID, int(req.ClientId))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	if prevClientID != int(req.ClientId) {
+		emit(c, webhook.EventKeyTransferred, webhook.KeyTransferred{KeyID: keyID, FromClientID: prevClientID, ToClientID: int(req.ClientId)})
+	}
+	c.JSON(http.StatusOK, req)
+}
+
 // ListClientsImpl - Returns list of all organizations related to licensation
 func ListClientsImpl(c *gin.Context) {
 	res := []Organization{}
@@ -102,3 +134,56 @@ func ListHistoryItemsImpl(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, res)
 }
+
+// GetClientSettingsImpl - Returns settings of the client
+func GetClientSettingsImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	clientID, err := strconv.Atoi(c.Param("clientId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	if _, err = db.ClientNameByID(clientID); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 3, Message: "unknown client"})
+		return
+	}
+	s, err := db.ClientSettings(clientID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ClientSettings{GraceDays: int32(s.GraceDays), NotifyCustomer: s.NotifyCustomer, Lang: s.Lang, Manager: s.Manager})
+}
+
+// UpdateClientSettingsImpl - Replaces settings of the client
+func UpdateClientSettingsImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	clientID, err := strconv.Atoi(c.Param("clientId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	s := ClientSettings{}
+	if err = c.BindJSON(&s); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	if s.GraceDays < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: "grace period must not be negative"})
+		return
+	}
+	if s.Lang == "" {
+		s.Lang = dao.DefaultLang
+	}
+	if !mailtmpl.IsValidLang(s.Lang) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: fmt.Sprintf("unsupported language %q", s.Lang)})
+		return
+	}
+	s.Manager = strings.TrimSpace(s.Manager)
+	err = db.SetClientSettings(dao.ClientSettings{OrgId: clientID, GraceDays: int(s.GraceDays), NotifyCustomer: s.NotifyCustomer, Lang: s.Lang, Manager: s.Manager})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
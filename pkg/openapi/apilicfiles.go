@@ -15,6 +15,9 @@ import (
 	"github.com/vaefremov/pnglic/config"
 	"github.com/vaefremov/pnglic/pkg/dao"
 	"github.com/vaefremov/pnglic/pkg/mailnotify"
+	"github.com/vaefremov/pnglic/pkg/mailtmpl"
+	"github.com/vaefremov/pnglic/pkg/outbox"
+	"github.com/vaefremov/pnglic/pkg/webhook"
 	"github.com/vaefremov/pnglic/pkg/xmlutils"
 )
 
@@ -80,44 +83,77 @@ func MakeLicenseFileImpl(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: fmt.Sprintf("Key %s does not belong to client id %d", keyID, clientID)})
 		return
 	}
-	dbLicset, err := db.LicensesSetByKeyId(keyID)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
-		return
-	}
-	resXML, err := makeXMLFromTemplate(keyID, db, dbLicset)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
-		return
-	}
-	resXML, err = signLicenseFile(c, resXML)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+	// Files are not issued for the retired keys any more
+	if abortRetiredKey(c, db, keyID) {
 		return
 	}
-	resXML, err = xmlutils.ReorderSingleFeaturesFirst(resXML)
+	resXML, issued, err := issueLicenseFile(c, db, clientID, keyID)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
 		return
 	}
-	err = db.AddToHistory(clientID, time.Now(), resXML)
-	if mailTo := c.Query("mailTo"); mailTo != "" {
+	mailTo := c.Query("mailTo")
+	if mailTo != "" {
 		clientName, err := db.ClientNameByID(clientID)
 		if err != nil {
 			log.Println("Error when sending file ", err)
 		}
 		conf := c.MustGet("conf").(*config.Config)
-		log.Println("Mailing file to ", mailTo)
-		notificator := mailnotify.New(conf.MailServer, conf.MailPort, conf.MailUser, conf.MailPass)
-		notificator.AddTo(mailTo).AddTo(conf.BackMail)
-		if err := notificator.SendFile(clientName, keyID, []byte(resXML)); err != nil {
+		log.Println("Queueing file for ", mailTo)
+		if err := mailLicenseFile(db, conf, clientID, clientName, keyID, resXML, mailTo); err != nil {
 			log.Println("Error when sending file ", err)
 		}
 	}
+	emit(c, webhook.EventLicenseFileIssued, webhook.LicenseFileIssued{ClientID: clientID, KeyID: keyID, Issued: issued, MailedTo: mailTo})
 
 	c.Data(http.StatusOK, "application/xml; charset=utf-8", []byte(resXML))
 }
 
+// issueLicenseFile makes the signed license file from the current license set of the key
+// and stores it in the history. The feature rules are applied to the set in the file.
+func issueLicenseFile(c *gin.Context, db *dao.DbConn, clientID int, keyID string) (resXML string, issued time.Time, err error) {
+	dbLicset, err := db.LicensesSetByKeyId(keyID)
+	if err != nil {
+		return
+	}
+	dbLicset, fired := featureRules(c).Apply(dbLicset)
+	for _, f := range fired {
+		log.Println("Feature rule fired for the license file of", keyID, f)
+	}
+	if resXML, err = makeXMLFromTemplate(keyID, db, dbLicset); err != nil {
+		return
+	}
+	if resXML, err = signLicenseFile(c, resXML); err != nil {
+		return
+	}
+	if resXML, err = xmlutils.ReorderSingleFeaturesFirst(resXML); err != nil {
+		return
+	}
+	issued = time.Now()
+	err = db.AddToHistory(clientID, issued, resXML)
+	return
+}
+
+// mailLicenseFile sends the license file to the given addresses and the back mail,
+// the letter is rendered in the language of the client
+func mailLicenseFile(db *dao.DbConn, conf *config.Config, clientID int, clientName string, keyID string, licenseFile string, mailTo ...string) error {
+	settings, err := db.ClientSettings(clientID)
+	if err != nil {
+		return err
+	}
+	data := mailtmpl.LicenseFileData{ClientName: clientName, KeyID: keyID, FileName: mailnotify.MakeLicenseFileName(clientName, keyID)}
+	ml, err := mailtmpl.New(conf.StaticContent).Render(mailtmpl.LicenseFile, settings.Lang, data)
+	if err != nil {
+		return err
+	}
+	ml.Attachments = []mailnotify.Attachment{{FileName: data.FileName, Data: []byte(licenseFile)}}
+	nt := outbox.NewNotifyer(db, conf)
+	for _, addr := range mailTo {
+		nt = nt.AddTo(addr)
+	}
+	return nt.AddTo(conf.BackMail).SendMail(ml)
+}
+
 const featureTemplate = `<%s
 	id="%s"
 	version="%.2f"
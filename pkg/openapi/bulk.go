@@ -0,0 +1,245 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/rules"
+	"github.com/vaefremov/pnglic/pkg/webhook"
+)
+
+// BulkProlongImpl - Prolongs the selected features of many keys in a single transaction.
+// Features are selected by all the given criteria: clients, feature names and end date.
+// The feature rules are applied to the prolonged license sets. Unless it is a dry run,
+// the revisions of the license sets returned by the dry run are required, the request
+// is rejected with 412 if any of the sets has been changed since.
+func BulkProlongImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	req := BulkProlongRequest{}
+	if err := c.BindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	if len(req.ClientIds) == 0 && len(req.Features) == 0 && req.EndBefore == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 150, Message: "features must be selected by clients, feature names or end date"})
+		return
+	}
+	var endBefore time.Time
+	if req.EndBefore != "" {
+		var err error
+		if endBefore, err = time.Parse("2006-01-02", req.EndBefore); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 150, Message: "invalid endBefore date: " + req.EndBefore})
+			return
+		}
+	}
+	by, unit := "", req.Unit
+	switch {
+	case req.By != 0:
+		by = strconv.Itoa(int(req.By))
+	case req.ByMonths != 0:
+		by, unit = strconv.Itoa(int(req.ByMonths)), prolongMonths
+	}
+	term, err := parseProlongTerm(req.Till, by, unit, req.Mode)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 30, Message: err.Error()})
+		return
+	}
+	if req.SetVersion < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 30, Message: "invalid version"})
+		return
+	}
+	if !dryRun && req.Revisions == nil {
+		c.AbortWithStatusJSON(http.StatusPreconditionRequired, Error{Code: 180,
+			Message: "revisions of the license sets as returned by the dry run are required"})
+		return
+	}
+
+	keys, err := db.Keys()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 1, Message: err.Error()})
+		return
+	}
+	clients, err := clientNames(db)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	licSets, err := licenseSetsOfKeys(db)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	selectedClients := map[int]bool{}
+	for _, id := range req.ClientIds {
+		selectedClients[int(id)] = true
+	}
+	selectedFeatures := map[string]bool{}
+	for _, f := range req.Features {
+		selectedFeatures[f] = true
+	}
+	engine := featureRules(c)
+	now := time.Now()
+	res := BulkProlongResult{DryRun: dryRun, Rows: []BulkProlongRow{}, Fired: []FiredRule{}, Files: []BulkIssuedFile{}, Revisions: map[string]int32{}}
+	newSets := map[string][]dao.LicenseSetItem{}
+	ownerOfKey := map[string]int{}
+	for _, k := range keys {
+		if len(selectedClients) > 0 && !selectedClients[k.OrgId] {
+			continue
+		}
+		prolonged := []int{}
+		fired := []rules.Fired{}
+		newSet := []dao.LicenseSetItem{}
+		for _, f := range licSets[k.Id] {
+			if len(selectedFeatures) > 0 && !selectedFeatures[f.Feature] || !endBefore.IsZero() && !f.End.Before(endBefore) {
+				newSet = append(newSet, f)
+				continue
+			}
+			fnew, tmp := engine.Prolong(f, term.end(engine.BaseEnd(f), now), req.SetVersion)
+			fired = append(fired, tmp...)
+			prolonged = append(prolonged, len(newSet))
+			newSet = append(newSet, fnew)
+		}
+		if len(prolonged) == 0 {
+			continue
+		}
+		// Apply keeps the features in place, only the always included ones are appended
+		newSet, tmp := engine.Apply(newSet)
+		for _, i := range prolonged {
+			f, fnew := licSets[k.Id][i], newSet[i]
+			res.Rows = append(res.Rows, BulkProlongRow{KeyId: k.Id, ClientId: int32(k.OrgId), ClientName: clients[k.OrgId], Feature: f.Feature,
+				OldEnd: f.End.Format("2006-01-02"), NewEnd: fnew.End.Format("2006-01-02"), OldVersion: f.Version, NewVersion: fnew.Version})
+		}
+		res.Fired = append(res.Fired, firedRules(k.Id, append(fired, tmp...))...)
+		newSets[k.Id] = newSet
+		ownerOfKey[k.Id] = k.OrgId
+	}
+	res.Keys = int32(len(newSets))
+	if !validateLicenseSets(c, db, newSets) {
+		return
+	}
+	keyIDs := []string{}
+	for keyID := range newSets {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	if dryRun {
+		if err = setBulkRevisions(db, keyIDs, res.Revisions); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	if len(newSets) == 0 {
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	revisions := map[string]int{}
+	for keyID, revision := range req.Revisions {
+		revisions[keyID] = int(revision)
+	}
+	err = db.UpdateLicenseSetsAtRevision(newSets, revisions)
+	if err == dao.ErrStaleRevision {
+		abortStaleLicenseSets(c, db, keyIDs, req.Revisions)
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 20, Message: "Input rejected: " + err.Error()})
+		return
+	}
+	if err = setBulkRevisions(db, keyIDs, res.Revisions); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	for _, keyID := range keyIDs {
+		emitLicenseSetChanged(c, db, keyID, webhook.OperationProlong)
+	}
+	if req.IssueFiles || req.MailFiles {
+		for _, keyID := range keyIDs {
+			res.Files = append(res.Files, reissueLicenseFile(c, db, ownerOfKey[keyID], clients[ownerOfKey[keyID]], keyID, req.MailFiles))
+		}
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// setBulkRevisions puts the current revisions of the license sets of the keys to revisions
+func setBulkRevisions(db *dao.DbConn, keyIDs []string, revisions map[string]int32) error {
+	for _, keyID := range keyIDs {
+		revision, err := db.LicenseSetRevision(keyID)
+		if err != nil {
+			return err
+		}
+		revisions[keyID] = int32(revision)
+	}
+	return nil
+}
+
+// abortStaleLicenseSets aborts the request with 412 listing the keys whose license sets
+// are not at the expected revisions any more, or have not been previewed at all
+func abortStaleLicenseSets(c *gin.Context, db *dao.DbConn, keyIDs []string, expected map[string]int32) {
+	res := Error{Code: 181, Errors: []FieldError{}}
+	stale := []string{}
+	for _, keyID := range keyIDs {
+		current, err := db.LicenseSetRevision(keyID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+			return
+		}
+		revision, ok := expected[keyID]
+		switch {
+		case !ok:
+			res.Errors = append(res.Errors, FieldError{KeyId: keyID, Field: "revisions",
+				Message: fmt.Sprintf("the license set has not been previewed, it is at revision %d", current)})
+		case int(revision) != current:
+			res.Errors = append(res.Errors, FieldError{KeyId: keyID, Field: "revisions",
+				Message: fmt.Sprintf("the license set has been changed since revision %d, it is at revision %d", revision, current)})
+		default:
+			continue
+		}
+		stale = append(stale, keyID)
+	}
+	res.Message = "license sets have been changed by someone else: " + strings.Join(stale, ", ")
+	c.AbortWithStatusJSON(http.StatusPreconditionFailed, res)
+}
+
+// reissueLicenseFile issues a new license file of the key and mails it to the contacts
+// of the client that want to receive license files
+func reissueLicenseFile(c *gin.Context, db *dao.DbConn, clientID int, clientName string, keyID string, mail bool) BulkIssuedFile {
+	res := BulkIssuedFile{KeyId: keyID, ClientId: int32(clientID)}
+	resXML, issued, err := issueLicenseFile(c, db, clientID, keyID)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if mail {
+		contacts, err := db.Contacts(clientID)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		for _, ct := range contacts {
+			if ct.NotifyFiles && ct.Email != "" {
+				res.MailedTo = append(res.MailedTo, ct.Email)
+			}
+		}
+		if len(res.MailedTo) > 0 {
+			conf := c.MustGet("conf").(*config.Config)
+			if err = mailLicenseFile(db, conf, clientID, clientName, keyID, resXML, res.MailedTo...); err != nil {
+				res.Error = fmt.Sprintf("the file is issued but not mailed: %s", err)
+			}
+		}
+	}
+	emit(c, webhook.EventLicenseFileIssued, webhook.LicenseFileIssued{ClientID: clientID, KeyID: keyID, Issued: issued, MailedTo: strings.Join(res.MailedTo, ", ")})
+	return res
+}
@@ -0,0 +1,161 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func bulkProlong(t *testing.T, db *dao.DbConn, conf *config.Config, query string, body string) (int, openapi.BulkProlongResult) {
+	c, w := newTestContext(db)
+	c.Set("conf", conf)
+	c.Request, _ = http.NewRequest("POST", "/v1/bulk/prolong"+query, strings.NewReader(body))
+	openapi.BulkProlongImpl(c)
+	res := openapi.BulkProlongResult{}
+	if w.Code == http.StatusOK {
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res), w.Body.String())
+	}
+	return w.Code, res
+}
+
+// bulkProlongPreviewed prolongs with the revisions of the license sets returned by the dry run
+func bulkProlongPreviewed(t *testing.T, db *dao.DbConn, conf *config.Config, body string) (int, openapi.BulkProlongResult) {
+	code, preview := bulkProlong(t, db, conf, "?dryRun=true", body)
+	if code != http.StatusOK {
+		return code, preview
+	}
+	return bulkProlong(t, db, conf, "", withRevisions(t, body, preview.Revisions))
+}
+
+// withRevisions adds the revisions of the license sets to the request body
+func withRevisions(t *testing.T, body string, revisions map[string]int32) string {
+	req := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &req))
+	req["revisions"] = revisions
+	res, _ := json.Marshal(req)
+	return string(res)
+}
+
+func TestBulkProlongImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	conf := config.NewConfig("")
+	for _, body := range []string{`{"till": "2030-01-01"}`, `{"features": ["F3"]}`, `{"features": ["F3"], "till": "2030-13-01"}`,
+		`{"endBefore": "01/01/2010", "byMonths": 12}`} {
+		code, _ := bulkProlong(t, db, conf, "", body)
+		assert.Equal(t, http.StatusBadRequest, code, body)
+	}
+
+	code, res := bulkProlong(t, db, conf, "?dryRun=true", `{"clientIds": [1], "features": ["F3", "F1"], "till": "2030-01-01", "setVersion": 20}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, res.DryRun)
+	assert.Equal(t, int32(2), res.Keys)
+	if assert.Equal(t, 3, len(res.Rows)) {
+		assert.Equal(t, openapi.BulkProlongRow{KeyId: "123abc", ClientId: 1, ClientName: "Org 1", Feature: "F3",
+			OldEnd: "2008-07-08", NewEnd: "2030-01-01", OldVersion: 19, NewVersion: 20}, res.Rows[0])
+	}
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+	for _, f := range licSet {
+		assert.Equal(t, 2008, f.End.Year())
+	}
+
+	code, res = bulkProlong(t, db, conf, "?dryRun=true", `{"features": ["F1"], "by": 30, "unit": "days", "mode": "from-current-end"}`)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Equal(t, 1, len(res.Rows)) {
+		assert.Equal(t, "2008-08-07", res.Rows[0].NewEnd)
+	}
+	code, _ = bulkProlong(t, db, conf, "?dryRun=true", `{"features": ["F1"], "by": 30, "mode": "from-yesterday"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, res = bulkProlongPreviewed(t, db, conf, `{"features": ["F3"], "endBefore": "2010-01-01", "till": "2030-01-01"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int32(2), res.Keys)
+	assert.Equal(t, map[string]int32{"123abc": 1, "123bbc": 1}, res.Revisions)
+	assert.Equal(t, 0, len(res.Files))
+	for _, keyID := range []string{"123abc", "123bbc"} {
+		licSet, _ = db.LicensesSetByKeyId(keyID)
+		for _, f := range licSet {
+			assert.Equal(t, f.Feature == "F3", f.End.Year() == 2030, f)
+		}
+	}
+	// F3 does not end before 2010 any more
+	code, res = bulkProlongPreviewed(t, db, conf, `{"features": ["F3"], "endBefore": "2010-01-01", "till": "2031-01-01"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int32(0), res.Keys)
+}
+
+func TestBulkProlongImplIssueFiles(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	dir, err := ioutil.TempDir("", "bulk")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	// The encoder just outputs the license file
+	encoder := filepath.Join(dir, "encoder.sh")
+	assert.Nil(t, ioutil.WriteFile(encoder, []byte("#!/bin/sh\ncat \"$2\"\n"), 0755))
+	conf := config.NewConfig("")
+	conf.LicfileEncoderLegacy = encoder
+	conf.StaticContent = "../../templates"
+	_, err = db.CreateContact(dao.Contact{OrgId: 1, Name: "Admin", Email: "licadmin@org1.ru", Role: dao.RoleLicenseAdmin, NotifyFiles: true})
+	assert.Nil(t, err)
+
+	code, res := bulkProlongPreviewed(t, db, conf, `{"clientIds": [1], "byMonths": 12, "mailFiles": true}`)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Equal(t, 2, len(res.Files)) {
+		assert.Equal(t, openapi.BulkIssuedFile{KeyId: "123abc", ClientId: 1, MailedTo: []string{"licadmin@org1.ru"}}, res.Files[0])
+	}
+	hist, _ := db.HistoryForClientId(1)
+	assert.Contains(t, hist[0].ContentXml, `id="123`)
+	messages, _ := db.OutboxItems(10)
+	assert.Equal(t, 2, len(messages))
+}
+
+func TestBulkProlongImplStale(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	conf := config.NewConfig("")
+	body := `{"features": ["F3"], "endBefore": "2010-01-01", "till": "2030-01-01"}`
+	code, _ := bulkProlong(t, db, conf, "", body)
+	assert.Equal(t, http.StatusPreconditionRequired, code)
+
+	code, preview := bulkProlong(t, db, conf, "?dryRun=true", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]int32{"123abc": 0, "123bbc": 0}, preview.Revisions)
+	// Someone changes the license set of one of the keys after the preview
+	licSet, _ := db.LicensesSetByKeyId("123bbc")
+	assert.Nil(t, db.UpdateLicenseSet("123bbc", licSet))
+
+	c, w := newTestContext(db)
+	c.Set("conf", conf)
+	c.Request, _ = http.NewRequest("POST", "/v1/bulk/prolong", strings.NewReader(withRevisions(t, body, preview.Revisions)))
+	openapi.BulkProlongImpl(c)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	res := openapi.Error{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	if assert.Equal(t, 1, len(res.Errors)) {
+		assert.Equal(t, "123bbc", res.Errors[0].KeyId)
+	}
+	assert.Contains(t, res.Message, "123bbc")
+	// None of the sets is changed
+	for _, keyID := range []string{"123abc", "123bbc"} {
+		licSet, _ = db.LicensesSetByKeyId(keyID)
+		for _, f := range licSet {
+			assert.NotEqual(t, 2030, f.End.Year(), f)
+		}
+	}
+	revision, _ := db.LicenseSetRevision("123abc")
+	assert.Equal(t, 0, revision)
+
+	// The keys not previewed are stale as well
+	code, _ = bulkProlong(t, db, conf, "", withRevisions(t, body, map[string]int32{"123bbc": 1}))
+	assert.Equal(t, http.StatusPreconditionFailed, code)
+	code, res2 := bulkProlongPreviewed(t, db, conf, body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]int32{"123abc": 1, "123bbc": 2}, res2.Revisions)
+}
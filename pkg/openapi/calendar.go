@@ -0,0 +1,118 @@
+package openapi
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/chkexprd"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/ical"
+)
+
+// calendarTokenKey is the context key of the calendar token taken out of the query
+const calendarTokenKey = "calendarToken"
+
+// ExpiryCalendarImpl - Returns the iCalendar feed of license expirations. The calendar
+// tokens are long-lived secrets, the token is accepted in the Authorization header
+// (Bearer) or in the token query parameter for the calendar apps that cannot set headers.
+func ExpiryCalendarImpl(c *gin.Context) {
+	conf := c.MustGet("conf").(*config.Config)
+	db := c.MustGet("db").(*dao.DbConn)
+	if len(conf.CalendarTokens) == 0 {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 120, Message: "calendar feed is disabled"})
+		return
+	}
+	if !isCalendarToken(conf, calendarToken(c)) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, Error{Code: 121, Message: "invalid calendar token"})
+		return
+	}
+	clientID := -1
+	if clientIDStr := c.Query("clientId"); clientIDStr != "" {
+		tmp, err := strconv.Atoi(clientIDStr)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+			return
+		}
+		clientID = tmp
+	}
+	manager := strings.TrimSpace(c.Query("manager"))
+	days := conf.CalendarDays
+	if daysStr := c.Query("days"); daysStr != "" {
+		tmp, err := strconv.Atoi(daysStr)
+		if err != nil || tmp <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: "days must be a positive number"})
+			return
+		}
+		days = tmp
+	}
+	events, err := chkexprd.FindExpiryEvents(db, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	cal := ical.Calendar{Name: "License expirations"}
+	for _, e := range events {
+		if clientID >= 0 && e.ClientID != clientID {
+			continue
+		}
+		if manager != "" && !strings.EqualFold(e.Manager, manager) {
+			continue
+		}
+		cal.Events = append(cal.Events, ical.Event{
+			UID:         fmt.Sprintf("%s-%s@%s", e.KeyID, e.End.Format("20060102"), conf.PublicName),
+			Date:        e.End,
+			Summary:     fmt.Sprintf("%s: %d feature(s) of key %s expire", e.ClientName, len(e.Features), e.KeyID),
+			Description: fmt.Sprintf("Client: %s\nKey: %s\nFeatures: %s", e.ClientName, e.KeyID, strings.Join(e.Features, ", ")),
+			URL:         fmt.Sprintf("http://%s:%d/v1/view/keyfeatures.html?keyId=%s&fullPage=true", conf.PublicName, conf.Port, e.KeyID),
+		})
+	}
+	c.Header("Content-Type", ical.ContentType)
+	c.Header("Content-Disposition", `inline; filename="expirations.ics"`)
+	c.Status(http.StatusOK)
+	if err := cal.WriteTo(c.Writer, time.Now()); err != nil {
+		c.Error(err)
+	}
+}
+
+// hideCalendarToken moves the token query parameter to the context, so that the token
+// does not get to the access log along with the URL. It must precede the logger.
+func hideCalendarToken(c *gin.Context) {
+	query := c.Request.URL.Query()
+	if _, ok := query["token"]; ok {
+		c.Set(calendarTokenKey, query.Get("token"))
+		query.Del("token")
+		c.Request.URL.RawQuery = query.Encode()
+	}
+	c.Next()
+}
+
+// calendarToken returns the token of the calendar feed request, taken from the
+// Authorization header or from the query
+func calendarToken(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if token, ok := c.Get(calendarTokenKey); ok {
+		return token.(string)
+	}
+	return c.Query("token")
+}
+
+// isCalendarToken checks if the token gives access to the calendar feed
+func isCalendarToken(conf *config.Config, token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, t := range conf.CalendarTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package openapi_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func TestExpiryCalendarImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	for _, keyID := range []string{"123abc", "123cbc"} {
+		licSet, _ := db.LicensesSetByKeyId(keyID)
+		if len(licSet) == 0 {
+			licSet = []dao.LicenseSetItem{{KeyID: keyID, Feature: "F1", Version: 19.0, Count: 1, Start: time.Now().AddDate(-1, 0, 0)}}
+		}
+		for i := range licSet {
+			licSet[i].End = time.Now().AddDate(0, 0, 10)
+		}
+		assert.Nil(t, db.UpdateLicenseSet(keyID, licSet))
+	}
+	assert.Nil(t, db.SetClientSettings(dao.ClientSettings{OrgId: 2, Manager: "petrov@pangea.ru"}))
+	conf := &config.Config{PublicName: "some.host", Port: 9995, CalendarDays: 30}
+	get := func(query string) (int, string) {
+		c, w := newTestContext(db)
+		c.Set("conf", conf)
+		c.Request, _ = http.NewRequest("GET", "/v1/calendar/expirations.ics?"+query, nil)
+		openapi.ExpiryCalendarImpl(c)
+		return w.Code, w.Body.String()
+	}
+
+	code, _ := get("token=secret")
+	assert.Equal(t, http.StatusNotFound, code)
+	conf.CalendarTokens = []string{"secret"}
+	code, _ = get("token=wrong")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = get("token=secret&days=-1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body := get("token=secret")
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, 2, strings.Count(body, "BEGIN:VEVENT"))
+	assert.Contains(t, body, "UID:123cbc-")
+
+	_, body = get("token=secret&clientId=1")
+	assert.Equal(t, 1, strings.Count(body, "BEGIN:VEVENT"))
+	assert.Contains(t, body, "key 123abc")
+
+	_, body = get("token=secret&manager=Petrov@pangea.ru")
+	assert.Equal(t, 1, strings.Count(body, "BEGIN:VEVENT"))
+	assert.Contains(t, body, "key 123cbc")
+
+	_, body = get("token=secret&days=5")
+	assert.Equal(t, 0, strings.Count(body, "BEGIN:VEVENT"))
+}
+
+// The calendar tokens must not get to the access log
+func TestExpiryCalendarTokenNotLogged(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	conf := config.NewConfig("")
+	conf.StaticContent = "../../templates"
+	conf.CalendarTokens = []string{"very-secret"}
+	accessLog := bytes.Buffer{}
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &accessLog
+	router := openapi.NewRouter(conf, db, nil, nil, nil)
+	gin.DefaultWriter = defaultWriter
+
+	for _, token := range []string{"very-secret", "wrong-secret"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/calendar/expirations.ics?days=10&token="+token, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, token == "very-secret", w.Code == http.StatusOK, w.Code)
+		assert.NotContains(t, accessLog.String(), token)
+	}
+	assert.Contains(t, accessLog.String(), "/v1/calendar/expirations.ics?days=10")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/calendar/expirations.ics", nil)
+	req.Header.Set("Authorization", "Bearer very-secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
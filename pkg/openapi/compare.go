@@ -0,0 +1,64 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// comparedKeys returns the IDs of the keys listed in the keys parameter, comma-separated
+// or repeated, without duplicates
+func comparedKeys(c *gin.Context) []string {
+	res := []string{}
+	seen := map[string]bool{}
+	for _, v := range c.QueryArray("keys") {
+		for _, keyID := range strings.Split(v, ",") {
+			keyID = strings.TrimSpace(keyID)
+			if keyID != "" && !seen[keyID] {
+				seen[keyID] = true
+				res = append(res, keyID)
+			}
+		}
+	}
+	return res
+}
+
+// CompareKeysImpl - Returns the feature-by-key matrix of the license sets of the keys, the
+// packages are expanded as in the license files
+func CompareKeysImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyIDs := comparedKeys(c)
+	if len(keyIDs) < 2 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 220, Message: "at least two keys must be specified"})
+		return
+	}
+	for _, keyID := range keyIDs {
+		if _, err := db.KeyOfWhichOrg(keyID); err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 221, Message: fmt.Sprintf("key %s is not registered", keyID)})
+			return
+		}
+	}
+	rows, err := db.CompareLicenseSets(keyIDs)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	res := KeyComparison{Keys: keyIDs, Identical: true, Features: []FeatureComparison{}}
+	for _, row := range rows {
+		f := FeatureComparison{Name: row.Feature, IsPackage: row.IsPackage, Differs: row.Differs}
+		for _, it := range row.Items {
+			if it == nil {
+				f.Keys = append(f.Keys, nil)
+				continue
+			}
+			f.Keys = append(f.Keys, &ComparedFeature{Version: it.Version, Count: int32(it.Count),
+				Start: it.Start.Format("2006-01-02"), End: it.End.Format("2006-01-02"), Package: it.Package})
+		}
+		res.Identical = res.Identical && len(row.Differs) == 0
+		res.Features = append(res.Features, f)
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,53 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func TestCompareKeysImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	compare := func(query string) (int, openapi.KeyComparison) {
+		c, w := newTestContext(db)
+		c.Request, _ = http.NewRequest("GET", "/v1/compareKeys?"+query, nil)
+		openapi.CompareKeysImpl(c)
+		res := openapi.KeyComparison{}
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res
+	}
+
+	code, res := compare("keys=123abc,123bbc&keys=123cbc")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"123abc", "123bbc", "123cbc"}, res.Keys)
+	assert.False(t, res.Identical)
+	if assert.Equal(t, 4, len(res.Features)) {
+		f1 := res.Features[1]
+		assert.Equal(t, "F1", f1.Name)
+		assert.Equal(t, []string{"licensed", "version", "count"}, f1.Differs)
+		assert.Equal(t, &openapi.ComparedFeature{Version: 19, Count: 10, Start: "2007-07-08", End: "2008-07-08", Package: "P1"}, f1.Keys[0])
+		assert.Equal(t, "", f1.Keys[1].Package)
+		assert.Nil(t, f1.Keys[2])
+	}
+
+	code, _ = compare("keys=123abc,123abc,123abc&keys=123abc")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = compare("keys=123abc,nosuchkey")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// A copy of the license set is identical to the original
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+	for i := range licSet {
+		licSet[i].KeyID = "123cbc"
+	}
+	assert.Nil(t, db.UpdateLicenseSet("123cbc", licSet))
+	code, res = compare("keys=123abc,123cbc")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, res.Identical)
+}
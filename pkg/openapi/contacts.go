@@ -0,0 +1,140 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// ListContactsImpl - Returns contact persons of the client
+func ListContactsImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	clientID, err := strconv.Atoi(c.Param("clientId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	contacts, err := db.Contacts(clientID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	res := []Contact{}
+	for _, ct := range contacts {
+		res = append(res, contactFromDao(ct))
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// CreateContactImpl - Adds a contact person to the client
+func CreateContactImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	clientID, err := strconv.Atoi(c.Param("clientId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	newContact := Contact{}
+	if err = c.BindJSON(&newContact); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 70, Message: err.Error()})
+		return
+	}
+	if err = checkContact(&newContact); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 70, Message: err.Error()})
+		return
+	}
+	ct := contactToDao(newContact)
+	ct.OrgId = clientID
+	if ct.Id, err = db.CreateContact(ct); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 70, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, contactFromDao(ct))
+}
+
+// UpdateContactImpl - Replaces data of the contact person
+func UpdateContactImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	contactID, err := strconv.Atoi(c.Param("contactId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	current, err := db.Contact(contactID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 70, Message: fmt.Sprintf("invalid contact ID %d", contactID)})
+		return
+	}
+	upd := Contact{}
+	if err = c.BindJSON(&upd); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 70, Message: err.Error()})
+		return
+	}
+	if err = checkContact(&upd); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 70, Message: err.Error()})
+		return
+	}
+	ct := contactToDao(upd)
+	ct.Id = contactID
+	ct.OrgId = current.OrgId
+	if err = db.UpdateContact(ct); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, contactFromDao(ct))
+}
+
+// DeleteContactImpl - Deletes the contact person
+func DeleteContactImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	contactID, err := strconv.Atoi(c.Param("contactId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	if err = db.DeleteContact(contactID); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 70, Message: err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// checkContact validates the contact and normalizes its e-mail to the bare address,
+// as it is used for SMTP recipients as is. The display name of the e-mail, if any,
+// becomes the name of the contact unless the name is given.
+func checkContact(ct *Contact) error {
+	if ct.Name == "" && ct.Email == "" {
+		return fmt.Errorf("either name or e-mail of the contact must be specified")
+	}
+	if !dao.IsValidRole(ct.Role) {
+		return fmt.Errorf("invalid role %q, expected one of %s, %s, %s", ct.Role, dao.RoleTechnical, dao.RoleBilling, dao.RoleLicenseAdmin)
+	}
+	if ct.Email != "" {
+		addr, err := mail.ParseAddress(ct.Email)
+		if err != nil {
+			return fmt.Errorf("invalid e-mail %q: %s", ct.Email, err.Error())
+		}
+		ct.Email = addr.Address
+		if ct.Name == "" {
+			ct.Name = addr.Name
+		}
+	}
+	if (ct.NotifyExpiry || ct.NotifyFiles) && ct.Email == "" {
+		return fmt.Errorf("e-mail is required to receive notifications")
+	}
+	return nil
+}
+
+func contactFromDao(ct dao.Contact) Contact {
+	return Contact{Id: int32(ct.Id), ClientId: int32(ct.OrgId), Name: ct.Name, Email: ct.Email, Phone: ct.Phone, Role: ct.Role,
+		NotifyExpiry: ct.NotifyExpiry, NotifyFiles: ct.NotifyFiles}
+}
+
+func contactToDao(ct Contact) dao.Contact {
+	return dao.Contact{Id: int(ct.Id), OrgId: int(ct.ClientId), Name: ct.Name, Email: ct.Email, Phone: ct.Phone, Role: ct.Role,
+		NotifyExpiry: ct.NotifyExpiry, NotifyFiles: ct.NotifyFiles}
+}
@@ -0,0 +1,56 @@
+package openapi_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func TestCreateContactImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	c, w := newTestContext(db)
+	c.Params = []gin.Param{gin.Param{Key: "clientId", Value: "1"}}
+	c.Request, _ = http.NewRequest("PUT", "/v1/clients/1/contacts",
+		bytes.NewBufferString(`{"name": "Ivanov", "email": "ivanov@org1.ru", "role": "boss", "notifyExpiry": true}`))
+	openapi.CreateContactImpl(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	c, w = newTestContext(db)
+	c.Params = []gin.Param{gin.Param{Key: "clientId", Value: "1"}}
+	c.Request, _ = http.NewRequest("PUT", "/v1/clients/1/contacts",
+		bytes.NewBufferString(`{"name": "Ivanov", "email": "ivanov@org1.ru", "role": "licadmin", "notifyExpiry": true}`))
+	openapi.CreateContactImpl(c)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	contacts, err := db.Contacts(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(contacts))
+
+	// Only the bare address is stored, as it is used for SMTP recipients
+	c, w = newTestContext(db)
+	c.Params = []gin.Param{gin.Param{Key: "clientId", Value: "1"}}
+	c.Request, _ = http.NewRequest("PUT", "/v1/clients/1/contacts",
+		bytes.NewBufferString(`{"email": "Ivan Petrov <petrov@org1.ru>", "role": "licadmin", "notifyFiles": true}`))
+	openapi.CreateContactImpl(c)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	contacts, _ = db.Contacts(1)
+	if assert.Equal(t, 2, len(contacts)) {
+		assert.Equal(t, "petrov@org1.ru", contacts[1].Email)
+		assert.Equal(t, "Ivan Petrov", contacts[1].Name)
+	}
+
+	c, w = newTestContext(db)
+	c.Params = []gin.Param{gin.Param{Key: "contactId", Value: fmt.Sprint(contacts[0].Id)}}
+	c.Request, _ = http.NewRequest("PUT", "/v1/contacts/x",
+		bytes.NewBufferString(`{"name": "Ivanov", "email": "I. Ivanov <ivanov@org1.ru>", "role": "licadmin", "notifyExpiry": true}`))
+	openapi.UpdateContactImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	ct, _ := db.Contact(contacts[0].Id)
+	assert.Equal(t, "ivanov@org1.ru", ct.Email)
+	assert.Equal(t, "Ivanov", ct.Name)
+}
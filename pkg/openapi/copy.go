@@ -0,0 +1,132 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/webhook"
+)
+
+// Conflict strategies of copying license sets
+const (
+	copyMerge   = "merge"
+	copyReplace = "replace"
+)
+
+// CopyLicenseSetImpl - Copies all or the selected features of the source key to the key,
+// optionally with the count and dates overridden. With the merge strategy the features
+// copied replace the same features of the key and the other ones are kept, with replace
+// the license set of the key is replaced. The feature rules are applied to the new set,
+// in the dry run it is only returned with the rules fired.
+func CopyLicenseSetImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyID, sourceKeyID := c.Param("keyId"), c.Param("sourceKeyId")
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	// The body is optional, everything is copied as is without it
+	req := LicenseSetCopy{}
+	if c.Request.Body != nil {
+		body, err := c.GetRawData()
+		if err == nil && len(bytes.TrimSpace(body)) > 0 {
+			err = json.Unmarshal(body, &req)
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 200, Message: "Malformed input: " + err.Error()})
+			return
+		}
+	}
+	if keyID == sourceKeyID {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 200, Message: "the key cannot be copied to itself"})
+		return
+	}
+	if req.Strategy == "" {
+		req.Strategy = copyMerge
+	}
+	if req.Strategy != copyMerge && req.Strategy != copyReplace {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 200, Message: fmt.Sprintf("strategy must be one of %s, %s", copyMerge, copyReplace)})
+		return
+	}
+	var start, end time.Time
+	var err error
+	if req.Start != "" {
+		if start, err = time.Parse("2006-01-02", req.Start); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 200, Message: "Malformed input: " + err.Error()})
+			return
+		}
+	}
+	if req.End != "" {
+		if end, err = time.Parse("2006-01-02", req.End); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 200, Message: "Malformed input: " + err.Error()})
+			return
+		}
+	}
+	if req.Count < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 200, Message: "count must be positive"})
+		return
+	}
+	if _, err := db.KeyOfWhichOrg(sourceKeyID); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 201, Message: fmt.Sprintf("source key %s is not registered", sourceKeyID)})
+		return
+	}
+	revision, ok := ifMatchRevision(c, db, keyID, dryRun)
+	if !ok {
+		return
+	}
+	source, err := db.LicensesSetByKeyId(sourceKeyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	current, err := db.LicensesSetByKeyId(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+
+	copied := []dao.LicenseSetItem{}
+	if len(req.Features) == 0 {
+		copied = append(copied, source...)
+	}
+	for _, feature := range req.Features {
+		f, ok := findFeature(source, feature)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 200, Message: fmt.Sprintf("feature %s is not licensed to the source key %s", feature, sourceKeyID)})
+			return
+		}
+		copied = append(copied, f)
+	}
+	for i := range copied {
+		copied[i].KeyID = keyID
+		if req.Count > 0 {
+			copied[i].Count = int(req.Count)
+		}
+		if !start.IsZero() {
+			copied[i].Start = start
+		}
+		if !end.IsZero() {
+			copied[i].End = end
+		}
+	}
+	newLicset := []dao.LicenseSetItem{}
+	if req.Strategy == copyMerge {
+		for _, f := range current {
+			if _, ok := findFeature(copied, f.Feature); !ok {
+				newLicset = append(newLicset, f)
+			}
+		}
+	}
+	newLicset = append(newLicset, copied...)
+	newLicset, fired := featureRules(c).Apply(newLicset)
+	if !saveLicenseSet(c, db, keyID, newLicset, fired, revision, dryRun) {
+		return
+	}
+	emitLicenseSetChanged(c, db, keyID, webhook.OperationCopy)
+	c.JSON(http.StatusAccepted, "")
+}
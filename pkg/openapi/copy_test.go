@@ -0,0 +1,87 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func TestCopyLicenseSetImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	copyFrom := func(keyID string, sourceKeyID string, query string, body string) (int, openapi.Error) {
+		c, w := newTestContext(db)
+		c.Request, _ = http.NewRequest("POST", "/v1/keys/"+keyID+"/copyFrom/"+sourceKeyID+query, strings.NewReader(body))
+		withIfMatch(db, c.Request, keyID)
+		c.Params = []gin.Param{{Key: "keyId", Value: keyID}, {Key: "sourceKeyId", Value: sourceKeyID}}
+		openapi.CopyLicenseSetImpl(c)
+		res := openapi.Error{}
+		if w.Code != http.StatusAccepted && w.Code != http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res
+	}
+
+	// The whole set of 123abc goes to the key of the other client as is
+	code, _ := copyFrom("123cbc", "123abc", "", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	source, _ := db.LicensesSetByKeyId("123abc")
+	target, _ := db.LicensesSetByKeyId("123cbc")
+	if assert.Equal(t, len(source), len(target)) {
+		for i := range source {
+			source[i].KeyID = "123cbc"
+		}
+		assert.ElementsMatch(t, source, target)
+	}
+
+	// Merge keeps F4 of the target and overrides the values of F3 copied
+	assert.Nil(t, db.UpdateLicenseSet("123cbc", []dao.LicenseSetItem{{KeyID: "123cbc", Feature: "F4", Version: 20, Count: 1, Start: source[0].Start, End: source[0].End}}))
+	code, _ = copyFrom("123cbc", "123abc", "", `{"features": ["F3"], "count": 2, "start": "2020-01-01", "end": "2021-01-01"}`)
+	assert.Equal(t, http.StatusAccepted, code)
+	target, _ = db.LicensesSetByKeyId("123cbc")
+	if assert.Equal(t, 2, len(target)) {
+		f3 := target[0]
+		if f3.Feature != "F3" {
+			f3 = target[1]
+		}
+		assert.Equal(t, "F3", f3.Feature)
+		assert.Equal(t, 2, f3.Count)
+		assert.Equal(t, "2020-01-01", f3.Start.Format("2006-01-02"))
+		assert.Equal(t, "2021-01-01", f3.End.Format("2006-01-02"))
+	}
+
+	// Replace drops the other features, the dry run changes nothing
+	code, _ = copyFrom("123cbc", "123abc", "?dryRun=true", `{"features": ["P1"], "strategy": "replace"}`)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = copyFrom("123cbc", "123abc", "", `{"features": ["P1"], "strategy": "replace"}`)
+	assert.Equal(t, http.StatusAccepted, code)
+	target, _ = db.LicensesSetByKeyId("123cbc")
+	if assert.Equal(t, 1, len(target)) {
+		assert.Equal(t, "P1", target[0].Feature)
+	}
+
+	for _, tc := range []struct {
+		keyID  string
+		source string
+		body   string
+		status int
+		code   int32
+	}{
+		{"123cbc", "123cbc", "", http.StatusBadRequest, 200},
+		{"123cbc", "123abc", `{"strategy": "keep"}`, http.StatusBadRequest, 200},
+		{"123cbc", "123abc", `{"features": ["F1"]}`, http.StatusBadRequest, 200},
+		{"123cbc", "123abc", `{"end": "soon"}`, http.StatusBadRequest, 200},
+		{"123cbc", "nokey", "", http.StatusNotFound, 201},
+		// F1 of 123bbc is covered by P1
+		{"123cbc", "123bbc", "", http.StatusUnprocessableEntity, 160},
+	} {
+		code, res := copyFrom(tc.keyID, tc.source, "", tc.body)
+		assert.Equal(t, tc.status, code, tc)
+		assert.Equal(t, tc.code, res.Code, tc)
+	}
+}
@@ -0,0 +1,140 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// countChange is the new count of a feature, either absolute or relative to the current one
+type countChange struct {
+	value    int
+	relative bool
+}
+
+// apply returns the count changed
+func (ch countChange) apply(count int) int {
+	if ch.relative {
+		return count + ch.value
+	}
+	return ch.value
+}
+
+var countChangeRe = regexp.MustCompile(`^[+-]?[0-9]+$`)
+
+// parseCountChange parses the count of the feature, a number or a string like "+5" or "-2"
+func parseCountChange(raw json.RawMessage) (ch countChange, err error) {
+	if err = json.Unmarshal(raw, &ch.value); err == nil {
+		return
+	}
+	var s string
+	if err = json.Unmarshal(raw, &s); err != nil || !countChangeRe.MatchString(s) {
+		return ch, fmt.Errorf("must be a number or a relative change like +5 or -2")
+	}
+	ch.value, err = strconv.Atoi(s)
+	ch.relative = s[0] == '+' || s[0] == '-'
+	return
+}
+
+// parseCountChanges returns the count changes of the features of the license set, taken
+// either from the body mapping the features to their counts, or from setCount applied to
+// the features listed in restrictTo, all of them if it is empty. The request is aborted
+// with 400 if the input is malformed or lists features not licensed to the key. False is
+// returned if the request is complete.
+func parseCountChanges(c *gin.Context, licSet []dao.LicenseSetItem) (map[string]countChange, bool) {
+	abort := func(msg string) (map[string]countChange, bool) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 190, Message: msg})
+		return nil, false
+	}
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		if body, err = c.GetRawData(); err != nil {
+			return abort("unable to read counts: " + err.Error())
+		}
+	}
+	setCount := c.Query("setCount")
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && setCount != "" {
+		return abort("either setCount or the counts in the body must be given, not both")
+	}
+	res := map[string]countChange{}
+	switch {
+	case len(body) > 0:
+		counts := map[string]json.RawMessage{}
+		if err := json.Unmarshal(body, &counts); err != nil {
+			return abort("malformed counts: " + err.Error())
+		}
+		for feature, raw := range counts {
+			ch, err := parseCountChange(raw)
+			if err != nil {
+				return abort(fmt.Sprintf("count of %s %s", feature, err.Error()))
+			}
+			res[feature] = ch
+		}
+	case setCount != "":
+		count, err := strconv.Atoi(setCount)
+		if err != nil || count < 1 {
+			return abort(fmt.Sprintf("setCount must be a positive integer, got %s", setCount))
+		}
+		for _, f := range strings.Split(c.Query("restrictTo"), ",") {
+			if f != "" {
+				res[f] = countChange{value: count}
+			}
+		}
+		if len(res) == 0 {
+			for _, f := range licSet {
+				res[f.Feature] = countChange{value: count}
+			}
+		}
+	default:
+		return abort("either setCount or the counts in the body must be given")
+	}
+	unknown := []string{}
+	for feature := range res {
+		if _, ok := findFeature(licSet, feature); !ok {
+			unknown = append(unknown, feature)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return abort("features not licensed to the key: " + strings.Join(unknown, ", "))
+	}
+	return res, true
+}
+
+// changeCounts returns the license set with the counts changed, the request is aborted
+// with 422 and all the violations found if a count is not positive. The maximums
+// configured are checked along with the whole license set, see validateLicenseSets.
+// False is returned if the request is complete.
+func changeCounts(c *gin.Context, keyID string, licSet []dao.LicenseSetItem, changes map[string]countChange) ([]dao.LicenseSetItem, bool) {
+	res := []dao.LicenseSetItem{}
+	violations := []FieldError{}
+	for _, f := range licSet {
+		if ch, ok := changes[f.Feature]; ok {
+			f.Count = ch.apply(f.Count)
+			field := "counts." + f.Feature
+			if f.Count < 1 {
+				violations = append(violations, FieldError{KeyId: keyID, Field: field, Message: fmt.Sprintf("count must be positive, got %d", f.Count)})
+			}
+		}
+		res = append(res, f)
+	}
+	if len(violations) > 0 {
+		msg := fmt.Sprintf("invalid counts: %s: %s", violations[0].Field, violations[0].Message)
+		if len(violations) > 1 {
+			msg += fmt.Sprintf(" and %d more violation(s)", len(violations)-1)
+		}
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, Error{Code: 191, Message: msg, Errors: violations})
+		return nil, false
+	}
+	return res, true
+}
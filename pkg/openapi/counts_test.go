@@ -0,0 +1,148 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+	"github.com/vaefremov/pnglic/pkg/rules"
+)
+
+func TestChangeLicensesCountImplCounts(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	conf := &config.Config{MaxCounts: map[string]int{"P1": 30, "*": 100}}
+	change := func(query string, body string) (int, openapi.Error) {
+		c, w := newTestContext(db)
+		c.Set("conf", conf)
+		c.Request, _ = http.NewRequest("POST", "/v1/changeFeaturesCountForKey/123abc"+query, strings.NewReader(body))
+		withIfMatch(db, c.Request, "123abc")
+		c.Params = []gin.Param{{Key: "keyId", Value: "123abc"}}
+		openapi.ChangeLicensesCountImpl(c)
+		res := openapi.Error{}
+		if w.Code != http.StatusAccepted {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res
+	}
+	counts := func() map[string]int {
+		licSet, _ := db.LicensesSetByKeyId("123abc")
+		res := map[string]int{}
+		for _, f := range licSet {
+			res[f.Feature] = f.Count
+		}
+		return res
+	}
+
+	// The test key has P1 and F3, both with the count of 10
+	code, _ := change("", `{"P1": "+5", "F3": 40}`)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, map[string]int{"P1": 15, "F3": 40}, counts())
+
+	code, _ = change("", `{"F3": "-2"}`)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, map[string]int{"P1": 15, "F3": 38}, counts())
+
+	code, res := change("", `{"P1": "+16", "F3": "-38"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, int32(191), res.Code)
+	assert.Equal(t, []openapi.FieldError{
+		{KeyId: "123abc", Field: "counts.F3", Message: "count must be positive, got 0"},
+	}, res.Errors)
+
+	// The maximums are checked with the rest of the license set
+	code, res = change("", `{"P1": "+16"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, int32(160), res.Code)
+	assert.Equal(t, []openapi.FieldError{
+		{KeyId: "123abc", Field: "features[1].count", Message: "count 31 exceeds the maximum of 30"},
+	}, res.Errors)
+	code, res = change("?setCount=101", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, int32(160), res.Code)
+	assert.Equal(t, 2, len(res.Errors))
+
+	for _, tc := range []struct {
+		query string
+		body  string
+		msg   string
+	}{
+		{"", "", "either setCount or the counts in the body must be given"},
+		{"?setCount=5", `{"F3": 5}`, "either setCount or the counts in the body must be given, not both"},
+		{"?setCount=many", "", "setCount must be a positive integer, got many"},
+		{"?setCount=0", "", "setCount must be a positive integer, got 0"},
+		{"", `{"F3": "twice"}`, "count of F3 must be a number or a relative change like +5 or -2"},
+		{"", `{"F3": 1.5}`, "count of F3 must be a number or a relative change like +5 or -2"},
+		{"", `{"F3": 5, "F1": 5}`, "features not licensed to the key: F1"},
+		{"?setCount=5&restrictTo=F3,NOPE", "", "features not licensed to the key: NOPE"},
+	} {
+		code, res = change(tc.query, tc.body)
+		assert.Equal(t, http.StatusBadRequest, code, tc)
+		assert.Equal(t, openapi.Error{Code: 190, Message: tc.msg}, res, tc)
+	}
+	assert.Equal(t, map[string]int{"P1": 15, "F3": 38}, counts())
+}
+
+// The maximum counts hold for every handler changing the license sets
+func TestMaxCountsAllHandlers(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	conf := &config.Config{MaxCounts: map[string]int{"P1": 12, "F4": 4}}
+	e, err := rules.New([]rules.Rule{{Name: "packages", Feature: "P*", MinCount: 15}})
+	assert.Nil(t, err)
+	request := func(handler gin.HandlerFunc, method string, url string, keyID string, params []gin.Param, body string) (int, openapi.Error) {
+		c, w := newTestContext(db)
+		c.Set("conf", conf)
+		c.Set("rules", e)
+		c.Request, _ = http.NewRequest(method, url, strings.NewReader(body))
+		if keyID != "" {
+			withIfMatch(db, c.Request, keyID)
+		}
+		c.Params = params
+		handler(c)
+		res := openapi.Error{}
+		if w.Code >= 300 {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res
+	}
+	keyFeature := func(keyID string, feature string) []gin.Param {
+		return []gin.Param{{Key: "keyId", Value: keyID}, {Key: "feature", Value: feature}}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		handler gin.HandlerFunc
+		method  string
+		url     string
+		keyID   string
+		params  []gin.Param
+		body    string
+	}{
+		{"put", openapi.PutKeyFeatureImpl, "PUT", "/v1/keys/123abc/features/F4", "123abc", keyFeature("123abc", "F4"),
+			`{"countedFeature": {"version": 20, "count": 5}, "start": "2020-01-01", "end": "2021-01-01"}`},
+		{"patch", openapi.PatchKeyFeatureImpl, "PATCH", "/v1/keys/123abc/features/P1", "123abc", keyFeature("123abc", "P1"),
+			`{"count": 13}`},
+		{"copy", openapi.CopyLicenseSetImpl, "POST", "/v1/keys/123cbc/copyFrom/123abc", "123cbc",
+			[]gin.Param{{Key: "keyId", Value: "123cbc"}, {Key: "sourceKeyId", Value: "123abc"}}, `{"features": ["P1"], "count": 13}`},
+		{"update", openapi.UpdateLicensedFeaturesForKeyImpl, "POST", "/v1/licensedFeaturesForKey/123cbc", "123cbc",
+			[]gin.Param{{Key: "keyId", Value: "123cbc"}},
+			`[{"countedFeature": {"name": "P1", "version": 19, "count": 13}, "start": "2020-01-01", "end": "2021-01-01"}]`},
+		// The rule raises the count of P1 to 15
+		{"bulk", openapi.BulkProlongImpl, "POST", "/v1/bulk/prolong", "", nil, `{"features": ["P1"], "till": "2030-01-01", "revisions": {}}`},
+	} {
+		code, res := request(tc.handler, tc.method, tc.url, tc.keyID, tc.params, tc.body)
+		assert.Equal(t, http.StatusUnprocessableEntity, code, tc.name)
+		assert.Equal(t, int32(160), res.Code, tc.name)
+	}
+	for _, keyID := range []string{"123abc", "123bbc", "123cbc"} {
+		licSet, _ := db.LicensesSetByKeyId(keyID)
+		for _, f := range licSet {
+			assert.True(t, f.Count <= 30 && f.Feature != "F4" && f.End.Year() == 2008, f)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package openapi
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// utf8BOM precedes the CSV content so that spreadsheets recognize the encoding of client names
+const utf8BOM = "\xef\xbb\xbf"
+
+// exportFilter selects rows of the CSV exports. Keys and clients are exported if they
+// have at least one licensed feature matching the feature and the end dates.
+type exportFilter struct {
+	clientID int
+	feature  string
+	from     time.Time
+	to       time.Time
+}
+
+// hasFeatureFilter tells if the filter restricts licensed features
+func (f exportFilter) hasFeatureFilter() bool {
+	return f.feature != "" || !f.from.IsZero() || !f.to.IsZero()
+}
+
+func (f exportFilter) matchesClient(clientID int) bool {
+	return f.clientID < 0 || f.clientID == clientID
+}
+
+func (f exportFilter) matchesFeature(item dao.LicenseSetItem) bool {
+	if f.feature != "" && f.feature != item.Feature {
+		return false
+	}
+	if !f.from.IsZero() && item.End.Before(f.from) {
+		return false
+	}
+	return f.to.IsZero() || !item.End.After(f.to)
+}
+
+// parseExportFilter reads the filter from the query parameters clientId, feature, from and to
+func parseExportFilter(c *gin.Context) (res exportFilter, err error) {
+	res = exportFilter{clientID: -1, feature: c.Query("feature")}
+	if clientIDStr := c.Query("clientId"); clientIDStr != "" {
+		if res.clientID, err = strconv.Atoi(clientIDStr); err != nil {
+			return res, fmt.Errorf("invalid clientId: %s", clientIDStr)
+		}
+	}
+	if fromStr := c.Query("from"); fromStr != "" {
+		if res.from, err = time.Parse("2006-01-02", fromStr); err != nil {
+			return res, fmt.Errorf("invalid from date: %s", fromStr)
+		}
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		if res.to, err = time.Parse("2006-01-02", toStr); err != nil {
+			return res, fmt.Errorf("invalid to date: %s", toStr)
+		}
+	}
+	if !res.from.IsZero() && !res.to.IsZero() && res.to.Before(res.from) {
+		return res, fmt.Errorf("the period must end after it starts")
+	}
+	return
+}
+
+// licenseSetsOfKeys returns license sets of all keys mapped by key ID
+func licenseSetsOfKeys(db *dao.DbConn) (map[string][]dao.LicenseSetItem, error) {
+	all, err := db.LicenseSets()
+	if err != nil {
+		return nil, err
+	}
+	res := map[string][]dao.LicenseSetItem{}
+	for _, item := range all {
+		res[item.KeyID] = append(res[item.KeyID], item)
+	}
+	return res, nil
+}
+
+// matchingFeatures returns the features of the license set that match the filter
+func matchingFeatures(f exportFilter, licSet []dao.LicenseSetItem) (res []dao.LicenseSetItem) {
+	for _, item := range licSet {
+		if f.matchesFeature(item) {
+			res = append(res, item)
+		}
+	}
+	return
+}
+
+// writeCSV sends the records as a CSV file to be downloaded
+func writeCSV(c *gin.Context, fileName string, records [][]string) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	c.Status(http.StatusOK)
+	c.Writer.WriteString(utf8BOM)
+	w := csv.NewWriter(c.Writer)
+	if err := w.WriteAll(records); err != nil {
+		c.Error(err)
+	}
+}
+
+// ExportKeysImpl - Returns the list of keys as CSV. Retired keys are kept in the list
+// with the ID of the key that has replaced them.
+func ExportKeysImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 130, Message: err.Error()})
+		return
+	}
+	keys, err := db.Keys()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 1, Message: err.Error()})
+		return
+	}
+	clients, err := clientNames(db)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	licSets, err := licenseSetsOfKeys(db)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	replacements, err := db.KeyReplacements(0)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	replacedBy := map[string]string{}
+	for _, r := range replacements {
+		replacedBy[r.KeyID] = r.ReplacedBy
+	}
+	records := [][]string{{"keyId", "clientId", "clientName", "comments", "features", "nearestEnd", "replacedBy"}}
+	for _, k := range keys {
+		if !filter.matchesClient(k.OrgId) {
+			continue
+		}
+		features := licSets[k.Id]
+		if filter.hasFeatureFilter() {
+			if features = matchingFeatures(filter, features); len(features) == 0 {
+				continue
+			}
+		}
+		nearestEnd := ""
+		for i, item := range features {
+			if i == 0 || item.End.Format("2006-01-02") < nearestEnd {
+				nearestEnd = item.End.Format("2006-01-02")
+			}
+		}
+		records = append(records, []string{k.Id, strconv.Itoa(k.OrgId), clients[k.OrgId], k.Comments, strconv.Itoa(len(features)), nearestEnd, replacedBy[k.Id]})
+	}
+	writeCSV(c, "keys.csv", records)
+}
+
+// ExportClientsImpl - Returns the list of clients with their settings as CSV
+func ExportClientsImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 130, Message: err.Error()})
+		return
+	}
+	clients, err := db.Clients()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	settings, err := db.AllClientSettings()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	keys, err := db.Keys()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 1, Message: err.Error()})
+		return
+	}
+	licSets, err := licenseSetsOfKeys(db)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	keysOfClient := map[int]int{}
+	for _, k := range keys {
+		if !filter.hasFeatureFilter() || len(matchingFeatures(filter, licSets[k.Id])) > 0 {
+			keysOfClient[k.OrgId]++
+		}
+	}
+	records := [][]string{{"clientId", "name", "contacts", "comments", "keys", "graceDays", "notifyCustomer", "lang", "manager"}}
+	for _, cl := range clients {
+		if !filter.matchesClient(cl.Id) || filter.hasFeatureFilter() && keysOfClient[cl.Id] == 0 {
+			continue
+		}
+		s := settings.Get(cl.Id)
+		records = append(records, []string{strconv.Itoa(cl.Id), cl.Name, cl.Contacts, cl.Comments, strconv.Itoa(keysOfClient[cl.Id]),
+			strconv.Itoa(s.GraceDays), strconv.FormatBool(s.NotifyCustomer), s.Lang, s.Manager})
+	}
+	writeCSV(c, "clients.csv", records)
+}
+
+// ExportLicenseSetsImpl - Returns license sets of all keys as CSV, one feature per row
+func ExportLicenseSetsImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 130, Message: err.Error()})
+		return
+	}
+	keys, err := db.Keys()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 1, Message: err.Error()})
+		return
+	}
+	clients, err := clientNames(db)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	ownerOfKey := map[string]int{}
+	for _, k := range keys {
+		ownerOfKey[k.Id] = k.OrgId
+	}
+	keyID := c.Query("keyId")
+	if keyID != "" {
+		if _, ok := ownerOfKey[keyID]; !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 130, Message: "unknown key " + keyID})
+			return
+		}
+	}
+	all, err := db.LicenseSets()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	records := [][]string{{"keyId", "clientId", "clientName", "feature", "version", "start", "end", "count", "dupGroup"}}
+	for _, item := range all {
+		orgID := ownerOfKey[item.KeyID]
+		if keyID != "" && keyID != item.KeyID || !filter.matchesClient(orgID) || !filter.matchesFeature(item) {
+			continue
+		}
+		records = append(records, []string{item.KeyID, strconv.Itoa(orgID), clients[orgID], item.Feature,
+			strconv.FormatFloat(float64(item.Version), 'f', 2, 32), item.Start.Format("2006-01-02"), item.End.Format("2006-01-02"),
+			strconv.Itoa(item.Count), item.DupGroup})
+	}
+	writeCSV(c, "licensesets.csv", records)
+}
+
+// ExportHistoryImpl - Returns the list of issued license files as CSV, the files themselves are not included.
+// The history is filtered by client and the dates of issue.
+func ExportHistoryImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 130, Message: err.Error()})
+		return
+	}
+	to := time.Now()
+	if !filter.to.IsZero() {
+		// The end date is inclusive
+		to = filter.to.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	hist, err := db.IssuesBetween(filter.from, to)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	clients, err := clientNames(db)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	// Files issued before the client IDs were stored in the history have client names only.
+	// Names are not unique, so such files are matched to the owner of the key, unless the
+	// key has been transferred since, and to the name only if it is unambiguous.
+	idOfClient := map[string]int{}
+	for id, name := range clients {
+		if _, dup := idOfClient[name]; dup {
+			idOfClient[name] = -1
+		} else {
+			idOfClient[name] = id
+		}
+	}
+	records := [][]string{{"clientId", "clientName", "whenIssued", "keyId"}}
+	for _, h := range hist {
+		keyID, _ := ExtractKeyIDFromXML(h.ContentXml)
+		orgID := h.OrgID
+		if orgID < 0 {
+			cl, err := db.KeyOfWhichOrg(keyID)
+			if err != nil && err != sql.ErrNoRows {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+				return
+			}
+			if err == nil && cl.Name == h.ClientName {
+				orgID = cl.Id
+			} else if id, ok := idOfClient[h.ClientName]; ok {
+				orgID = id
+			}
+		}
+		if filter.clientID >= 0 && filter.clientID != orgID {
+			continue
+		}
+		clientID := ""
+		if orgID >= 0 {
+			clientID = strconv.Itoa(orgID)
+		}
+		records = append(records, []string{clientID, h.ClientName, h.IssueTime.Format(time.RFC3339), keyID})
+	}
+	writeCSV(c, "history.csv", records)
+}
+
+// clientNames maps client IDs to their names
+func clientNames(db *dao.DbConn) (map[int]string, error) {
+	clients, err := db.Clients()
+	if err != nil {
+		return nil, err
+	}
+	res := map[int]string{}
+	for _, cl := range clients {
+		res[cl.Id] = cl.Name
+	}
+	return res, nil
+}
@@ -0,0 +1,145 @@
+package openapi_test
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func exportCSV(t *testing.T, db *dao.DbConn, handler func(c *gin.Context), url string) (int, [][]string) {
+	c, w := newTestContext(db)
+	c.Request, _ = http.NewRequest("GET", url, nil)
+	handler(c)
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(w.Body.String(), "\xef\xbb\xbf"))).ReadAll()
+	assert.Nil(t, err)
+	return w.Code, records
+}
+
+func TestExportKeysImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	code, records := exportCSV(t, db, openapi.ExportKeysImpl, "/v1/export/keys.csv")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"keyId", "clientId", "clientName", "comments", "features", "nearestEnd", "replacedBy"}, records[0])
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, []string{"123abc", "1", "Org 1", "Comments key 1", "2", "2008-07-08", ""}, records[1])
+
+	_, records = exportCSV(t, db, openapi.ExportKeysImpl, "/v1/export/keys.csv?feature=F1")
+	if assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, "123bbc", records[1][0])
+		assert.Equal(t, "1", records[1][4])
+	}
+	_, records = exportCSV(t, db, openapi.ExportKeysImpl, "/v1/export/keys.csv?clientId=2")
+	assert.Equal(t, 2, len(records))
+	_, records = exportCSV(t, db, openapi.ExportKeysImpl, "/v1/export/keys.csv?from=2009-01-01")
+	assert.Equal(t, 1, len(records))
+
+	for _, query := range []string{"clientId=x", "from=2008-13-01", "from=2008-07-01&to=2008-06-01"} {
+		code, _ = exportCSV(t, db, openapi.ExportKeysImpl, "/v1/export/keys.csv?"+query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+func TestExportClientsImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	assert.Nil(t, db.SetClientSettings(dao.ClientSettings{OrgId: 2, GraceDays: 5, Lang: "ru", Manager: "petrov@pangea.ru"}))
+	_, records := exportCSV(t, db, openapi.ExportClientsImpl, "/v1/export/clients.csv")
+	if assert.Equal(t, 3, len(records)) {
+		assert.Equal(t, []string{"1", "Org 1", "Contact 1", `No comments for "Org 1"`, "2", "0", "false", "en", ""}, records[1])
+		assert.Equal(t, []string{"2", "Org 2", "Contact 1", `No comments for "Org 2"`, "1", "5", "false", "ru", "petrov@pangea.ru"}, records[2])
+	}
+	// Org 2 has no licensed features
+	_, records = exportCSV(t, db, openapi.ExportClientsImpl, "/v1/export/clients.csv?to=2020-01-01")
+	if assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, "1", records[1][0])
+	}
+}
+
+func TestExportLicenseSetsImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	_, records := exportCSV(t, db, openapi.ExportLicenseSetsImpl, "/v1/export/licensesets.csv")
+	if assert.Equal(t, 6, len(records)) {
+		assert.Equal(t, []string{"123abc", "1", "Org 1", "F3", "19.00", "2007-07-08", "2008-07-08", "10", "DISP"}, records[1])
+	}
+	_, records = exportCSV(t, db, openapi.ExportLicenseSetsImpl, "/v1/export/licensesets.csv?keyId=123bbc&feature=P1")
+	if assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, "20", records[1][7])
+	}
+	code, _ := exportCSV(t, db, openapi.ExportLicenseSetsImpl, "/v1/export/licensesets.csv?keyId=nokey")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestExportHistoryImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	_, records := exportCSV(t, db, openapi.ExportHistoryImpl, "/v1/export/history.csv")
+	assert.Equal(t, []string{"clientId", "clientName", "whenIssued", "keyId"}, records[0])
+	assert.Equal(t, 5, len(records))
+	_, records = exportCSV(t, db, openapi.ExportHistoryImpl, "/v1/export/history.csv?clientId=1&to=2018-04-26")
+	if assert.Equal(t, 3, len(records)) {
+		assert.Equal(t, "1", records[1][0])
+		assert.Equal(t, "2018-04-26T14:24:54Z", records[1][2])
+		assert.Contains(t, []string{"123ABC", "123BBC"}, records[1][3])
+	}
+}
+
+// Clients of the same name are told apart by the keys of the license files
+func TestExportHistoryImplSameName(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	res, err := db.Connx().Exec("insert into organizations (name, contact, comments) values ('Org 1', '', '')")
+	assert.Nil(t, err)
+	orgID, _ := res.LastInsertId()
+	assert.Nil(t, db.CreateKey(dao.HWKey{Id: "dupkey", OrgId: int(orgID)}))
+	assert.Nil(t, db.AddToHistory(int(orgID), time.Now(), `<?xml version="1.0"?><license_server port="1234" id="dupkey"></license_server>`))
+	assert.Nil(t, db.AddToHistory(1, time.Now(), `<?xml version="1.0"?><license_server port="1234" id="123abc"></license_server>`))
+
+	_, records := exportCSV(t, db, openapi.ExportHistoryImpl, fmt.Sprintf("/v1/export/history.csv?clientId=%d", orgID))
+	if assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, []string{fmt.Sprint(orgID), "Org 1", "dupkey"}, []string{records[1][0], records[1][1], records[1][3]})
+	}
+	_, records = exportCSV(t, db, openapi.ExportHistoryImpl, "/v1/export/history.csv?clientId=1")
+	keys := []string{}
+	for _, r := range records[1:] {
+		assert.Equal(t, "1", r[0])
+		keys = append(keys, r[3])
+	}
+	assert.Contains(t, keys, "123abc")
+	assert.NotContains(t, keys, "dupkey")
+}
+
+// The files issued before the key is transferred stay with the former owner
+func TestExportHistoryImplTransferredKey(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	assert.Nil(t, db.AddToHistory(1, time.Now(), `<?xml version="1.0"?><license_server port="1234" id="123abc"></license_server>`))
+	// Recorded before the client IDs were stored in the history
+	_, err := db.Connx().Exec(`insert into history (orgname, xml) values ('Org 1', '<?xml version="1.0"?><license_server port="1234" id="123bbc"></license_server>')`)
+	assert.Nil(t, err)
+	for _, keyID := range []string{"123abc", "123bbc"} {
+		_, err = db.TransferKey(keyID, 2)
+		assert.Nil(t, err)
+	}
+
+	_, records := exportCSV(t, db, openapi.ExportHistoryImpl, "/v1/export/history.csv?clientId=2")
+	for _, r := range records[1:] {
+		assert.Equal(t, []string{"2", "Org 2"}, r[:2])
+		assert.NotContains(t, []string{"123abc", "123bbc"}, r[3])
+	}
+	_, records = exportCSV(t, db, openapi.ExportHistoryImpl, "/v1/export/history.csv?clientId=1")
+	keys := []string{}
+	for _, r := range records[1:] {
+		assert.Equal(t, []string{"1", "Org 1"}, r[:2])
+		keys = append(keys, r[3])
+	}
+	assert.Contains(t, keys, "123abc")
+	assert.Contains(t, keys, "123bbc")
+}
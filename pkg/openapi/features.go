@@ -9,7 +9,10 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/config"
 	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/rules"
+	"github.com/vaefremov/pnglic/pkg/webhook"
 )
 
 // LicensedFeaturesForKeyImpl - Returns list of all license features related to a given key
@@ -21,14 +24,8 @@ func LicensedFeaturesForKeyImpl(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
 		return
 	}
-	res := []LicensedFeature{}
-	for _, f := range tmp {
-		res = append(res, LicensedFeature{CountedFeature: CountedFeature{
-			Name:    f.Feature,
-			Version: f.Version, Count: int32(f.Count), DupGroup: f.DupGroup},
-			Start: f.Start.Format("2006-01-02"), End: f.End.Format("2006-01-02")})
-	}
-	c.JSON(http.StatusOK, res)
+	setLicenseSetETag(c, db, keyID)
+	c.JSON(http.StatusOK, licensedFeatures(tmp))
 }
 
 // ListFeaturesImpl - Returns list of features
@@ -75,11 +72,19 @@ func PackageContentImpl(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
-// UpdateLicensedFeaturesForKeyImpl - Update license features for the given key ID, replace the previousely defined ones
+// UpdateLicensedFeaturesForKeyImpl - Update license features for the given key ID, replace the previousely defined ones.
+// The feature rules are applied to the new set, in the dry run it is only returned with the rules fired.
 func UpdateLicensedFeaturesForKeyImpl(c *gin.Context) {
 	db := c.MustGet("db").(*dao.DbConn)
 	keyID := c.Param("keyId")
-	// TODO: should check that keyId ia a valid key
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	revision, ok := ifMatchRevision(c, db, keyID, dryRun)
+	if !ok {
+		return
+	}
 	res := []LicensedFeature{}
 	err := c.BindJSON(&res)
 	if err != nil {
@@ -101,31 +106,39 @@ func UpdateLicensedFeaturesForKeyImpl(c *gin.Context) {
 		newFeature := dao.LicenseSetItem{KeyID: keyID, Feature: f.CountedFeature.Name, Version: f.CountedFeature.Version, Count: int(f.CountedFeature.Count), Start: start, End: end, DupGroup: f.CountedFeature.DupGroup}
 		newLicset = append(newLicset, newFeature)
 	}
-	err = db.UpdateLicenseSet(keyID, newLicset)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 20, Message: "Input rejected: " + err.Error()})
+	newLicset, fired := featureRules(c).Apply(newLicset)
+	if !saveLicenseSet(c, db, keyID, newLicset, fired, revision, dryRun) {
 		return
 	}
+	emitLicenseSetChanged(c, db, keyID, webhook.OperationUpdate)
 	c.JSON(http.StatusAccepted, "")
 }
 
 // ProlongLicensedFeaturesForKeyImpl - Update license features for the given key ID, replace the previousely defined ones
 // Also, the count of the issued features may be set to a number specified by the count parameter.
+// The features are prolonged either till the date or by the term in days, months or years
+// counted according to the mode: from now (the default), from the current end of
+// the feature or from the later of the two. The feature rules are applied to the
+// prolonged set, in the dry run it is only returned with the rules fired.
 func ProlongLicensedFeaturesForKeyImpl(c *gin.Context) {
 	db := c.MustGet("db").(*dao.DbConn)
 	keyID := c.Param("keyId")
-	var byMonths int
-	tillDate, err := time.Parse("2006-01-02", c.Query("till"))
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	revision, ok := ifMatchRevision(c, db, keyID, dryRun)
+	if !ok {
+		return
+	}
+	by, unit := c.Query("by"), c.Query("unit")
+	if by == "" && c.Query("byMonths") != "" {
+		by, unit = c.Query("byMonths"), prolongMonths
+	}
+	term, err := parseProlongTerm(c.Query("till"), by, unit, c.Query("mode"))
 	if err != nil {
-		byMonths, err = strconv.Atoi(c.Query("byMonths"))
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 30, Message: "extension term must be set with either byMonths or till parameters " + err.Error()})
-			return
-		}
-		if byMonths <= 0 {
-			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 30, Message: "invalid extension term (<= 0)"})
-			return
-		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 30, Message: err.Error()})
+		return
 	}
 
 	var newVersion float64 = 0.
@@ -150,78 +163,198 @@ func ProlongLicensedFeaturesForKeyImpl(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
 		return
 	}
+	engine := featureRules(c)
+	now := time.Now()
 	newLicset := []dao.LicenseSetItem{}
+	fired := []rules.Fired{}
 	for _, f := range currentLicSet {
 		fnew := f
 		if len(featuresSet) == 0 || featuresSet[f.Feature] {
-			// we should have
-			if byMonths > 0 {
-				// fnew.End = f.End.AddDate(0, byMonths, 0)
-				tillDate = time.Now().AddDate(0, byMonths, 0)
-			}
-			fnew.End = tillDate
-
-			if newVersion > 0.0 {
-				fnew.Version = float32(newVersion)
-			}
-			// LM_CONSOLE requires special treatment
-			// @TODO: We should make sure the LM_CONSOLE is present
-			if fnew.Feature == "LM_CONSOLE" {
-				fnew.Version = 1.0
-				// We intentionally add one extra year for LM_CONSOLE!
-				fnew.End = tillDate.AddDate(1, 0, 0)
-			}
+			var tmp []rules.Fired
+			fnew, tmp = engine.Prolong(f, term.end(engine.BaseEnd(f), now), float32(newVersion))
+			fired = append(fired, tmp...)
 		}
 		newLicset = append(newLicset, fnew)
 	}
-	err = db.UpdateLicenseSet(keyID, newLicset)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 20, Message: "Input rejected: " + err.Error()})
+	newLicset, tmp := engine.Apply(newLicset)
+	if !saveLicenseSet(c, db, keyID, newLicset, append(fired, tmp...), revision, dryRun) {
 		return
 	}
+	emitLicenseSetChanged(c, db, keyID, webhook.OperationProlong)
 	c.JSON(http.StatusAccepted, "")
 }
 
-func ChangeLicensesCountImpl(c *gin.Context) {
-	db := c.MustGet("db").(*dao.DbConn)
-	keyID := c.Param("keyId")
+// Modes of counting the extension term
+const (
+	prolongFromNow = "from-now"
+	prolongFromEnd = "from-current-end"
+	prolongFromMax = "from-max"
+)
 
-	var err error
-	var newCount int = 0
-	if newCountStr := c.Query("setCount"); newCountStr != "" {
-		if newCount, err = strconv.Atoi(newCountStr); err != nil {
-			newCount = 0
-			log.Println("Wrong value of the count parameter, ignored: ", err)
-		}
+// Units of the extension term
+const (
+	prolongDays   = "days"
+	prolongMonths = "months"
+	prolongYears  = "years"
+)
+
+// prolongTerm is the extension term of features: either the fixed date or the number
+// of days, months or years counted from now, from the current end of the feature or
+// from the later of the two, depending on the mode
+type prolongTerm struct {
+	till time.Time
+	by   int
+	unit string
+	mode string
+}
+
+// parseProlongTerm makes the extension term from the date or the number of units,
+// months are the default unit and from-now is the default mode
+func parseProlongTerm(till string, by string, unit string, mode string) (res prolongTerm, err error) {
+	res = prolongTerm{unit: unit, mode: mode}
+	if res.mode == "" {
+		res.mode = prolongFromNow
 	}
-	// Retrieve features from the request
-	featuresSet := map[string]bool{}
-	for _, f := range strings.Split(c.Query("restrictTo"), ",") {
-		if f != "" {
-			featuresSet[f] = true
+	if res.mode != prolongFromNow && res.mode != prolongFromEnd && res.mode != prolongFromMax {
+		return res, fmt.Errorf("mode must be one of %s, %s, %s", prolongFromNow, prolongFromEnd, prolongFromMax)
+	}
+	if till != "" {
+		if res.till, err = time.Parse("2006-01-02", till); err != nil {
+			return res, fmt.Errorf("invalid till date: %s", till)
 		}
+		return
+	}
+	if by == "" {
+		return res, fmt.Errorf("extension term must be set with either till or by parameters")
+	}
+	if res.by, err = strconv.Atoi(by); err != nil {
+		return res, fmt.Errorf("invalid extension term %s", by)
+	}
+	if res.by <= 0 {
+		return res, fmt.Errorf("invalid extension term (<= 0)")
+	}
+	if res.unit == "" {
+		res.unit = prolongMonths
 	}
+	if res.unit != prolongDays && res.unit != prolongMonths && res.unit != prolongYears {
+		return res, fmt.Errorf("unit must be one of %s, %s, %s", prolongDays, prolongMonths, prolongYears)
+	}
+	return
+}
 
-	currentLicSet, err := db.LicensesSetByKeyId(keyID)
+// end returns the new end date of the feature that currently ends at the given date.
+// The current end must not include the extra terms of the feature rules, see
+// rules.Engine.BaseEnd.
+func (t prolongTerm) end(current time.Time, now time.Time) time.Time {
+	if !t.till.IsZero() {
+		return t.till
+	}
+	from := now
+	if t.mode == prolongFromEnd || t.mode == prolongFromMax && current.After(now) {
+		from = current
+	}
+	switch t.unit {
+	case prolongDays:
+		return from.AddDate(0, 0, t.by)
+	case prolongYears:
+		return from.AddDate(t.by, 0, 0)
+	default:
+		return from.AddDate(0, t.by, 0)
+	}
+}
+
+// saveLicenseSet validates and stores the new license set of the key provided it is still at
+// the revision given, in the dry run the set is returned with the rules fired instead.
+// False is returned if the request is complete.
+func saveLicenseSet(c *gin.Context, db *dao.DbConn, keyID string, licSet []dao.LicenseSetItem, fired []rules.Fired, revision int, dryRun bool) bool {
+	if !validateLicenseSets(c, db, map[string][]dao.LicenseSetItem{keyID: licSet}) {
+		return false
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, RulesPreview{Features: licensedFeatures(licSet), Fired: firedRules(keyID, fired)})
+		return false
+	}
+	if err := db.UpdateLicenseSetAtRevision(keyID, revision, licSet); err == dao.ErrStaleRevision {
+		abortStaleLicenseSet(c, db, keyID)
+		return false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 20, Message: "Input rejected: " + err.Error()})
+		return false
+	}
+	for _, f := range fired {
+		log.Println("Feature rule fired for key", keyID, f)
+	}
+	setLicenseSetETag(c, db, keyID)
+	return true
+}
+
+// validateLicenseSets checks the license sets before they are stored, the request is
+// aborted with all the violations found. False is returned if the request is complete.
+// Every handler changing license sets calls it, so the maximum counts configured cannot
+// be bypassed.
+func validateLicenseSets(c *gin.Context, db *dao.DbConn, sets map[string][]dao.LicenseSetItem) bool {
+	violations, err := db.ValidateLicenseSets(sets, maxCounts(c))
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return false
+	}
+	if len(violations) == 0 {
+		return true
+	}
+	res := Error{Code: 160, Message: "invalid license set: " + violations[0].Error()}
+	if len(violations) > 1 {
+		res.Message += fmt.Sprintf(" and %d more violation(s)", len(violations)-1)
+	}
+	for _, v := range violations {
+		res.Errors = append(res.Errors, FieldError{KeyId: v.KeyID, Field: v.Field, Message: v.Message})
+	}
+	c.AbortWithStatusJSON(http.StatusUnprocessableEntity, res)
+	return false
+}
+
+// maxCounts returns the maximum counts of features configured, nil if there is no config
+func maxCounts(c *gin.Context) func(feature string) int {
+	if conf, ok := c.Get("conf"); ok {
+		return conf.(*config.Config).MaxCount
+	}
+	return nil
+}
+
+// ChangeLicensesCountImpl - Changes the counts of the features of the key. The counts are
+// given either in the body, mapping the features to absolute counts or to relative
+// changes like "+5" or "-2", or with setCount for the features listed in restrictTo.
+// The counts must be positive and not exceed the maximums configured for the features.
+// The feature rules are applied to the changed set, in the dry run it is only returned
+// with the rules fired.
+func ChangeLicensesCountImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyID := c.Param("keyId")
+	dryRun, ok := parseDryRun(c)
+	if !ok {
 		return
 	}
-	newLicset := []dao.LicenseSetItem{}
-	for _, f := range currentLicSet {
-		fnew := f
-		if len(featuresSet) == 0 || featuresSet[f.Feature] {
-			if newCount > 0 {
-				fnew.Count = newCount
-			}
-		}
-		newLicset = append(newLicset, fnew)
+	revision, ok := ifMatchRevision(c, db, keyID, dryRun)
+	if !ok {
+		return
 	}
-	err = db.UpdateLicenseSet(keyID, newLicset)
+	currentLicSet, err := db.LicensesSetByKeyId(keyID)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 20, Message: "Input rejected: " + err.Error()})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	changes, ok := parseCountChanges(c, currentLicSet)
+	if !ok {
+		return
+	}
+	newLicset, ok := changeCounts(c, keyID, currentLicSet, changes)
+	if !ok {
+		return
+	}
+	newLicset, fired := featureRules(c).Apply(newLicset)
+	if !saveLicenseSet(c, db, keyID, newLicset, fired, revision, dryRun) {
 		return
 	}
+	emitLicenseSetChanged(c, db, keyID, webhook.OperationCount)
 	c.JSON(http.StatusAccepted, "")
 }
 
@@ -14,6 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/vaefremov/pnglic/pkg/dao"
 	"github.com/vaefremov/pnglic/pkg/openapi"
+	"github.com/vaefremov/pnglic/pkg/rules"
 )
 
 func TestProlongLicensedFeaturesForKeyImpl(t *testing.T) {
@@ -31,6 +32,7 @@ func TestProlongLicensedFeaturesForKeyImpl(t *testing.T) {
 	c, w = newTestContext(db)
 	buf := new(bytes.Buffer)
 	c.Request, _ = http.NewRequest("POST", "/v1/prolongLicensedFeaturesForKey/123abc?till=2018-04-30", buf)
+	withIfMatch(db, c.Request, "123abc")
 	c.Params = []gin.Param{gin.Param{Key: "keyId", Value: "123abc"}}
 	openapi.ProlongLicensedFeaturesForKeyImpl(c)
 	if w.Code != 202 {
@@ -55,6 +57,7 @@ func TestProlongLicensedFeaturesForKeyImpl(t *testing.T) {
 	c, w = newTestContext(db)
 	buf = new(bytes.Buffer)
 	c.Request, _ = http.NewRequest("POST", "/v1/prolongLicensedFeaturesForKey/123abc?byMonths=10", buf)
+	withIfMatch(db, c.Request, "123abc")
 	c.Params = []gin.Param{gin.Param{Key: "keyId", Value: "123abc"}}
 	openapi.ProlongLicensedFeaturesForKeyImpl(c)
 	if w.Code != 202 {
@@ -79,6 +82,122 @@ func TestProlongLicensedFeaturesForKeyImpl(t *testing.T) {
 	// t.Error("nil")
 }
 
+func TestProlongLicensedFeaturesForKeyImplModes(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	prolong := func(query string) int {
+		c, w := newTestContext(db)
+		c.Request, _ = http.NewRequest("POST", "/v1/prolongLicensedFeaturesForKey/123abc?"+query, new(bytes.Buffer))
+		withIfMatch(db, c.Request, "123abc")
+		c.Params = []gin.Param{gin.Param{Key: "keyId", Value: "123abc"}}
+		openapi.ProlongLicensedFeaturesForKeyImpl(c)
+		return w.Code
+	}
+	ends := func() (res []string) {
+		c, w := newTestContext(db)
+		c.Params = []gin.Param{gin.Param{Key: "keyId", Value: "123abc"}}
+		openapi.LicensedFeaturesForKey(c)
+		features := []openapi.LicensedFeature{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &features))
+		for _, f := range features {
+			res = append(res, f.End)
+		}
+		return
+	}
+
+	// The features of the test key end on 2008-07-08
+	assert.Equal(t, 202, prolong("by=10&unit=days&mode=from-current-end"))
+	assert.Equal(t, []string{"2008-07-18", "2008-07-18"}, ends())
+
+	// The current end is in the past, so from-max counts from now
+	assert.Equal(t, 202, prolong("by=1&unit=years&mode=from-max"))
+	expected := time.Now().AddDate(1, 0, 0).Format("2006-01-02")
+	assert.Equal(t, []string{expected, expected}, ends())
+
+	// Now the current end is in the future and wins
+	assert.Equal(t, 202, prolong("by=2&unit=months&mode=from-max"))
+	expected = time.Now().AddDate(1, 2, 0).Format("2006-01-02")
+	assert.Equal(t, []string{expected, expected}, ends())
+
+	// byMonths is still accepted
+	assert.Equal(t, 202, prolong("byMonths=3"))
+	expected = time.Now().AddDate(0, 3, 0).Format("2006-01-02")
+	assert.Equal(t, []string{expected, expected}, ends())
+
+	assert.Equal(t, 400, prolong("by=1&mode=from-somewhere"))
+	assert.Equal(t, 400, prolong("by=1&unit=weeks"))
+	assert.Equal(t, 400, prolong("by=0&unit=days"))
+	assert.Equal(t, []string{expected, expected}, ends())
+}
+
+// The extra term of LM_CONSOLE is added once, not again with every prolongation
+// counted from the current end
+func TestProlongFromCurrentEndExtraMonths(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	_, err := db.CreateOrUpdateFeature("LM_CONSOLE", "Console", false)
+	assert.Nil(t, err)
+	start, _ := time.Parse("2006-01-02", "2020-01-01")
+	end, _ := time.Parse("2006-01-02", "2030-01-01")
+	assert.Nil(t, db.UpdateLicenseSet("123cbc", []dao.LicenseSetItem{
+		{KeyID: "123cbc", Feature: "F3", Version: 20, Count: 1, Start: start, End: end},
+		{KeyID: "123cbc", Feature: "LM_CONSOLE", Version: 1, Count: 1, Start: start, End: end.AddDate(1, 0, 0)},
+	}))
+	prolong := func(mode string) {
+		c, w := newTestContext(db)
+		c.Set("rules", rules.Default())
+		c.Request, _ = http.NewRequest("POST", "/v1/prolongLicensedFeaturesForKey/123cbc?by=1&unit=years&mode="+mode, new(bytes.Buffer))
+		withIfMatch(db, c.Request, "123cbc")
+		c.Params = []gin.Param{{Key: "keyId", Value: "123cbc"}}
+		openapi.ProlongLicensedFeaturesForKeyImpl(c)
+		assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	}
+	for i, mode := range []string{"from-current-end", "from-current-end", "from-max"} {
+		prolong(mode)
+		licSet, _ := db.LicensesSetByKeyId("123cbc")
+		ends := map[string]time.Time{}
+		for _, f := range licSet {
+			ends[f.Feature] = f.End
+		}
+		assert.Equal(t, end.AddDate(i+1, 0, 0), ends["F3"], mode)
+		assert.Equal(t, ends["F3"].AddDate(1, 0, 0), ends["LM_CONSOLE"], mode)
+	}
+}
+
+func TestUpdateLicensedFeaturesForKeyImplValidation(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	update := func(keyID string, body string) (int, openapi.Error) {
+		c, w := newTestContext(db)
+		c.Request, _ = http.NewRequest("POST", "/v1/licensedFeaturesForKey/"+keyID, bytes.NewBufferString(body))
+		withIfMatch(db, c.Request, keyID)
+		c.Params = []gin.Param{gin.Param{Key: "keyId", Value: keyID}}
+		openapi.UpdateLicensedFeaturesForKeyImpl(c)
+		res := openapi.Error{}
+		if w.Code != http.StatusAccepted {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res
+	}
+
+	code, res := update("123abc", `[{"countedFeature": {"name": "F1", "version": 19, "count": 0}, "start": "2020-01-01", "end": "2019-01-01"},
+		{"countedFeature": {"name": "P1", "version": 19, "count": 1}, "start": "2020-01-01", "end": "2021-01-01"}]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, int32(160), res.Code)
+	assert.Equal(t, "invalid license set: 123abc: features[0].feature: feature F1 is also covered by package P1 and 2 more violation(s)", res.Message)
+	assert.Equal(t, []openapi.FieldError{
+		{KeyId: "123abc", Field: "features[0].feature", Message: "feature F1 is also covered by package P1"},
+		{KeyId: "123abc", Field: "features[0].end", Message: "end must be after start"},
+		{KeyId: "123abc", Field: "features[0].count", Message: "count must be positive"},
+	}, res.Errors)
+
+	code, res = update("nokey", `[]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, []openapi.FieldError{{KeyId: "nokey", Field: "keyId", Message: "key is not registered"}}, res.Errors)
+
+	code, _ = update("123abc", `[{"countedFeature": {"name": "F1", "version": 19, "count": 1}, "start": "2020-01-01", "end": "2021-01-01"}]`)
+	assert.Equal(t, http.StatusAccepted, code)
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+	assert.Equal(t, 1, len(licSet))
+}
+
 func TestChangeLicensesCountImpl(t *testing.T) {
 	db := dao.MustInMemoryTestPool()
 	c, w := newTestContext(db)
@@ -89,6 +208,7 @@ func TestChangeLicensesCountImpl(t *testing.T) {
 	buf := new(bytes.Buffer)
 	var expCount int32 = 20
 	c.Request, _ = http.NewRequest("POST", fmt.Sprintf("/v1/prolongLicensedFeaturesForKey/123abc?setCount=%d", expCount), buf)
+	withIfMatch(db, c.Request, "123abc")
 	openapi.ChangeLicensesCount(c)
 	if w.Code != 202 {
 		t.Error("Return code not OK", w.Code, w.Body)
@@ -118,6 +238,7 @@ func TestChangeLicensesCountImpl_RestrictTo(t *testing.T) {
 	var expCount_unchanged int32 = 10
 
 	c.Request, _ = http.NewRequest("POST", fmt.Sprintf("/v1/prolongLicensedFeaturesForKey/123abc?setCount=%d&restrictTo=P1", expCount_changed), buf)
+	withIfMatch(db, c.Request, "123abc")
 	openapi.ChangeLicensesCount(c)
 	if w.Code != 202 {
 		t.Error("Return code not OK", w.Code, w.Body)
@@ -142,3 +263,11 @@ func newTestContext(db *dao.DbConn) (*gin.Context, *httptest.ResponseRecorder) {
 	c.Set("db", db)
 	return c, w
 }
+
+// withIfMatch sets the If-Match header of the request to the current ETag of the
+// license set of the key
+func withIfMatch(db *dao.DbConn, req *http.Request, keyID string) *http.Request {
+	revision, _ := db.LicenseSetRevision(keyID)
+	req.Header.Set("If-Match", fmt.Sprintf("%q", fmt.Sprint(revision)))
+	return req
+}
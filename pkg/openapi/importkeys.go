@@ -0,0 +1,192 @@
+package openapi
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/webhook"
+)
+
+// defaultKeyKind is the kind of keys that have no kind specified
+const defaultKeyKind = "HASP"
+
+// maxImportedKeys limits the number of keys imported at once
+const maxImportedKeys = 1000
+
+// Statuses of imported rows
+const (
+	importOk    = "ok"
+	importError = "error"
+)
+
+// keyIDFormats are the formats of IDs of the supported kinds of keys
+var keyIDFormats = map[string]*regexp.Regexp{
+	"HASP":     regexp.MustCompile(`^[0-9A-Fa-f]{1,8}$`),
+	"GUARDANT": regexp.MustCompile(`^[0-9A-Fa-f]{8}$`),
+}
+
+// checkKeyID checks if the ID is valid for the kind of key, the kind is
+// returned in the canonical form
+func checkKeyID(kind string, id string) (string, error) {
+	kind = strings.ToUpper(strings.TrimSpace(kind))
+	if kind == "" {
+		kind = defaultKeyKind
+	}
+	format, ok := keyIDFormats[kind]
+	if !ok {
+		return kind, fmt.Errorf("unsupported key kind %q", kind)
+	}
+	if !format.MatchString(id) {
+		return kind, fmt.Errorf("invalid %s key ID %q", kind, id)
+	}
+	return kind, nil
+}
+
+// newHWKey returns the key to register, the ID is checked for the kind of key and the
+// comments are prefixed with the kind. Keys imported and registered as replacements are
+// created this way, CreateKeyImpl stores the keys as given.
+func newHWKey(kind string, id string, orgID int, comments string) (dao.HWKey, error) {
+	kind, err := checkKeyID(kind, id)
+	return dao.HWKey{Id: id, OrgId: orgID, Comments: kind + " " + comments}, err
+}
+
+// ImportKeysImpl - Creates keys listed in CSV or JSON in a single transaction
+func ImportKeysImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	var keys []HardwareKey
+	var err error
+	if strings.HasPrefix(c.ContentType(), "text/csv") {
+		keys, err = readKeysCSV(c.Request.Body)
+	} else {
+		err = c.ShouldBindJSON(&keys)
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 140, Message: err.Error()})
+		return
+	}
+	if len(keys) == 0 || len(keys) > maxImportedKeys {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 140, Message: fmt.Sprintf("from 1 to %d keys may be imported at once", maxImportedKeys)})
+		return
+	}
+	existing, err := db.Keys()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 1, Message: err.Error()})
+		return
+	}
+	clients, err := clientNames(db)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	res, newKeys := checkImportedKeys(keys, existing, clients)
+	res.DryRun = dryRun
+	if res.Failed > 0 {
+		status := http.StatusUnprocessableEntity
+		if dryRun {
+			status = http.StatusOK
+		}
+		c.JSON(status, res)
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	if err = db.CreateKeys(newKeys); err != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, Error{Code: 141, Message: err.Error()})
+		return
+	}
+	res.Imported = int32(len(newKeys))
+	for i, k := range newKeys {
+		emit(c, webhook.EventKeyCreated, webhook.KeyCreated{KeyID: k.Id, ClientID: k.OrgId, Comments: keys[i].Comments})
+	}
+	c.JSON(http.StatusCreated, res)
+}
+
+// checkImportedKeys checks every key: the ID must be valid for the kind of key and must
+// be neither registered nor repeated, the client must exist
+func checkImportedKeys(keys []HardwareKey, existing []dao.HWKey, clients map[int]string) (res KeyImportResult, newKeys []dao.HWKey) {
+	seen := map[string]bool{}
+	for _, k := range existing {
+		seen[strings.ToLower(k.Id)] = true
+	}
+	res.Rows = []KeyImportRow{}
+	for i, k := range keys {
+		k.Id = strings.TrimSpace(k.Id)
+		row := KeyImportRow{Row: int32(i + 1), Id: k.Id, Status: importOk}
+		key, err := newHWKey(k.Kind, k.Id, int(k.CurrentOwnerId), k.Comments)
+		switch {
+		case err != nil:
+			row.Error = err.Error()
+		case seen[strings.ToLower(k.Id)]:
+			row.Error = fmt.Sprintf("duplicate key ID %s", k.Id)
+		case clients[int(k.CurrentOwnerId)] == "":
+			row.Error = fmt.Sprintf("unknown client ID %d", k.CurrentOwnerId)
+		}
+		if k.Id != "" {
+			seen[strings.ToLower(k.Id)] = true
+		}
+		if row.Error != "" {
+			row.Status = importError
+			res.Failed++
+		} else {
+			newKeys = append(newKeys, key)
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return
+}
+
+// readKeysCSV reads keys from CSV with the header row, columns are id, kind,
+// clientId and comments in any order; kind and comments may be omitted
+func readKeysCSV(r io.Reader) (res []HardwareKey, err error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the CSV header: %s", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimPrefix(strings.TrimSpace(name), utf8BOM)] = i
+	}
+	for _, required := range []string{"id", "clientId"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("column %s is missing in the CSV header", required)
+		}
+	}
+	value := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		k := HardwareKey{Id: value(record, "id"), Kind: value(record, "kind"), Comments: value(record, "comments")}
+		// Rows with an invalid client ID are reported as ones of an unknown client
+		if clientID, err := strconv.Atoi(value(record, "clientId")); err == nil {
+			k.CurrentOwnerId = int32(clientID)
+		}
+		res = append(res, k)
+	}
+	return
+}
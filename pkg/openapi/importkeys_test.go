@@ -0,0 +1,83 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func importKeys(t *testing.T, db *dao.DbConn, query string, contentType string, body string) (int, openapi.KeyImportResult) {
+	c, w := newTestContext(db)
+	c.Request, _ = http.NewRequest("POST", "/v1/importKeys"+query, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", contentType)
+	openapi.ImportKeysImpl(c)
+	res := openapi.KeyImportResult{}
+	if w.Code < http.StatusBadRequest || w.Code == http.StatusUnprocessableEntity {
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res), w.Body.String())
+	}
+	return w.Code, res
+}
+
+func TestImportKeysImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	csvIn := "id,clientId,kind,comments\n" +
+		"aa01,1,HASP,batch 1\n" +
+		"aa02,2,,\n" +
+		"123abc,1,HASP,registered\n" +
+		"aa01,1,HASP,repeated\n" +
+		"aa03,20,HASP,unknown client\n" +
+		"aa04,1,GUARDANT,short ID\n" +
+		"xyz,1,HASP,not hex\n" +
+		"aa05,1,SOFT,\n"
+	code, res := importKeys(t, db, "?dryRun=true", "text/csv", csvIn)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, res.DryRun)
+	assert.Equal(t, int32(6), res.Failed)
+	if assert.Equal(t, 8, len(res.Rows)) {
+		assert.Equal(t, openapi.KeyImportRow{Row: 1, Id: "aa01", Status: "ok"}, res.Rows[0])
+		assert.Equal(t, "ok", res.Rows[1].Status)
+		for _, row := range res.Rows[2:] {
+			assert.Equal(t, "error", row.Status, row)
+		}
+		assert.Contains(t, res.Rows[2].Error, "duplicate")
+		assert.Contains(t, res.Rows[3].Error, "duplicate")
+		assert.Contains(t, res.Rows[4].Error, "unknown client")
+		assert.Contains(t, res.Rows[7].Error, "unsupported key kind")
+	}
+	// The import fails as a whole
+	code, res = importKeys(t, db, "", "text/csv", csvIn)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, int32(0), res.Imported)
+	keys, _ := db.Keys()
+	assert.Equal(t, 3, len(keys))
+
+	code, res = importKeys(t, db, "?dryRun=true", "text/csv", "id,clientId\naa01,1\naa02,2\n")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int32(0), res.Imported)
+	keys, _ = db.Keys()
+	assert.Equal(t, 3, len(keys))
+
+	code, res = importKeys(t, db, "", "text/csv", "id,clientId\naa01,1\naa02,2\n")
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, int32(2), res.Imported)
+	org, _ := db.KeyOfWhichOrg("aa02")
+	assert.Equal(t, 2, org.Id)
+
+	code, res = importKeys(t, db, "", "application/json", `[{"id": "0A0B0C0D", "kind": "guardant", "currentOwnerId": 1, "comments": "json"}]`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, int32(1), res.Imported)
+	keys, _ = db.KeysOfOrg(1)
+	assert.Contains(t, keys, dao.HWKey{Id: "0A0B0C0D", OrgId: 1, Comments: "GUARDANT json"})
+
+	code, _ = importKeys(t, db, "", "text/csv", "kind,comments\nHASP,x\n")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = importKeys(t, db, "", "application/json", `[]`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = importKeys(t, db, "?dryRun=maybe", "application/json", `[{"id": "aa", "currentOwnerId": 1}]`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,68 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/leader"
+	"github.com/vaefremov/pnglic/pkg/scheduler"
+)
+
+// ListJobsImpl - Returns the scheduled jobs and results of their last runs
+func ListJobsImpl(c *gin.Context) {
+	sched := c.MustGet("scheduler").(*scheduler.Scheduler)
+	res := []Job{}
+	for _, st := range sched.Jobs() {
+		res = append(res, jobFromStatus(st))
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// RunJobImpl - Runs the job immediately and waits for it to finish. Only the leader
+// of the instances sharing the database runs jobs, the others respond with 409.
+func RunJobImpl(c *gin.Context) {
+	sched := c.MustGet("scheduler").(*scheduler.Scheduler)
+	name := c.Param("name")
+	if tmp, ok := c.Get("leader"); ok && tmp.(*leader.Elector) != nil {
+		if elector := tmp.(*leader.Elector); !elector.IsLeader() {
+			msg := "this instance is not the leader, the jobs are run by the leader"
+			if holder, err := elector.Leader(); err == nil {
+				msg += " " + holder
+			}
+			c.AbortWithStatusJSON(http.StatusConflict, Error{Code: 113, Message: msg})
+			return
+		}
+	}
+	err := sched.RunNow(c.Request.Context(), name)
+	switch err {
+	case scheduler.ErrUnknownJob:
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 110, Message: fmt.Sprintf("unknown job %s", name)})
+		return
+	case scheduler.ErrJobRunning:
+		c.AbortWithStatusJSON(http.StatusConflict, Error{Code: 111, Message: fmt.Sprintf("job %s is running already", name)})
+		return
+	}
+	st, _ := sched.Job(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 112, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, jobFromStatus(st))
+}
+
+func jobFromStatus(st scheduler.Status) Job {
+	res := Job{Name: st.Name, Schedule: st.Schedule, Running: st.Running, LastError: st.LastError}
+	res.NextRun = optionalTime(st.Next)
+	res.LastStart = optionalTime(st.LastStart)
+	res.LastEnd = optionalTime(st.LastEnd)
+	return res
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,109 @@
+package openapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/leader"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+	"github.com/vaefremov/pnglic/pkg/scheduler"
+)
+
+func TestRunJobImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	sched := scheduler.New()
+	daily, _ := scheduler.Parse("09:00", nil)
+	runs := 0
+	sched.Add("expiry", daily, func(ctx context.Context) error {
+		runs++
+		return nil
+	})
+	sched.Add("failing", daily, func(ctx context.Context) error { return errors.New("mail server is down") })
+
+	c, w := newTestContext(db)
+	c.Set("scheduler", sched)
+	c.Params = []gin.Param{gin.Param{Key: "name", Value: "none"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/admin/jobs/none/run", nil)
+	openapi.RunJobImpl(c)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+	c, w = newTestContext(db)
+	c.Set("scheduler", sched)
+	c.Params = []gin.Param{gin.Param{Key: "name", Value: "failing"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/admin/jobs/failing/run", nil)
+	openapi.RunJobImpl(c)
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+
+	c, w = newTestContext(db)
+	c.Set("scheduler", sched)
+	c.Params = []gin.Param{gin.Param{Key: "name", Value: "expiry"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/admin/jobs/expiry/run", nil)
+	openapi.RunJobImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 1, runs)
+	job := openapi.Job{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &job))
+	assert.Equal(t, "expiry", job.Name)
+	assert.NotNil(t, job.LastEnd)
+	assert.NotNil(t, job.NextRun)
+
+	c, w = newTestContext(db)
+	c.Set("scheduler", sched)
+	c.Request, _ = http.NewRequest("GET", "/v1/admin/jobs", nil)
+	openapi.ListJobsImpl(c)
+	jobs := []openapi.Job{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+	assert.Equal(t, 2, len(jobs))
+	assert.Equal(t, "mail server is down", jobs[1].LastError)
+}
+
+func TestRunJobImplNotLeader(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	sched := scheduler.New()
+	daily, _ := scheduler.Parse("09:00", nil)
+	runs := 0
+	sched.Add("expiry", daily, func(ctx context.Context) error {
+		runs++
+		return nil
+	})
+	a := leader.New(db, "background")
+	a.TTL = 30 * time.Millisecond
+	b := leader.New(db, "background")
+	run := func(elector *leader.Elector) (int, openapi.Error) {
+		c, w := newTestContext(db)
+		c.Set("scheduler", sched)
+		c.Set("leader", elector)
+		c.Params = []gin.Param{{Key: "name", Value: "expiry"}}
+		c.Request, _ = http.NewRequest("POST", "/v1/admin/jobs/expiry/run", nil)
+		openapi.RunJobImpl(c)
+		res := openapi.Error{}
+		if w.Code != http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { a.Run(ctx, func(ctx context.Context) { <-ctx.Done() }); close(done) }()
+	assert.Eventually(t, a.IsLeader, time.Second, 5*time.Millisecond)
+
+	code, res := run(b)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, int32(113), res.Code)
+	assert.Equal(t, "this instance is not the leader, the jobs are run by the leader "+a.ID, res.Message)
+	assert.Equal(t, 0, runs)
+
+	code, _ = run(a)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, runs)
+	stop()
+	<-done
+}
@@ -0,0 +1,215 @@
+package openapi
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/webhook"
+)
+
+// PutKeyFeatureImpl - Adds the feature to the license set of the key or replaces it,
+// the other features of the key are left intact
+func PutKeyFeatureImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyID, feature := c.Param("keyId"), c.Param("feature")
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	revision, ok := ifMatchRevision(c, db, keyID, dryRun)
+	if !ok {
+		return
+	}
+	f := LicensedFeature{}
+	if err := c.BindJSON(&f); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 20, Message: "Malformed input: " + err.Error()})
+		return
+	}
+	if f.CountedFeature.Name != "" && f.CountedFeature.Name != feature {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 20, Message: fmt.Sprintf("feature name %s does not match %s", f.CountedFeature.Name, feature)})
+		return
+	}
+	item := dao.LicenseSetItem{KeyID: keyID, Feature: feature, Version: f.CountedFeature.Version, Count: int(f.CountedFeature.Count),
+		DupGroup: f.CountedFeature.DupGroup}
+	var err error
+	if item.Start, err = time.Parse("2006-01-02", f.Start); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 20, Message: "Malformed input: " + err.Error()})
+		return
+	}
+	if item.End, err = time.Parse("2006-01-02", f.End); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 20, Message: "Malformed input: " + err.Error()})
+		return
+	}
+	current, err := db.LicensesSetByKeyId(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	_, exists := findFeature(current, feature)
+	operation := webhook.OperationAdd
+	if exists {
+		operation = webhook.OperationChange
+	}
+	newSet, ok := saveKeyFeature(c, db, keyID, current, feature, &item, revision, dryRun, operation)
+	if !ok {
+		return
+	}
+	status := http.StatusCreated
+	if exists {
+		status = http.StatusOK
+	}
+	saved, _ := findFeature(newSet, feature)
+	c.JSON(status, licensedFeatures([]dao.LicenseSetItem{saved})[0])
+}
+
+// PatchKeyFeatureImpl - Changes the given fields of the feature licensed to the key
+func PatchKeyFeatureImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyID, feature := c.Param("keyId"), c.Param("feature")
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	revision, ok := ifMatchRevision(c, db, keyID, dryRun)
+	if !ok {
+		return
+	}
+	patch := LicensedFeaturePatch{}
+	if err := c.BindJSON(&patch); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 20, Message: "Malformed input: " + err.Error()})
+		return
+	}
+	current, err := db.LicensesSetByKeyId(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	item, exists := findFeature(current, feature)
+	if !exists {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 170, Message: fmt.Sprintf("feature %s is not licensed to key %s", feature, keyID)})
+		return
+	}
+	if patch.Version != nil {
+		item.Version = *patch.Version
+	}
+	if patch.Count != nil {
+		item.Count = int(*patch.Count)
+	}
+	if patch.DupGroup != nil {
+		item.DupGroup = *patch.DupGroup
+	}
+	if patch.Start != nil {
+		if item.Start, err = time.Parse("2006-01-02", *patch.Start); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 20, Message: "Malformed input: " + err.Error()})
+			return
+		}
+	}
+	if patch.End != nil {
+		if item.End, err = time.Parse("2006-01-02", *patch.End); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 20, Message: "Malformed input: " + err.Error()})
+			return
+		}
+	}
+	newSet, ok := saveKeyFeature(c, db, keyID, current, feature, &item, revision, dryRun, webhook.OperationChange)
+	if !ok {
+		return
+	}
+	saved, _ := findFeature(newSet, feature)
+	c.JSON(http.StatusOK, licensedFeatures([]dao.LicenseSetItem{saved})[0])
+}
+
+// DeleteKeyFeatureImpl - Removes the feature from the license set of the key
+func DeleteKeyFeatureImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyID, feature := c.Param("keyId"), c.Param("feature")
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	revision, ok := ifMatchRevision(c, db, keyID, dryRun)
+	if !ok {
+		return
+	}
+	current, err := db.LicensesSetByKeyId(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	if _, exists := findFeature(current, feature); !exists {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 170, Message: fmt.Sprintf("feature %s is not licensed to key %s", feature, keyID)})
+		return
+	}
+	if _, ok := saveKeyFeature(c, db, keyID, current, feature, nil, revision, dryRun, webhook.OperationRemove); !ok {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// findFeature returns the feature of the license set
+func findFeature(licSet []dao.LicenseSetItem, feature string) (dao.LicenseSetItem, bool) {
+	for _, f := range licSet {
+		if f.Feature == feature {
+			return f, true
+		}
+	}
+	return dao.LicenseSetItem{}, false
+}
+
+// saveKeyFeature replaces the feature in the license set of the key with the item, or
+// removes it if the item is nil, applies the feature rules and validates the set. Only
+// the features that differ from the current ones are stored provided the set is still at
+// the revision given, in the dry run the set is returned with the rules fired instead.
+// False is returned if the request is complete.
+func saveKeyFeature(c *gin.Context, db *dao.DbConn, keyID string, current []dao.LicenseSetItem, feature string,
+	item *dao.LicenseSetItem, revision int, dryRun bool, operation string) ([]dao.LicenseSetItem, bool) {
+	newSet := []dao.LicenseSetItem{}
+	for _, f := range current {
+		if f.Feature != feature {
+			newSet = append(newSet, f)
+		}
+	}
+	if item != nil {
+		newSet = append(newSet, *item)
+	}
+	newSet, fired := featureRules(c).Apply(newSet)
+	if _, exists := findFeature(newSet, feature); item == nil && exists {
+		c.AbortWithStatusJSON(http.StatusConflict, Error{Code: 171, Message: fmt.Sprintf("feature %s is always included by the feature rules", feature)})
+		return nil, false
+	}
+	if !validateLicenseSets(c, db, map[string][]dao.LicenseSetItem{keyID: newSet}) {
+		return nil, false
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, RulesPreview{Features: licensedFeatures(newSet), Fired: firedRules(keyID, fired)})
+		return nil, false
+	}
+	save := []dao.LicenseSetItem{}
+	for _, f := range newSet {
+		if prev, ok := findFeature(current, f.Feature); !ok || prev != f {
+			save = append(save, f)
+		}
+	}
+	remove := []string{}
+	for _, f := range current {
+		if _, ok := findFeature(newSet, f.Feature); !ok {
+			remove = append(remove, f.Feature)
+		}
+	}
+	if err := db.ChangeLicenseSetItems(keyID, revision, save, remove); err == dao.ErrStaleRevision {
+		abortStaleLicenseSet(c, db, keyID)
+		return nil, false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 20, Message: "Input rejected: " + err.Error()})
+		return nil, false
+	}
+	for _, f := range fired {
+		log.Println("Feature rule fired for key", keyID, f)
+	}
+	setLicenseSetETag(c, db, keyID)
+	emitLicenseSetChanged(c, db, keyID, operation)
+	return newSet, true
+}
@@ -0,0 +1,110 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+	"github.com/vaefremov/pnglic/pkg/rules"
+)
+
+func keyFeatureRequest(db *dao.DbConn, e *rules.Engine, handler gin.HandlerFunc, method string, keyID string, feature string, query string, body string) (int, []byte) {
+	c, w := newTestContext(db)
+	if e != nil {
+		c.Set("rules", e)
+	}
+	c.Request, _ = http.NewRequest(method, "/v1/keys/"+keyID+"/features/"+feature+query, strings.NewReader(body))
+	withIfMatch(db, c.Request, keyID)
+	c.Params = []gin.Param{{Key: "keyId", Value: keyID}, {Key: "feature", Value: feature}}
+	handler(c)
+	return c.Writer.Status(), w.Body.Bytes()
+}
+
+func TestPutKeyFeatureImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	put := func(feature string, body string) (int, openapi.LicensedFeature) {
+		code, resp := keyFeatureRequest(db, nil, openapi.PutKeyFeatureImpl, "PUT", "123abc", feature, "", body)
+		res := openapi.LicensedFeature{}
+		if code < 300 {
+			assert.Nil(t, json.Unmarshal(resp, &res))
+		}
+		return code, res
+	}
+
+	code, res := put("F4", `{"countedFeature": {"version": 20, "count": 3}, "start": "2020-01-01", "end": "2021-01-01"}`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, openapi.LicensedFeature{CountedFeature: openapi.CountedFeature{Name: "F4", Version: 20, Count: 3}, Start: "2020-01-01", End: "2021-01-01"}, res)
+
+	code, res = put("F4", `{"countedFeature": {"name": "F4", "version": 20, "count": 5}, "start": "2020-01-01", "end": "2022-01-01"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int32(5), res.CountedFeature.Count)
+
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+	assert.Equal(t, 3, len(licSet))
+
+	code, _ = put("F4", `{"countedFeature": {"name": "F2", "version": 20, "count": 5}, "start": "2020-01-01", "end": "2022-01-01"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = put("F4", `{"countedFeature": {"version": 20, "count": 5}, "start": "2020-01-01", "end": "soon"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	// F1 is covered by P1
+	code, _ = put("F1", `{"countedFeature": {"version": 20, "count": 5}, "start": "2020-01-01", "end": "2022-01-01"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+}
+
+func TestPatchKeyFeatureImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	code, body := keyFeatureRequest(db, nil, openapi.PatchKeyFeatureImpl, "PATCH", "123abc", "F3", "", `{"count": 7, "end": "2030-01-01"}`)
+	assert.Equal(t, http.StatusOK, code)
+	res := openapi.LicensedFeature{}
+	assert.Nil(t, json.Unmarshal(body, &res))
+	assert.Equal(t, openapi.LicensedFeature{CountedFeature: openapi.CountedFeature{Name: "F3", Version: 19, Count: 7, DupGroup: "DISP"},
+		Start: "2007-07-08", End: "2030-01-01"}, res)
+
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+	for _, f := range licSet {
+		assert.Equal(t, f.Feature == "F3", f.End.Year() == 2030, f)
+	}
+
+	// F1 of 123bbc is covered by P1, the other features of the key may still be changed
+	code, _ = keyFeatureRequest(db, nil, openapi.PatchKeyFeatureImpl, "PATCH", "123bbc", "F3", "", `{"count": 7}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = keyFeatureRequest(db, nil, openapi.PatchKeyFeatureImpl, "PATCH", "123abc", "F4", "", `{"count": 7}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = keyFeatureRequest(db, nil, openapi.PatchKeyFeatureImpl, "PATCH", "123abc", "F3", "", `{"count": 0}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	code, _ = keyFeatureRequest(db, nil, openapi.PatchKeyFeatureImpl, "PATCH", "123abc", "F3", "?dryRun=true", `{"count": 8}`)
+	assert.Equal(t, http.StatusOK, code)
+	licSet, _ = db.LicensesSetByKeyId("123abc")
+	for _, f := range licSet {
+		if f.Feature == "F3" {
+			assert.Equal(t, 7, f.Count)
+		}
+	}
+}
+
+func TestDeleteKeyFeatureImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	e, err := rules.New([]rules.Rule{{Feature: "P1", AlwaysIncluded: true}})
+	assert.Nil(t, err)
+
+	code, _ := keyFeatureRequest(db, e, openapi.DeleteKeyFeatureImpl, "DELETE", "123abc", "P1", "", "")
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = keyFeatureRequest(db, e, openapi.DeleteKeyFeatureImpl, "DELETE", "123abc", "F3", "", "")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = keyFeatureRequest(db, e, openapi.DeleteKeyFeatureImpl, "DELETE", "123abc", "F3", "", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+	if assert.Equal(t, 1, len(licSet)) {
+		assert.Equal(t, "P1", licSet[0].Feature)
+	}
+	// The other keys are intact
+	licSet, _ = db.LicensesSetByKeyId("123bbc")
+	assert.Equal(t, 3, len(licSet))
+}
@@ -0,0 +1,51 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/mailtmpl"
+)
+
+// PreviewMailTemplateImpl - Renders the mail template with sample data
+func PreviewMailTemplateImpl(c *gin.Context) {
+	conf := c.MustGet("conf").(*config.Config)
+	name := c.Param("name")
+	if !mailtmpl.IsValidName(name) {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 90, Message: fmt.Sprintf("unknown mail template %q, expected one of %s",
+			name, strings.Join(mailtmpl.Names(), ", "))})
+		return
+	}
+	lang := c.DefaultQuery("lang", conf.MailLang)
+	if !mailtmpl.IsValidLang(lang) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 90, Message: fmt.Sprintf("unsupported language %q", lang)})
+		return
+	}
+	data, err := mailtmpl.Sample(name, fmt.Sprintf("http://%s:%d", conf.PublicName, conf.Port))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 90, Message: err.Error()})
+		return
+	}
+	ml, err := mailtmpl.New(conf.StaticContent).Render(name, lang, data)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 90, Message: err.Error()})
+		return
+	}
+	switch c.Query("format") {
+	case "html":
+		if ml.HTML == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 90, Message: fmt.Sprintf("no HTML template for %s", name)})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(ml.HTML))
+	case "text":
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(ml.Text))
+	case "", "json":
+		c.JSON(http.StatusOK, MailPreview{Name: name, Lang: lang, Subject: ml.Subject, Text: ml.Text, Html: ml.HTML})
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: fmt.Sprintf("invalid format %q", c.Query("format"))})
+	}
+}
@@ -0,0 +1,53 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func TestPreviewMailTemplateImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	conf := &config.Config{StaticContent: "../../templates", MailLang: "en", PublicName: "some.host", Port: 9995}
+
+	c, w := newTestContext(db)
+	c.Set("conf", conf)
+	c.Params = []gin.Param{gin.Param{Key: "name", Value: "customer"}}
+	c.Request, _ = http.NewRequest("GET", "/v1/mailTemplates/customer/preview?lang=ru", nil)
+	openapi.PreviewMailTemplateImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	res := openapi.MailPreview{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "ru", res.Lang)
+	assert.Equal(t, "Срок действия ваших лицензий Pangea скоро закончится", res.Subject)
+	assert.Contains(t, res.Text, "Sample Client")
+	assert.Contains(t, res.Html, "<table")
+
+	c, w = newTestContext(db)
+	c.Set("conf", conf)
+	c.Params = []gin.Param{gin.Param{Key: "name", Value: "expiring"}}
+	c.Request, _ = http.NewRequest("GET", "/v1/mailTemplates/expiring/preview?format=html", nil)
+	openapi.PreviewMailTemplateImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+	c, w = newTestContext(db)
+	c.Set("conf", conf)
+	c.Params = []gin.Param{gin.Param{Key: "name", Value: "unknown"}}
+	c.Request, _ = http.NewRequest("GET", "/v1/mailTemplates/unknown/preview", nil)
+	openapi.PreviewMailTemplateImpl(c)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	c, w = newTestContext(db)
+	c.Set("conf", conf)
+	c.Params = []gin.Param{gin.Param{Key: "name", Value: "customer"}}
+	c.Request, _ = http.NewRequest("GET", "/v1/mailTemplates/customer/preview?lang=de", nil)
+	openapi.PreviewMailTemplateImpl(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
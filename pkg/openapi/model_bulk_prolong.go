@@ -0,0 +1,98 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+type BulkProlongRequest struct {
+	// Select features of keys of the clients
+	ClientIds []int32 `json:"clientIds,omitempty"`
+
+	// Select the features or packages
+	Features []string `json:"features,omitempty"`
+
+	// Select features ending before the date (YYYY-MM-DD)
+	EndBefore string `json:"endBefore,omitempty"`
+
+	// Prolong by the number of months, same as by with months unit
+	ByMonths int32 `json:"byMonths,omitempty"`
+
+	// Prolong by the number of units
+	By int32 `json:"by,omitempty"`
+
+	// days, months (the default) or years
+	Unit string `json:"unit,omitempty"`
+
+	// Count the term from-now (the default), from-current-end or from-max of the two
+	Mode string `json:"mode,omitempty"`
+
+	// Prolong till the date (YYYY-MM-DD)
+	Till string `json:"till,omitempty"`
+
+	// Set the version of the features
+	SetVersion float32 `json:"setVersion,omitempty"`
+
+	// Issue new license files of the keys
+	IssueFiles bool `json:"issueFiles"`
+
+	// Mail the new license files to the contacts of the clients
+	MailFiles bool `json:"mailFiles"`
+
+	// Revisions of the license sets of the keys as returned by the dry run, required unless it is a dry run
+	Revisions map[string]int32 `json:"revisions,omitempty"`
+}
+
+type BulkProlongResult struct {
+	// The features were only selected, not prolonged
+	DryRun bool `json:"dryRun"`
+
+	// Number of keys affected
+	Keys int32 `json:"keys"`
+
+	Rows []BulkProlongRow `json:"rows"`
+
+	// Feature rules fired for the prolonged keys
+	Fired []FiredRule `json:"fired"`
+
+	Files []BulkIssuedFile `json:"files"`
+
+	// Revisions of the license sets of the affected keys, the current ones in the dry run and the new ones otherwise
+	Revisions map[string]int32 `json:"revisions"`
+}
+
+type BulkProlongRow struct {
+	KeyId string `json:"keyId"`
+
+	ClientId int32 `json:"clientId"`
+
+	ClientName string `json:"clientName"`
+
+	Feature string `json:"feature"`
+
+	// YYYY-MM-DD date
+	OldEnd string `json:"oldEnd"`
+
+	// YYYY-MM-DD date
+	NewEnd string `json:"newEnd"`
+
+	OldVersion float32 `json:"oldVersion"`
+
+	NewVersion float32 `json:"newVersion"`
+}
+
+type BulkIssuedFile struct {
+	KeyId string `json:"keyId"`
+
+	ClientId int32 `json:"clientId"`
+
+	// Addresses the file is mailed to
+	MailedTo []string `json:"mailedTo,omitempty"`
+
+	// Error of issuing or mailing the file, empty on success
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,24 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+type ClientSettings struct {
+	// Number of days an expired license is still tolerated
+	GraceDays int32 `json:"graceDays"`
+
+	// Send expiry notices to the contacts of the client
+	NotifyCustomer bool `json:"notifyCustomer"`
+
+	// Language of mail sent to the client, en or ru
+	Lang string `json:"lang,omitempty"`
+
+	// Account manager responsible for the client, used to filter the expiry calendar
+	Manager string `json:"manager,omitempty"`
+}
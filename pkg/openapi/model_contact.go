@@ -0,0 +1,31 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+type Contact struct {
+	Id int32 `json:"id,omitempty"`
+
+	ClientId int32 `json:"clientId,omitempty"`
+
+	Name string `json:"name"`
+
+	Email string `json:"email,omitempty"`
+
+	Phone string `json:"phone,omitempty"`
+
+	// One of technical, billing, licadmin
+	Role string `json:"role"`
+
+	// The contact wants to receive expiry notices
+	NotifyExpiry bool `json:"notifyExpiry"`
+
+	// The contact wants to receive license files
+	NotifyFiles bool `json:"notifyFiles"`
+}
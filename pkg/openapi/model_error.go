@@ -13,4 +13,16 @@ type Error struct {
 	Code int32 `json:"code"`
 
 	Message string `json:"message"`
+
+	// Violations of the input constraints, all of them are listed
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	KeyId string `json:"keyId,omitempty"`
+
+	// Path of the offending value, e.g. features[1].end
+	Field string `json:"field"`
+
+	Message string `json:"message"`
 }
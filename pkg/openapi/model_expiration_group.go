@@ -0,0 +1,40 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+type ExpirationGroup struct {
+	// Client ID, feature name or month (YYYY-MM) depending on the grouping
+	Group string `json:"group"`
+
+	Label string `json:"label"`
+
+	// Number of features ending within the period
+	Features int32 `json:"features"`
+
+	// Number of keys the features belong to
+	Keys int32 `json:"keys"`
+
+	// YYYY-MM-DD date
+	FirstEnd string `json:"firstEnd"`
+
+	// YYYY-MM-DD date
+	LastEnd string `json:"lastEnd"`
+
+	Months []ExpirationMonth `json:"months"`
+}
+
+type ExpirationMonth struct {
+	// YYYY-MM
+	Month string `json:"month"`
+
+	Features int32 `json:"features"`
+
+	Keys int32 `json:"keys"`
+}
@@ -0,0 +1,28 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+type ExpiredFeature struct {
+	KeyId string `json:"keyId"`
+
+	ClientId int32 `json:"clientId"`
+
+	ClientName string `json:"clientName"`
+
+	Feature string `json:"feature"`
+
+	// YYYY-MM-DD date
+	End string `json:"end"`
+
+	// YYYY-MM-DD date
+	GraceEnd string `json:"graceEnd"`
+
+	InGrace bool `json:"inGrace"`
+}
@@ -0,0 +1,47 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+type FeatureRule struct {
+	Name string `json:"name"`
+
+	// Name of the feature or package, or a shell pattern
+	Feature string `json:"feature"`
+
+	// Version the features always have, 0 if not fixed
+	Version float32 `json:"version"`
+
+	// Minimal number of licenses
+	MinCount int32 `json:"minCount"`
+
+	// Months added to the term when the features are prolonged
+	ExtraMonths int32 `json:"extraMonths"`
+
+	// The feature is added to every license set
+	AlwaysIncluded bool `json:"alwaysIncluded"`
+}
+
+type FiredRule struct {
+	KeyId string `json:"keyId,omitempty"`
+
+	Rule string `json:"rule"`
+
+	Feature string `json:"feature"`
+
+	// How the rule changed the feature
+	Effect string `json:"effect"`
+}
+
+type RulesPreview struct {
+	// The license set as it is after the rules are applied
+	Features []LicensedFeature `json:"features"`
+
+	Fired []FiredRule `json:"fired"`
+}
@@ -0,0 +1,31 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+import (
+	"time"
+)
+
+type Job struct {
+	Name string `json:"name"`
+
+	Schedule string `json:"schedule"`
+
+	NextRun *time.Time `json:"nextRun,omitempty"`
+
+	Running bool `json:"running"`
+
+	LastStart *time.Time `json:"lastStart,omitempty"`
+
+	LastEnd *time.Time `json:"lastEnd,omitempty"`
+
+	// Error of the last run, empty if it succeeded
+	LastError string `json:"lastError,omitempty"`
+}
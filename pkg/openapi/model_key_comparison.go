@@ -0,0 +1,48 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// KeyComparison is the feature-by-key matrix of the license sets of the keys
+type KeyComparison struct {
+	Keys []string `json:"keys"`
+
+	// The keys are granted the same features with the same version, count and end
+	Identical bool `json:"identical"`
+
+	Features []FeatureComparison `json:"features"`
+}
+
+// FeatureComparison is the feature as it is granted to each of the keys compared
+type FeatureComparison struct {
+	Name string `json:"name"`
+
+	IsPackage bool `json:"isPackage"`
+
+	// Attributes differing between the keys: licensed, version, count, end
+	Differs []string `json:"differs"`
+
+	// In the order of the keys, null if the feature is not granted to the key
+	Keys []*ComparedFeature `json:"keys"`
+}
+
+type ComparedFeature struct {
+	Version float32 `json:"version"`
+
+	Count int32 `json:"count"`
+
+	// YYYY-MM-DD date
+	Start string `json:"start"`
+
+	// YYYY-MM-DD date
+	End string `json:"end"`
+
+	// Package the feature is granted with, empty if it is licensed by itself
+	Package string `json:"package,omitempty"`
+}
@@ -0,0 +1,35 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+type KeyImportResult struct {
+	// The keys were only checked, not created
+	DryRun bool `json:"dryRun"`
+
+	// Number of keys created
+	Imported int32 `json:"imported"`
+
+	// Number of rows that failed the checks
+	Failed int32 `json:"failed"`
+
+	Rows []KeyImportRow `json:"rows"`
+}
+
+type KeyImportRow struct {
+	// Number of the row starting from 1, the CSV header is not counted
+	Row int32 `json:"row"`
+
+	Id string `json:"id"`
+
+	// ok or error
+	Status string `json:"status"`
+
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,47 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// KeyReplacementRequest names the key replacing the retired one
+type KeyReplacementRequest struct {
+	// ID of the new key, it is registered with the client unless it exists
+	NewKeyId string `json:"newKeyId"`
+
+	// Kind of the new key if it is registered, HASP by default
+	Kind string `json:"kind,omitempty"`
+
+	// Comments of the new key if it is registered
+	Comments string `json:"comments,omitempty"`
+
+	Reason string `json:"reason,omitempty"`
+
+	// Issue the license file for the new key
+	IssueFile bool `json:"issueFile,omitempty"`
+
+	// Address the license file is mailed to, if it is issued
+	MailTo string `json:"mailTo,omitempty"`
+}
+
+// KeyReplacement links the retired key to the key that replaced it
+type KeyReplacement struct {
+	KeyId string `json:"keyId"`
+
+	ReplacedBy string `json:"replacedBy"`
+
+	ClientId int32 `json:"clientId"`
+
+	// RFC3339 time of the replacement
+	Replaced string `json:"replaced"`
+
+	Reason string `json:"reason"`
+
+	// RFC3339 time the license file of the new key was issued, if it was
+	FileIssued string `json:"fileIssued,omitempty"`
+}
@@ -0,0 +1,15 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+type KeyTransfer struct {
+	// ID of the client the key is transferred to
+	ClientId int32 `json:"clientId"`
+}
@@ -0,0 +1,28 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// LicenseSetCopy selects the features copied from the source key and the values overridden
+type LicenseSetCopy struct {
+	// Features to copy, all the features of the source key if empty
+	Features []string `json:"features,omitempty"`
+
+	// Count of the features copied, the source counts are kept if not set
+	Count int32 `json:"count,omitempty"`
+
+	// YYYY-MM-DD date, the source start dates are kept if not set
+	Start string `json:"start,omitempty"`
+
+	// YYYY-MM-DD date, the source end dates are kept if not set
+	End string `json:"end,omitempty"`
+
+	// merge (the default) keeps the other features of the target key, replace drops them
+	Strategy string `json:"strategy,omitempty"`
+}
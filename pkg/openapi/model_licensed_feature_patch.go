@@ -0,0 +1,26 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// LicensedFeaturePatch lists the fields of the licensed feature to change, the fields
+// not set are left intact
+type LicensedFeaturePatch struct {
+	Version *float32 `json:"version,omitempty"`
+
+	Count *int32 `json:"count,omitempty"`
+
+	DupGroup *string `json:"dupGroup,omitempty"`
+
+	// YYYY-MM-DD date
+	Start *string `json:"start,omitempty"`
+
+	// YYYY-MM-DD date
+	End *string `json:"end,omitempty"`
+}
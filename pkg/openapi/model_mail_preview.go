@@ -0,0 +1,23 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+type MailPreview struct {
+	Name string `json:"name"`
+
+	Lang string `json:"lang"`
+
+	Subject string `json:"subject"`
+
+	Text string `json:"text"`
+
+	// Empty if there is no HTML template
+	Html string `json:"html,omitempty"`
+}
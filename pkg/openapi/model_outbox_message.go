@@ -0,0 +1,38 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+import (
+	"time"
+)
+
+type OutboxMessage struct {
+	Id int32 `json:"id"`
+
+	From string `json:"from"`
+
+	To []string `json:"to"`
+
+	Subject string `json:"subject"`
+
+	// One of pending, sent, failed
+	Status string `json:"status"`
+
+	Attempts int32 `json:"attempts"`
+
+	// Error of the last failed delivery attempt
+	LastError string `json:"lastError,omitempty"`
+
+	Created time.Time `json:"created"`
+
+	NextAttempt time.Time `json:"nextAttempt"`
+
+	Sent *time.Time `json:"sent,omitempty"`
+}
@@ -0,0 +1,39 @@
+/*
+ * PANGEA License Manager
+ *
+ * No description provided (generated by Openapi Generator https://github.com/openapitools/openapi-generator)
+ *
+ * API version: 0.1
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+import (
+	"time"
+)
+
+type WebhookDelivery struct {
+	Id int32 `json:"id"`
+
+	Url string `json:"url"`
+
+	Event string `json:"event"`
+
+	// One of pending, sent, failed
+	Status string `json:"status"`
+
+	Attempts int32 `json:"attempts"`
+
+	// HTTP status of the last response, 0 if there was none
+	ResponseCode int32 `json:"responseCode"`
+
+	// Error of the last failed delivery attempt
+	LastError string `json:"lastError,omitempty"`
+
+	Created time.Time `json:"created"`
+
+	NextAttempt time.Time `json:"nextAttempt"`
+
+	Delivered *time.Time `json:"delivered,omitempty"`
+}
@@ -0,0 +1,67 @@
+package openapi
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+const defaultOutboxLimit = 100
+
+// ListOutboxImpl - Returns the most recent messages of the mail outbox
+func ListOutboxImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultOutboxLimit)))
+	if err != nil || limit <= 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: fmt.Sprintf("invalid limit %q", c.Query("limit"))})
+		return
+	}
+	items, err := db.OutboxItems(limit)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	res := []OutboxMessage{}
+	for _, it := range items {
+		res = append(res, outboxMessageFromDao(it))
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// ResendMessageImpl - Puts the message of the outbox back to the delivery queue
+func ResendMessageImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	messageID, err := strconv.Atoi(c.Param("messageId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	if err = db.ResendOutboxItem(messageID, time.Now()); err == sql.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 80, Message: fmt.Sprintf("invalid message ID %d", messageID)})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	it, err := db.OutboxItem(messageID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, outboxMessageFromDao(it))
+}
+
+func outboxMessageFromDao(it dao.OutboxItem) OutboxMessage {
+	res := OutboxMessage{Id: int32(it.Id), From: it.Sender, To: it.Recipients, Subject: it.Subject, Status: it.Status,
+		Attempts: int32(it.Attempts), LastError: it.LastError, Created: it.Created, NextAttempt: it.NextAttempt}
+	if !it.Sent.IsZero() {
+		sent := it.Sent
+		res.Sent = &sent
+	}
+	return res
+}
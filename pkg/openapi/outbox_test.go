@@ -0,0 +1,39 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func TestResendMessageImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	assert.Nil(t, db.Enqueue("lic@pangea.ru", []string{"client@org1.ru"}, "Subj", []byte("Subject: Subj\r\n\r\nBody")))
+	items, _ := db.OutboxItems(1)
+	assert.Nil(t, db.MarkOutboxFailed(items[0].Id, "connection refused"))
+
+	c, w := newTestContext(db)
+	c.Params = []gin.Param{gin.Param{Key: "messageId", Value: "100"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/outbox/100/resend", nil)
+	openapi.ResendMessageImpl(c)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+	c, w = newTestContext(db)
+	c.Params = []gin.Param{gin.Param{Key: "messageId", Value: "1"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/outbox/1/resend", nil)
+	openapi.ResendMessageImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	res := openapi.OutboxMessage{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, dao.OutboxPending, res.Status)
+	assert.Equal(t, int32(0), res.Attempts)
+	assert.Equal(t, "", res.LastError)
+	due, _ := db.DueOutboxItems(time.Now(), 10)
+	assert.Equal(t, 1, len(due))
+}
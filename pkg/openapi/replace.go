@@ -0,0 +1,126 @@
+package openapi
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/webhook"
+)
+
+func keyReplacement(r dao.KeyReplacement) KeyReplacement {
+	return KeyReplacement{KeyId: r.KeyID, ReplacedBy: r.ReplacedBy, ClientId: int32(r.OrgID), Replaced: r.Replaced.Format(time.RFC3339), Reason: r.Reason}
+}
+
+// abortRetiredKey responds with 409 if the key is retired, true is returned then
+func abortRetiredKey(c *gin.Context, db *dao.DbConn, keyID string) bool {
+	r, retired, err := db.KeyRetirement(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return true
+	}
+	if retired {
+		c.AbortWithStatusJSON(http.StatusConflict, Error{Code: 212, Message: fmt.Sprintf("key %s is retired, replaced by %s", keyID, r.ReplacedBy)})
+		return true
+	}
+	return false
+}
+
+// ReplaceKeyImpl - Moves the license set of the key to the new key and retires the key.
+// The new key is registered with the client unless it exists, its ID must be valid for
+// the kind given as for the keys imported. Optionally the license file of the new key
+// is issued and mailed.
+func ReplaceKeyImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyID := c.Param("keyId")
+	req := KeyReplacementRequest{}
+	if err := c.BindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 210, Message: err.Error()})
+		return
+	}
+	if req.NewKeyId == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 210, Message: "ID of the new key must be specified"})
+		return
+	}
+	org, err := db.KeyOfWhichOrg(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 211, Message: fmt.Sprintf("key %s is not registered", keyID)})
+		return
+	}
+	if abortRetiredKey(c, db, keyID) {
+		return
+	}
+	comments := req.Comments
+	if _, err = db.KeyOfWhichOrg(req.NewKeyId); err != nil {
+		newKey, err := newHWKey(req.Kind, req.NewKeyId, org.Id, req.Comments)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 210, Message: err.Error()})
+			return
+		}
+		comments = newKey.Comments
+	}
+	revision, ok := ifMatchRevision(c, db, keyID, false)
+	if !ok {
+		return
+	}
+	when := time.Now()
+	created, err := db.ReplaceKey(keyID, req.NewKeyId, comments, req.Reason, revision, when)
+	if err == dao.ErrStaleRevision {
+		abortStaleLicenseSet(c, db, keyID)
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 210, Message: err.Error()})
+		return
+	}
+	if created {
+		emit(c, webhook.EventKeyCreated, webhook.KeyCreated{KeyID: req.NewKeyId, ClientID: org.Id, Comments: req.Comments})
+	}
+	emit(c, webhook.EventKeyReplaced, webhook.KeyReplaced{KeyID: keyID, ReplacedBy: req.NewKeyId, ClientID: org.Id, Reason: req.Reason})
+	emitLicenseSetChanged(c, db, req.NewKeyId, webhook.OperationReplace)
+	setLicenseSetETag(c, db, req.NewKeyId)
+
+	res := KeyReplacement{KeyId: keyID, ReplacedBy: req.NewKeyId, ClientId: int32(org.Id), Replaced: when.Format(time.RFC3339), Reason: req.Reason}
+	if req.IssueFile {
+		resXML, issued, err := issueLicenseFile(c, db, org.Id, req.NewKeyId)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: "the key is replaced, but the license file is not issued: " + err.Error()})
+			return
+		}
+		if req.MailTo != "" {
+			conf := c.MustGet("conf").(*config.Config)
+			log.Println("Queueing file for ", req.MailTo)
+			if err := mailLicenseFile(db, conf, org.Id, org.Name, req.NewKeyId, resXML, req.MailTo); err != nil {
+				log.Println("Error when sending file ", err)
+			}
+		}
+		emit(c, webhook.EventLicenseFileIssued, webhook.LicenseFileIssued{ClientID: org.Id, KeyID: req.NewKeyId, Issued: issued, MailedTo: req.MailTo})
+		res.FileIssued = issued.Format(time.RFC3339)
+	}
+	c.JSON(http.StatusCreated, res)
+}
+
+// KeyReplacementsImpl - Returns the chain of replacements the key takes part in, from
+// the first key replaced to the last one
+func KeyReplacementsImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyID := c.Param("keyId")
+	if _, err := db.KeyOfWhichOrg(keyID); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 211, Message: fmt.Sprintf("key %s is not registered", keyID)})
+		return
+	}
+	chain, err := db.ReplacementChain(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	res := []KeyReplacement{}
+	for _, r := range chain {
+		res = append(res, keyReplacement(r))
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,128 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func TestReplaceKeyImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	replace := func(keyID string, body string) (int, []byte) {
+		c, w := newTestContext(db)
+		c.Request, _ = http.NewRequest("POST", "/v1/keys/"+keyID+"/replace", strings.NewReader(body))
+		withIfMatch(db, c.Request, keyID)
+		c.Params = []gin.Param{{Key: "keyId", Value: keyID}}
+		openapi.ReplaceKeyImpl(c)
+		return w.Code, w.Body.Bytes()
+	}
+	replacements := func(keyID string) []openapi.KeyReplacement {
+		c, w := newTestContext(db)
+		c.Request, _ = http.NewRequest("GET", "/v1/keys/"+keyID+"/replacements", nil)
+		c.Params = []gin.Param{{Key: "keyId", Value: keyID}}
+		openapi.KeyReplacementsImpl(c)
+		res := []openapi.KeyReplacement{}
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res
+	}
+
+	before, _ := db.LicensesSetByKeyId("123abc")
+	code, body := replace("123abc", `{"newKeyId": "123dbc", "comments": "spare", "reason": "broken"}`)
+	assert.Equal(t, http.StatusCreated, code)
+	res := openapi.KeyReplacement{}
+	assert.Nil(t, json.Unmarshal(body, &res))
+	assert.Equal(t, "123abc", res.KeyId)
+	assert.Equal(t, "123dbc", res.ReplacedBy)
+	assert.Equal(t, int32(1), res.ClientId)
+	assert.Equal(t, "broken", res.Reason)
+	assert.Empty(t, res.FileIssued)
+
+	moved, _ := db.LicensesSetByKeyId("123dbc")
+	assert.Equal(t, len(before), len(moved))
+	left, _ := db.LicensesSetByKeyId("123abc")
+	assert.Empty(t, left)
+	org, err := db.KeyOfWhichOrg("123dbc")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, org.Id)
+	keys, _ := db.KeysOfOrg(1)
+	for _, k := range keys {
+		if k.Id == "123dbc" {
+			assert.Equal(t, "HASP spare", k.Comments)
+		}
+	}
+
+	// The retired key is neither replaced again nor used as a replacement
+	code, _ = replace("123abc", `{"newKeyId": "123ebc"}`)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = replace("123bbc", `{"newKeyId": "123abc"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	// The new key must belong to the same client and have no features
+	code, _ = replace("123dbc", `{"newKeyId": "123cbc"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = replace("123dbc", `{"newKeyId": "123bbc"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = replace("123dbc", `{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	// The new key is registered like the keys imported
+	code, _ = replace("123dbc", `{"newKeyId": "xyz"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = replace("123dbc", `{"newKeyId": "123ebc", "kind": "GUARDANT"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = replace("nosuchkey", `{"newKeyId": "123ebc"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = replace("123dbc", `{"newKeyId": "123ebc", "reason": "lost"}`)
+	assert.Equal(t, http.StatusCreated, code)
+	for _, keyID := range []string{"123abc", "123dbc", "123ebc"} {
+		chain := replacements(keyID)
+		if assert.Equal(t, 2, len(chain)) {
+			assert.Equal(t, "123abc", chain[0].KeyId)
+			assert.Equal(t, "123dbc", chain[1].KeyId)
+			assert.Equal(t, "123ebc", chain[1].ReplacedBy)
+		}
+	}
+	assert.Empty(t, replacements("123bbc"))
+}
+
+func TestReplaceKeyStaleRevision(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	c, w := newTestContext(db)
+	c.Request, _ = http.NewRequest("POST", "/v1/keys/123abc/replace", strings.NewReader(`{"newKeyId": "123dbc"}`))
+	c.Request.Header.Set("If-Match", `"12345"`)
+	c.Params = []gin.Param{{Key: "keyId", Value: "123abc"}}
+	openapi.ReplaceKeyImpl(c)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	_, err := db.KeyOfWhichOrg("123dbc")
+	assert.NotNil(t, err)
+}
+
+// The retired key is not handled as a live one
+func TestRetiredKey(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	revision, _ := db.LicenseSetRevision("123abc")
+	_, err := db.ReplaceKey("123abc", "123dbc", "spare", "broken", revision, time.Now())
+	assert.Nil(t, err)
+
+	c, w := newTestContext(db)
+	c.Params = []gin.Param{{Key: "keyId", Value: "123abc"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/keys/123abc/transfer", strings.NewReader(`{"clientId": 2}`))
+	openapi.TransferKeyImpl(c)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	org, _ := db.KeyOfWhichOrg("123abc")
+	assert.Equal(t, 1, org.Id)
+
+	_, records := exportCSV(t, db, openapi.ExportKeysImpl, "/v1/export/keys.csv?clientId=1")
+	replacedBy := map[string]string{}
+	for _, r := range records[1:] {
+		replacedBy[r[0]] = r[6]
+	}
+	assert.Equal(t, map[string]string{"123abc": "123dbc", "123bbc": "", "123dbc": ""}, replacedBy)
+}
@@ -0,0 +1,86 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/chkexprd"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// ExpiredFeaturesImpl - Returns features that expired within the given number of days
+func ExpiredFeaturesImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	conf := c.MustGet("conf").(*config.Config)
+	days := conf.ExpiredReportDays
+	if daysStr := c.Query("days"); daysStr != "" {
+		var err error
+		if days, err = strconv.Atoi(daysStr); err != nil || days < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 60, Message: "invalid number of days: " + daysStr})
+			return
+		}
+	}
+	expired, err := chkexprd.FindExpiredFeatures(db, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	res := []ExpiredFeature{}
+	for _, f := range expired {
+		res = append(res, ExpiredFeature{KeyId: f.KeyID, ClientId: int32(f.ClientID), ClientName: f.ClientName, Feature: f.Feature,
+			End: f.End.Format("2006-01-02"), GraceEnd: f.GraceEnd.Format("2006-01-02"), InGrace: f.InGrace})
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// maxForecastYears limits the period of the expirations forecast
+const maxForecastYears = 5
+
+// ExpirationsForecastImpl - Returns features ending within the period aggregated by client, feature or month
+func ExpirationsForecastImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	from := time.Now()
+	if fromStr := c.Query("from"); fromStr != "" {
+		var err error
+		if from, err = time.Parse("2006-01-02", fromStr); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 61, Message: "invalid from date: " + fromStr})
+			return
+		}
+	}
+	to := from.AddDate(1, 0, 0)
+	if toStr := c.Query("to"); toStr != "" {
+		var err error
+		if to, err = time.Parse("2006-01-02", toStr); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 61, Message: "invalid to date: " + toStr})
+			return
+		}
+	}
+	if to.Before(from) || to.After(from.AddDate(maxForecastYears, 0, 0)) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 61, Message: fmt.Sprintf("the period must end after it starts and be at most %d years long", maxForecastYears)})
+		return
+	}
+	groupBy := c.DefaultQuery("groupBy", chkexprd.GroupByMonth)
+	if !chkexprd.IsValidGroupBy(groupBy) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 62, Message: "groupBy must be one of client, feature, month"})
+		return
+	}
+	groups, err := chkexprd.Forecast(db, from, to, groupBy)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	res := []ExpirationGroup{}
+	for _, g := range groups {
+		tmp := ExpirationGroup{Group: g.Group, Label: g.Label, Features: int32(g.Features), Keys: int32(g.Keys),
+			FirstEnd: g.FirstEnd.Format("2006-01-02"), LastEnd: g.LastEnd.Format("2006-01-02"), Months: []ExpirationMonth{}}
+		for _, m := range g.Months {
+			tmp.Months = append(tmp.Months, ExpirationMonth{Month: m.Month, Features: int32(m.Features), Keys: int32(m.Keys)})
+		}
+		res = append(res, tmp)
+	}
+	c.JSON(http.StatusOK, res)
+}
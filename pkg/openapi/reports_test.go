@@ -0,0 +1,33 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func TestExpirationsForecastImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	for _, query := range []string{"from=2008-13-01", "from=2008-07-01&to=2008-06-01", "from=2008-07-01&to=2020-07-01", "groupBy=year"} {
+		c, w := newTestContext(db)
+		c.Request, _ = http.NewRequest("GET", "/v1/reports/expirations?"+query, nil)
+		openapi.ExpirationsForecastImpl(c)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	c, w := newTestContext(db)
+	c.Request, _ = http.NewRequest("GET", "/v1/reports/expirations?from=2008-07-01&to=2008-12-31&groupBy=client", nil)
+	openapi.ExpirationsForecastImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	res := []openapi.ExpirationGroup{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	if assert.Equal(t, 1, len(res)) {
+		assert.Equal(t, "Org 1", res[0].Label)
+		assert.Equal(t, "2008-07-08", res[0].FirstEnd)
+		assert.Equal(t, []openapi.ExpirationMonth{{Month: "2008-07", Features: 5, Keys: 2}}, res[0].Months)
+	}
+}
@@ -0,0 +1,68 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// licenseSetETag makes the ETag of the license set revision
+func licenseSetETag(revision int) string {
+	return strconv.Quote(strconv.Itoa(revision))
+}
+
+// ifMatchRevision returns the revision of the license set of the key the request is
+// based on, taken from the If-Match header. The header is required unless it is a dry
+// run, the request is aborted with 428 if it is missing and with 412 if the license set
+// has been changed since. False is returned if the request is complete.
+func ifMatchRevision(c *gin.Context, db *dao.DbConn, keyID string, dryRun bool) (int, bool) {
+	current, err := db.LicenseSetRevision(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return 0, false
+	}
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "" {
+		if dryRun {
+			return current, true
+		}
+		c.AbortWithStatusJSON(http.StatusPreconditionRequired, Error{Code: 180,
+			Message: fmt.Sprintf("If-Match header with the ETag of the license set of key %s is required", keyID)})
+		return 0, false
+	}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(tag) == licenseSetETag(current) {
+			return current, true
+		}
+	}
+	abortStaleLicenseSet(c, db, keyID)
+	return 0, false
+}
+
+// abortStaleLicenseSet aborts the request with 412 and the current license set of the
+// key along with its ETag
+func abortStaleLicenseSet(c *gin.Context, db *dao.DbConn, keyID string) {
+	revision, err := db.LicenseSetRevision(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	licSet, err := db.LicensesSetByKeyId(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	c.Header("ETag", licenseSetETag(revision))
+	c.AbortWithStatusJSON(http.StatusPreconditionFailed, licensedFeatures(licSet))
+}
+
+// setLicenseSetETag sets the ETag header to the current revision of the license set
+func setLicenseSetETag(c *gin.Context, db *dao.DbConn, keyID string) {
+	if revision, err := db.LicenseSetRevision(keyID); err == nil {
+		c.Header("ETag", licenseSetETag(revision))
+	}
+}
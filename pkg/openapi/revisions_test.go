@@ -0,0 +1,66 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+)
+
+func TestLicenseSetRevisions(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	get := func() (string, []openapi.LicensedFeature) {
+		c, w := newTestContext(db)
+		c.Params = []gin.Param{{Key: "keyId", Value: "123abc"}}
+		openapi.LicensedFeaturesForKeyImpl(c)
+		res := []openapi.LicensedFeature{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return w.Header().Get("ETag"), res
+	}
+	setCount := func(count string, etag string) (int, http.Header, []byte) {
+		c, w := newTestContext(db)
+		c.Request, _ = http.NewRequest("POST", "/v1/changeFeaturesCountForKey/123abc?setCount="+count, nil)
+		if etag != "" {
+			c.Request.Header.Set("If-Match", etag)
+		}
+		c.Params = []gin.Param{{Key: "keyId", Value: "123abc"}}
+		openapi.ChangeLicensesCountImpl(c)
+		return w.Code, w.Header(), w.Body.Bytes()
+	}
+
+	etag, _ := get()
+	assert.Equal(t, `"0"`, etag)
+
+	code, _, body := setCount("5", "")
+	assert.Equal(t, http.StatusPreconditionRequired, code)
+	res := openapi.Error{}
+	assert.Nil(t, json.Unmarshal(body, &res))
+	assert.Equal(t, int32(180), res.Code)
+
+	code, header, _ := setCount("5", etag)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, `"1"`, header.Get("ETag"))
+
+	// The other operator still holds the old ETag
+	code, header, body = setCount("7", etag)
+	assert.Equal(t, http.StatusPreconditionFailed, code)
+	assert.Equal(t, `"1"`, header.Get("ETag"))
+	current := []openapi.LicensedFeature{}
+	assert.Nil(t, json.Unmarshal(body, &current))
+	etag, features := get()
+	assert.Equal(t, `"1"`, etag)
+	assert.Equal(t, features, current)
+	for _, f := range features {
+		assert.Equal(t, int32(5), f.CountedFeature.Count)
+	}
+
+	// A dry run needs no If-Match
+	code, _, _ = setCount("7&dryRun=true", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _, _ = setCount("7", `"0", "1"`)
+	assert.Equal(t, http.StatusAccepted, code)
+}
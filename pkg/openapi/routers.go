@@ -16,7 +16,11 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/vaefremov/pnglic/config"
 	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/leader"
+	"github.com/vaefremov/pnglic/pkg/rules"
+	"github.com/vaefremov/pnglic/pkg/scheduler"
 	"github.com/vaefremov/pnglic/pkg/view"
+	"github.com/vaefremov/pnglic/pkg/webhook"
 )
 
 // Route is the information for every URI.
@@ -42,14 +46,47 @@ func addDatabaseAndConf(db *dao.DbConn, conf *config.Config) gin.HandlerFunc {
 	}
 }
 
+func addWebhooks(hooks *webhook.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("hooks", hooks)
+		c.Next()
+	}
+}
+
+func addScheduler(sched *scheduler.Scheduler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("scheduler", sched)
+		c.Next()
+	}
+}
+
+func addElector(elector *leader.Elector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("leader", elector)
+		c.Next()
+	}
+}
+
+func addRules(featureRules *rules.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("rules", featureRules)
+		c.Next()
+	}
+}
+
 // NewRouter returns a new router.
-func NewRouter(conf *config.Config) *gin.Engine {
-	db := dao.MustNewPool(conf.DSN)
-	router := gin.Default()
+func NewRouter(conf *config.Config, db *dao.DbConn, sched *scheduler.Scheduler, elector *leader.Elector, featureRules *rules.Engine) *gin.Engine {
+	// The calendar token is taken out of the query before the access log sees it
+	router := gin.New()
+	router.Use(hideCalendarToken, gin.Logger(), gin.Recovery())
 	router.Delims("[[", "]]") // Template delimiters changed to be able to use Vue.js in template-generated pages
 	router.Static("/s", filepath.Clean(filepath.Join(conf.StaticContent, "../static")))
-	router.LoadHTMLGlob(filepath.Join(conf.StaticContent, "*"))
+	router.LoadHTMLGlob(filepath.Join(conf.StaticContent, "*.html"))
 	router.Use(addDatabaseAndConf(db, conf))
+	router.Use(addWebhooks(webhook.New(db, conf)))
+	router.Use(addScheduler(sched))
+	router.Use(addElector(elector))
+	router.Use(addRules(featureRules))
 	for _, route := range routes {
 		switch route.Method {
 		case http.MethodGet:
@@ -58,6 +95,8 @@ func NewRouter(conf *config.Config) *gin.Engine {
 			router.POST(route.Pattern, route.HandlerFunc)
 		case http.MethodPut:
 			router.PUT(route.Pattern, route.HandlerFunc)
+		case http.MethodPatch:
+			router.PATCH(route.Pattern, route.HandlerFunc)
 		case http.MethodDelete:
 			router.DELETE(route.Pattern, route.HandlerFunc)
 		}
@@ -66,6 +105,9 @@ func NewRouter(conf *config.Config) *gin.Engine {
 	// Note: Basic authentication will be replaced in future with something more secure
 	protected := router.Group("/v1/view/", gin.BasicAuth(acc))
 	protected.GET("/*c", view.Index)
+	admin := router.Group("/v1/admin/", gin.BasicAuth(acc))
+	admin.GET("/jobs", ListJobs)
+	admin.POST("/jobs/:name/run", RunJob)
 	return router
 }
 
@@ -81,6 +123,13 @@ var routes = Routes{
 		DefaultEntryPoint,
 	},
 
+	{
+		"BulkProlong",
+		http.MethodPost,
+		"/v1/bulk/prolong",
+		BulkProlong,
+	},
+
 	{
 		"ChangeLicensesCount",
 		http.MethodPost,
@@ -88,6 +137,34 @@ var routes = Routes{
 		ChangeLicensesCount,
 	},
 
+	{
+		"GetClientSettings",
+		http.MethodGet,
+		"/v1/clients/:clientId/settings",
+		GetClientSettings,
+	},
+
+	{
+		"CompareKeys",
+		http.MethodGet,
+		"/v1/compareKeys",
+		CompareKeys,
+	},
+
+	{
+		"CopyLicenseSet",
+		http.MethodPost,
+		"/v1/keys/:keyId/copyFrom/:sourceKeyId",
+		CopyLicenseSet,
+	},
+
+	{
+		"CreateContact",
+		http.MethodPut,
+		"/v1/clients/:clientId/contacts",
+		CreateContact,
+	},
+
 	{
 		"CreateFeature",
 		http.MethodPut,
@@ -102,6 +179,13 @@ var routes = Routes{
 		CreateKey,
 	},
 
+	{
+		"DeleteContact",
+		http.MethodDelete,
+		"/v1/contacts/:contactId",
+		DeleteContact,
+	},
+
 	{
 		"DeleteFeature",
 		http.MethodDelete,
@@ -109,6 +193,62 @@ var routes = Routes{
 		DeleteFeature,
 	},
 
+	{
+		"DeleteKeyFeature",
+		http.MethodDelete,
+		"/v1/keys/:keyId/features/:feature",
+		DeleteKeyFeature,
+	},
+
+	{
+		"ExpirationsForecast",
+		http.MethodGet,
+		"/v1/reports/expirations",
+		ExpirationsForecast,
+	},
+
+	{
+		"ExpiredFeatures",
+		http.MethodGet,
+		"/v1/reports/expired",
+		ExpiredFeatures,
+	},
+
+	{
+		"ExpiryCalendar",
+		http.MethodGet,
+		"/v1/calendar/expirations.ics",
+		ExpiryCalendar,
+	},
+
+	{
+		"ExportClients",
+		http.MethodGet,
+		"/v1/export/clients.csv",
+		ExportClients,
+	},
+
+	{
+		"ExportHistory",
+		http.MethodGet,
+		"/v1/export/history.csv",
+		ExportHistory,
+	},
+
+	{
+		"ExportKeys",
+		http.MethodGet,
+		"/v1/export/keys.csv",
+		ExportKeys,
+	},
+
+	{
+		"ExportLicenseSets",
+		http.MethodGet,
+		"/v1/export/licensesets.csv",
+		ExportLicenseSets,
+	},
+
 	{
 		"HistoryLicenseFile",
 		http.MethodGet,
@@ -116,6 +256,27 @@ var routes = Routes{
 		HistoryLicenseFile,
 	},
 
+	{
+		"ImportKeys",
+		http.MethodPost,
+		"/v1/importKeys",
+		ImportKeys,
+	},
+
+	{
+		"KeyFeatureRules",
+		http.MethodGet,
+		"/v1/keys/:keyId/rules",
+		KeyFeatureRules,
+	},
+
+	{
+		"KeyReplacements",
+		http.MethodGet,
+		"/v1/keys/:keyId/replacements",
+		KeyReplacements,
+	},
+
 	{
 		"LicensedFeaturesForKey",
 		http.MethodGet,
@@ -130,6 +291,20 @@ var routes = Routes{
 		ListClients,
 	},
 
+	{
+		"ListContacts",
+		http.MethodGet,
+		"/v1/clients/:clientId/contacts",
+		ListContacts,
+	},
+
+	{
+		"ListFeatureRules",
+		http.MethodGet,
+		"/v1/rules",
+		ListFeatureRules,
+	},
+
 	{
 		"ListFeatures",
 		http.MethodGet,
@@ -151,6 +326,20 @@ var routes = Routes{
 		ListKeys,
 	},
 
+	{
+		"ListOutbox",
+		http.MethodGet,
+		"/v1/outbox",
+		ListOutbox,
+	},
+
+	{
+		"ListWebhookDeliveries",
+		http.MethodGet,
+		"/v1/webhooks/deliveries",
+		ListWebhookDeliveries,
+	},
+
 	{
 		"MakeLicenseFile",
 		http.MethodGet,
@@ -165,6 +354,13 @@ var routes = Routes{
 		PackageContent,
 	},
 
+	{
+		"PatchKeyFeature",
+		http.MethodPatch,
+		"/v1/keys/:keyId/features/:feature",
+		PatchKeyFeature,
+	},
+
 	{
 		"Ping",
 		http.MethodGet,
@@ -172,6 +368,13 @@ var routes = Routes{
 		Ping,
 	},
 
+	{
+		"PreviewMailTemplate",
+		http.MethodGet,
+		"/v1/mailTemplates/:name/preview",
+		PreviewMailTemplate,
+	},
+
 	{
 		"ProlongLicensedFeaturesForKey",
 		http.MethodPost,
@@ -179,6 +382,55 @@ var routes = Routes{
 		ProlongLicensedFeaturesForKey,
 	},
 
+	{
+		"PutKeyFeature",
+		http.MethodPut,
+		"/v1/keys/:keyId/features/:feature",
+		PutKeyFeature,
+	},
+
+	{
+		"RedeliverWebhook",
+		http.MethodPost,
+		"/v1/webhooks/deliveries/:deliveryId/redeliver",
+		RedeliverWebhook,
+	},
+
+	{
+		"ReplaceKey",
+		http.MethodPost,
+		"/v1/keys/:keyId/replace",
+		ReplaceKey,
+	},
+
+	{
+		"ResendMessage",
+		http.MethodPost,
+		"/v1/outbox/:messageId/resend",
+		ResendMessage,
+	},
+
+	{
+		"TransferKey",
+		http.MethodPost,
+		"/v1/keys/:keyId/transfer",
+		TransferKey,
+	},
+
+	{
+		"UpdateClientSettings",
+		http.MethodPost,
+		"/v1/clients/:clientId/settings",
+		UpdateClientSettings,
+	},
+
+	{
+		"UpdateContact",
+		http.MethodPost,
+		"/v1/contacts/:contactId",
+		UpdateContact,
+	},
+
 	{
 		"UpdateLicensedFeaturesForKey",
 		http.MethodPost,
@@ -0,0 +1,78 @@
+package openapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/rules"
+)
+
+// featureRules returns the policy rules of features, the built-in ones unless configured
+func featureRules(c *gin.Context) *rules.Engine {
+	if e, ok := c.Get("rules"); ok {
+		return e.(*rules.Engine)
+	}
+	return rules.Default()
+}
+
+// firedRules converts the rules fired for the key to the API model
+func firedRules(keyID string, fired []rules.Fired) []FiredRule {
+	res := []FiredRule{}
+	for _, f := range fired {
+		res = append(res, FiredRule{KeyId: keyID, Rule: f.Rule, Feature: f.Feature, Effect: f.Effect})
+	}
+	return res
+}
+
+// licensedFeatures converts the license set to the API model
+func licensedFeatures(licSet []dao.LicenseSetItem) []LicensedFeature {
+	res := []LicensedFeature{}
+	for _, f := range licSet {
+		res = append(res, LicensedFeature{CountedFeature: CountedFeature{
+			Name:    f.Feature,
+			Version: f.Version, Count: int32(f.Count), DupGroup: f.DupGroup},
+			Start: f.Start.Format("2006-01-02"), End: f.End.Format("2006-01-02")})
+	}
+	return res
+}
+
+// parseDryRun returns the value of the dryRun query parameter, false if it is not set.
+// The request is aborted if the value is invalid.
+func parseDryRun(c *gin.Context) (dryRun bool, ok bool) {
+	dryRunStr := c.Query("dryRun")
+	if dryRunStr == "" {
+		return false, true
+	}
+	var err error
+	if dryRun, err = strconv.ParseBool(dryRunStr); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: "invalid dryRun: " + dryRunStr})
+		return false, false
+	}
+	return dryRun, true
+}
+
+// ListFeatureRulesImpl - Returns the policy rules of features
+func ListFeatureRulesImpl(c *gin.Context) {
+	res := []FeatureRule{}
+	for _, r := range featureRules(c).Rules {
+		res = append(res, FeatureRule{Name: r.Name, Feature: r.Feature, Version: r.Version, MinCount: int32(r.MinCount),
+			ExtraMonths: int32(r.ExtraMonths), AlwaysIncluded: r.AlwaysIncluded})
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// KeyFeatureRulesImpl - Explains which rules fire when the license file of the key is made,
+// returns the license set as it goes to the file
+func KeyFeatureRulesImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyID := c.Param("keyId")
+	licSet, err := db.LicensesSetByKeyId(keyID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	licSet, fired := featureRules(c).Apply(licSet)
+	c.JSON(http.StatusOK, RulesPreview{Features: licensedFeatures(licSet), Fired: firedRules(keyID, fired)})
+}
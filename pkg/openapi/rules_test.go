@@ -0,0 +1,106 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+	"github.com/vaefremov/pnglic/pkg/rules"
+)
+
+func testRules(t *testing.T) *rules.Engine {
+	e, err := rules.New([]rules.Rule{
+		{Name: "f3", Feature: "F3", Version: 1.0, ExtraMonths: 12},
+		{Name: "packages", Feature: "P*", MinCount: 15},
+		{Name: "f4", Feature: "F4", MinCount: 5, AlwaysIncluded: true},
+	})
+	assert.Nil(t, err)
+	return e
+}
+
+func TestListFeatureRulesImpl(t *testing.T) {
+	c, w := newTestContext(dao.MustInMemoryTestPool())
+	openapi.ListFeatureRulesImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	res := []openapi.FeatureRule{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, []openapi.FeatureRule{{Name: "lm-console", Feature: "LM_CONSOLE", Version: 1.0, ExtraMonths: 12}}, res)
+}
+
+func TestProlongWithRules(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	e := testRules(t)
+	prolong := func(query string) (*gin.Context, int, []byte) {
+		c, w := newTestContext(db)
+		c.Set("rules", e)
+		c.Request, _ = http.NewRequest("POST", "/v1/prolongLicensedFeaturesForKey/123abc?"+query, nil)
+		withIfMatch(db, c.Request, "123abc")
+		c.Params = []gin.Param{{Key: "keyId", Value: "123abc"}}
+		openapi.ProlongLicensedFeaturesForKeyImpl(c)
+		return c, w.Code, w.Body.Bytes()
+	}
+
+	_, code, body := prolong("till=2030-01-01&dryRun=true")
+	assert.Equal(t, http.StatusOK, code)
+	res := openapi.RulesPreview{}
+	assert.Nil(t, json.Unmarshal(body, &res))
+	assert.Equal(t, []openapi.LicensedFeature{
+		{CountedFeature: openapi.CountedFeature{Name: "F3", Version: 1, Count: 10, DupGroup: "DISP"}, Start: "2007-07-08", End: "2031-01-01"},
+		{CountedFeature: openapi.CountedFeature{Name: "P1", Version: 19, Count: 15, DupGroup: "DISP"}, Start: "2007-07-08", End: "2030-01-01"},
+		{CountedFeature: openapi.CountedFeature{Name: "F4", Version: 19, Count: 5}, Start: "2007-07-08", End: "2031-01-01"},
+	}, res.Features)
+	assert.Equal(t, []openapi.FiredRule{
+		{KeyId: "123abc", Rule: "f3", Feature: "F3", Effect: "term extended by 12 month(s) till 2031-01-01"},
+		{KeyId: "123abc", Rule: "f4", Feature: "F4", Effect: "added to the license set"},
+		{KeyId: "123abc", Rule: "f3", Feature: "F3", Effect: "version changed from 19.00 to 1.00"},
+		{KeyId: "123abc", Rule: "packages", Feature: "P1", Effect: "count raised from 10 to 15"},
+	}, res.Fired)
+	licSet, _ := db.LicensesSetByKeyId("123abc")
+	assert.Equal(t, 2, len(licSet), "the dry run must not change the license set")
+
+	_, code, _ = prolong("till=2030-01-01")
+	assert.Equal(t, http.StatusAccepted, code)
+	licSet, _ = db.LicensesSetByKeyId("123abc")
+	assert.Equal(t, 3, len(licSet))
+
+	_, code, _ = prolong("till=2030-01-01&dryRun=maybe")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestChangeLicensesCountWithRules(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	c, w := newTestContext(db)
+	c.Set("rules", testRules(t))
+	c.Request, _ = http.NewRequest("POST", "/v1/changeFeaturesCountForKey/123bbc?setCount=5&dryRun=1", nil)
+	c.Params = []gin.Param{{Key: "keyId", Value: "123bbc"}}
+	openapi.ChangeLicensesCountImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	res := openapi.RulesPreview{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	counts := map[string]int32{}
+	for _, f := range res.Features {
+		counts[f.CountedFeature.Name] = f.CountedFeature.Count
+	}
+	assert.Equal(t, map[string]int32{"P1": 15, "F3": 5, "F1": 5, "F4": 5}, counts)
+}
+
+func TestKeyFeatureRulesImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	for keyID, fired := range map[string]int{"123bbc": 2, "123cbc": 0} {
+		c, w := newTestContext(db)
+		c.Set("rules", testRules(t))
+		c.Params = []gin.Param{{Key: "keyId", Value: keyID}}
+		openapi.KeyFeatureRulesImpl(c)
+		assert.Equal(t, http.StatusOK, w.Code)
+		res := openapi.RulesPreview{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, fired, len(res.Fired), keyID)
+	}
+	// The rules only explain the file, the license set is not changed
+	licSet, _ := db.LicensesSetByKeyId("123bbc")
+	assert.Equal(t, 3, len(licSet))
+}
@@ -0,0 +1,94 @@
+package openapi
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/webhook"
+)
+
+const defaultWebhookDeliveriesLimit = 100
+
+// emit posts the event to webhooks. Failures are only logged, the change the
+// event reports has already been made.
+func emit(c *gin.Context, event string, data interface{}) {
+	hooks, ok := c.Get("hooks")
+	if !ok {
+		return
+	}
+	if err := hooks.(*webhook.Dispatcher).Emit(event, data); err != nil {
+		log.Println("Error when emitting webhook event ", event, err)
+	}
+}
+
+// emitLicenseSetChanged posts the license set of the key as it is after the change
+func emitLicenseSetChanged(c *gin.Context, db *dao.DbConn, keyID string, operation string) {
+	if _, ok := c.Get("hooks"); !ok {
+		return
+	}
+	licSet, err := db.LicensesSetByKeyId(keyID)
+	if err != nil {
+		log.Println("Error when emitting webhook event ", webhook.EventLicenseSetChanged, err)
+		return
+	}
+	emit(c, webhook.EventLicenseSetChanged, webhook.LicenseSetChanged{KeyID: keyID, Operation: operation, Features: webhook.FeaturesOf(licSet)})
+}
+
+// ListWebhookDeliveriesImpl - Returns the most recent webhook deliveries
+func ListWebhookDeliveriesImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultWebhookDeliveriesLimit)))
+	if err != nil || limit <= 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: fmt.Sprintf("invalid limit %q", c.Query("limit"))})
+		return
+	}
+	items, err := db.WebhookDeliveries(limit)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	res := []WebhookDelivery{}
+	for _, it := range items {
+		res = append(res, webhookDeliveryFromDao(it))
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// RedeliverWebhookImpl - Puts the webhook delivery back to the queue
+func RedeliverWebhookImpl(c *gin.Context) {
+	db := c.MustGet("db").(*dao.DbConn)
+	deliveryID, err := strconv.Atoi(c.Param("deliveryId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, Error{Code: 3, Message: err.Error()})
+		return
+	}
+	if err = db.RedeliverWebhook(deliveryID, time.Now()); err == sql.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, Error{Code: 100, Message: fmt.Sprintf("invalid delivery ID %d", deliveryID)})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	it, err := db.WebhookDelivery(deliveryID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Error{Code: 2, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webhookDeliveryFromDao(it))
+}
+
+func webhookDeliveryFromDao(it dao.WebhookDelivery) WebhookDelivery {
+	res := WebhookDelivery{Id: int32(it.Id), Url: it.URL, Event: it.Event, Status: it.Status, Attempts: int32(it.Attempts),
+		ResponseCode: int32(it.ResponseCode), LastError: it.LastError, Created: it.Created, NextAttempt: it.NextAttempt}
+	if !it.Delivered.IsZero() {
+		delivered := it.Delivered
+		res.Delivered = &delivered
+	}
+	return res
+}
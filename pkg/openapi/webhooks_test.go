@@ -0,0 +1,105 @@
+package openapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/openapi"
+	"github.com/vaefremov/pnglic/pkg/webhook"
+)
+
+func TestTransferKeyImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	hooks := webhook.New(db, &config.Config{Webhooks: []config.Webhook{{URL: "http://ops.example.com/hook"}}})
+
+	c, w := newTestContext(db)
+	c.Set("hooks", hooks)
+	c.Params = []gin.Param{gin.Param{Key: "keyId", Value: "123abc"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/keys/123abc/transfer", bytes.NewBufferString(`{"clientId": 100}`))
+	openapi.TransferKeyImpl(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	c, w = newTestContext(db)
+	c.Set("hooks", hooks)
+	c.Params = []gin.Param{gin.Param{Key: "keyId", Value: "123abc"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/keys/123abc/transfer", bytes.NewBufferString(`{"clientId": 2}`))
+	openapi.TransferKeyImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	org, _ := db.KeyOfWhichOrg("123abc")
+	assert.Equal(t, 2, org.Id)
+
+	deliveries, err := db.WebhookDeliveries(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(deliveries))
+	assert.Equal(t, webhook.EventKeyTransferred, deliveries[0].Event)
+	ev := struct {
+		Data webhook.KeyTransferred `json:"data"`
+	}{}
+	assert.Nil(t, json.Unmarshal(deliveries[0].Payload, &ev))
+	assert.Equal(t, webhook.KeyTransferred{KeyID: "123abc", FromClientID: 1, ToClientID: 2}, ev.Data)
+}
+
+func TestLicenseSetChangedEvent(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	hooks := webhook.New(db, &config.Config{Webhooks: []config.Webhook{{URL: "http://crm.example.com/hook", Events: []string{webhook.EventLicenseSetChanged}}}})
+
+	c, w := newTestContext(db)
+	c.Set("hooks", hooks)
+	c.Params = []gin.Param{gin.Param{Key: "keyId", Value: "123abc"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/changeFeaturesCountForKey/123abc?setCount=5", nil)
+	withIfMatch(db, c.Request, "123abc")
+	openapi.ChangeLicensesCountImpl(c)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	deliveries, _ := db.WebhookDeliveries(10)
+	assert.Equal(t, 1, len(deliveries))
+	ev := struct {
+		Data webhook.LicenseSetChanged `json:"data"`
+	}{}
+	assert.Nil(t, json.Unmarshal(deliveries[0].Payload, &ev))
+	assert.Equal(t, "123abc", ev.Data.KeyID)
+	assert.Equal(t, webhook.OperationCount, ev.Data.Operation)
+	assert.NotEmpty(t, ev.Data.Features)
+	for _, f := range ev.Data.Features {
+		assert.Equal(t, 5, f.Count)
+	}
+}
+
+func TestRedeliverWebhookImpl(t *testing.T) {
+	db := dao.MustInMemoryTestPool()
+	assert.Nil(t, db.EnqueueWebhook("http://ops.example.com/hook", webhook.EventKeyCreated, []byte(`{}`)))
+	deliveries, _ := db.WebhookDeliveries(1)
+	assert.Nil(t, db.MarkWebhookFailed(deliveries[0].Id, http.StatusInternalServerError, "unexpected response status 500"))
+
+	c, w := newTestContext(db)
+	c.Params = []gin.Param{gin.Param{Key: "deliveryId", Value: "100"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/webhooks/deliveries/100/redeliver", nil)
+	openapi.RedeliverWebhookImpl(c)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+	c, w = newTestContext(db)
+	c.Request, _ = http.NewRequest("GET", "/v1/webhooks/deliveries", nil)
+	openapi.ListWebhookDeliveriesImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	list := []openapi.WebhookDelivery{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, dao.OutboxFailed, list[0].Status)
+	assert.Equal(t, int32(http.StatusInternalServerError), list[0].ResponseCode)
+
+	c, w = newTestContext(db)
+	c.Params = []gin.Param{gin.Param{Key: "deliveryId", Value: "1"}}
+	c.Request, _ = http.NewRequest("POST", "/v1/webhooks/deliveries/1/redeliver", nil)
+	openapi.RedeliverWebhookImpl(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	res := openapi.WebhookDelivery{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, dao.OutboxPending, res.Status)
+	assert.Equal(t, int32(0), res.Attempts)
+}
@@ -0,0 +1,116 @@
+// Package outbox delivers mail messages stored in the outbox table. Messages are
+// put to the outbox by notifiers made with NewNotifyer and are sent by the Worker
+// in the background. Failed deliveries are retried with exponential backoff.
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+)
+
+const (
+	defaultPollInterval = 30 * time.Second
+	defaultBaseDelay    = time.Minute
+	defaultMaxDelay     = 6 * time.Hour
+	batchSize           = 50
+)
+
+// NewNotifyer returns a notifier that puts messages to the outbox instead of
+// sending them directly
+func NewNotifyer(db *dao.DbConn, conf *config.Config) mailnotify.MailNotifyer {
+	m := mailnotify.New(conf.MailServer, conf.MailPort, conf.MailUser, conf.MailPass).(*mailnotify.MailServiceImpl)
+	m.From = conf.MailSender()
+	m.FromName = conf.MailSenderName()
+	return mailnotify.Queued(m, db)
+}
+
+// Worker sends the pending messages of the outbox
+type Worker struct {
+	db           *dao.DbConn
+	Deliver      func(from string, to []string, msg []byte) error
+	PollInterval time.Duration
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	MaxAttempts  int
+}
+
+// NewWorker constructs a worker delivering messages via the mail server set in config
+func NewWorker(db *dao.DbConn, conf *config.Config) (*Worker, error) {
+	nt, err := mailnotify.NewFromConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	m := nt.(*mailnotify.MailServiceImpl)
+	return &Worker{
+		db:           db,
+		Deliver:      m.SendRaw,
+		PollInterval: defaultPollInterval,
+		BaseDelay:    defaultBaseDelay,
+		MaxDelay:     defaultMaxDelay,
+		MaxAttempts:  conf.MailMaxAttempts,
+	}, nil
+}
+
+// Backoff returns the delay before the next attempt after the given number of
+// failed attempts: base, 2*base, 4*base... but not more than max
+func Backoff(attempts int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// RunOnce makes a delivery attempt for every message due by now. It returns the
+// number of messages sent successfully.
+func (w *Worker) RunOnce(now time.Time) (sent int, err error) {
+	items, err := w.db.DueOutboxItems(now, batchSize)
+	if err != nil {
+		return
+	}
+	for _, it := range items {
+		if deliveryErr := w.Deliver(it.Sender, it.Recipients, it.Message); deliveryErr != nil {
+			attempts := it.Attempts + 1
+			log.Printf("Delivery of message %d (attempt %d) failed: %s", it.Id, attempts, deliveryErr)
+			if w.MaxAttempts > 0 && attempts >= w.MaxAttempts {
+				err = w.db.MarkOutboxFailed(it.Id, deliveryErr.Error())
+			} else {
+				err = w.db.MarkOutboxRetry(it.Id, deliveryErr.Error(), now.Add(Backoff(attempts, w.BaseDelay, w.MaxDelay)))
+			}
+		} else {
+			sent++
+			err = w.db.MarkOutboxSent(it.Id, now)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Run delivers the messages every PollInterval until the context is canceled
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.RunOnce(time.Now()); err != nil {
+			log.Println("Error when delivering mail from outbox: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package outbox_test
+
+import (
+	"bytes"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailnotify"
+	"github.com/vaefremov/pnglic/pkg/mailnotify/smtptest"
+	"github.com/vaefremov/pnglic/pkg/outbox"
+)
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, outbox.Backoff(1, time.Minute, time.Hour))
+	assert.Equal(t, 2*time.Minute, outbox.Backoff(2, time.Minute, time.Hour))
+	assert.Equal(t, 16*time.Minute, outbox.Backoff(5, time.Minute, time.Hour))
+	assert.Equal(t, time.Hour, outbox.Backoff(10, time.Minute, time.Hour))
+}
+
+func TestWorker(t *testing.T) {
+	srv, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	db := dao.MustInMemoryTestPool()
+	conf := &config.Config{MailServer: srv.Host(), MailPort: srv.Port(), MailMaxAttempts: 3, MailTransport: mailnotify.TransportPlain}
+
+	nt := outbox.NewNotifyer(db, conf).AddTo("client@org1.ru")
+	assert.Nil(t, nt.SendMail(mailnotify.Mail{Subject: "License file key 123abc for Org 1", Text: "Body",
+		Attachments: []mailnotify.Attachment{{FileName: "license.xml", Data: []byte("<license/>")}}}))
+	assert.Equal(t, 0, len(srv.Messages()))
+	items, err := db.OutboxItems(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, dao.OutboxPending, items[0].Status)
+	assert.Equal(t, "License file key 123abc for Org 1", items[0].Subject)
+
+	w, err := outbox.NewWorker(db, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// The first attempt fails, the next one is scheduled in BaseDelay
+	srv.RejectNext(1)
+	sent, err := w.RunOnce(now)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, sent)
+	it, _ := db.OutboxItem(items[0].Id)
+	assert.Equal(t, dao.OutboxPending, it.Status)
+	assert.Equal(t, 1, it.Attempts)
+	assert.Contains(t, it.LastError, "451")
+	assert.Equal(t, now.Add(w.BaseDelay).Format("2006-01-02 15:04:05"), it.NextAttempt.Format("2006-01-02 15:04:05"))
+
+	// Not due yet
+	sent, _ = w.RunOnce(now.Add(w.BaseDelay / 2))
+	assert.Equal(t, 0, sent)
+
+	sent, err = w.RunOnce(now.Add(w.BaseDelay))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sent)
+	it, _ = db.OutboxItem(items[0].Id)
+	assert.Equal(t, dao.OutboxSent, it.Status)
+	assert.Equal(t, "", it.LastError)
+	msgs := srv.Messages()
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, []string{"client@org1.ru"}, msgs[0].To)
+	parsed, err := mail.ReadMessage(bytes.NewReader(msgs[0].Data))
+	assert.Nil(t, err)
+	assert.Equal(t, "client@org1.ru", parsed.Header.Get("To"))
+}
+
+func TestWorkerGivesUp(t *testing.T) {
+	srv, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	db := dao.MustInMemoryTestPool()
+	conf := &config.Config{MailServer: srv.Host(), MailPort: srv.Port(), MailMaxAttempts: 2, MailTransport: mailnotify.TransportPlain}
+	assert.Nil(t, outbox.NewNotifyer(db, conf).AddTo("admin@pangea.ru").SendMessage("Subj", "Body"))
+	w, err := outbox.NewWorker(db, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.RejectNext(2)
+	now := time.Now()
+	w.RunOnce(now)
+	w.RunOnce(now.Add(time.Hour))
+	items, _ := db.OutboxItems(10)
+	assert.Equal(t, dao.OutboxFailed, items[0].Status)
+	assert.Equal(t, 2, items[0].Attempts)
+	sent, _ := w.RunOnce(now.Add(2 * time.Hour))
+	assert.Equal(t, 0, sent)
+
+	// Resend puts the message back to the queue
+	assert.Nil(t, db.ResendOutboxItem(items[0].Id, now.Add(2*time.Hour)))
+	sent, err = w.RunOnce(now.Add(2 * time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, 1, len(srv.Messages()))
+	assert.Equal(t, "Subj", items[0].Subject)
+}
@@ -0,0 +1,206 @@
+// Package rules applies the policy rules of licensed features: fixed versions,
+// minimal counts, longer terms and features always included in license sets.
+// The same rules are evaluated when features are prolonged, their counts are
+// changed, license sets are replaced and license files are made.
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"gopkg.in/yaml.v2"
+)
+
+// Rule is the policy of the features matching Feature, either the name of the
+// feature or package or a shell pattern (e.g. PANGEA_*). Zero values turn the
+// parts of the rule off.
+type Rule struct {
+	// Name is reported when the rule fires, Feature is used if it is empty
+	Name    string `yaml:"name" json:"name"`
+	Feature string `yaml:"feature" json:"feature"`
+	// Version the features always have
+	Version float32 `yaml:"version" json:"version,omitempty"`
+	// MinCount is the minimal number of licenses
+	MinCount int `yaml:"minCount" json:"minCount,omitempty"`
+	// ExtraMonths are added to the term when the features are prolonged
+	ExtraMonths int `yaml:"extraMonths" json:"extraMonths,omitempty"`
+	// AlwaysIncluded features are added to every non-empty license set,
+	// Feature must be a name, not a pattern
+	AlwaysIncluded bool `yaml:"alwaysIncluded" json:"alwaysIncluded,omitempty"`
+}
+
+// Fired tells how the rule changed the feature
+type Fired struct {
+	Rule    string
+	Feature string
+	Effect  string
+}
+
+func (f Fired) String() string {
+	return fmt.Sprintf("%s: %s %s", f.Rule, f.Feature, f.Effect)
+}
+
+// Engine evaluates the rules in the order they are listed
+type Engine struct {
+	Rules []Rule
+}
+
+// DefaultRules are used unless the rules file is configured: LM_CONSOLE is always
+// of version 1.0 and is licensed one year longer than the other features
+var DefaultRules = []Rule{
+	{Name: "lm-console", Feature: "LM_CONSOLE", Version: 1.0, ExtraMonths: 12},
+}
+
+// Default returns the engine of the built-in rules
+func Default() *Engine {
+	return &Engine{Rules: append([]Rule{}, DefaultRules...)}
+}
+
+// New checks the rules and returns the engine evaluating them
+func New(rules []Rule) (*Engine, error) {
+	res := &Engine{}
+	for i, r := range rules {
+		if r.Feature == "" {
+			return nil, fmt.Errorf("rule %d: feature is not set", i+1)
+		}
+		if r.Name == "" {
+			r.Name = r.Feature
+		}
+		if _, err := path.Match(r.Feature, ""); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid feature pattern %s", r.Name, r.Feature)
+		}
+		if r.AlwaysIncluded && strings.ContainsAny(r.Feature, `*?[\`) {
+			return nil, fmt.Errorf("rule %s: always included feature must be a name, not a pattern", r.Name)
+		}
+		if r.Version < 0 || r.MinCount < 0 || r.ExtraMonths < 0 {
+			return nil, fmt.Errorf("rule %s: version, minCount and extraMonths must not be negative", r.Name)
+		}
+		res.Rules = append(res.Rules, r)
+	}
+	return res, nil
+}
+
+// Load reads the rules from the YAML file with the list of rules under the rules key,
+// the built-in rules are returned if the path is empty
+func Load(rulesPath string) (*Engine, error) {
+	if rulesPath == "" {
+		return Default(), nil
+	}
+	f, err := os.Open(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tmp := struct {
+		Rules []Rule `yaml:"rules"`
+	}{}
+	if err = yaml.NewDecoder(f).Decode(&tmp); err != nil {
+		return nil, errors.Wrap(err, rulesPath)
+	}
+	res, err := New(tmp.Rules)
+	return res, errors.Wrap(err, rulesPath)
+}
+
+// matching returns the rules applicable to the feature
+func (e *Engine) matching(feature string) (res []Rule) {
+	for _, r := range e.Rules {
+		if ok, _ := path.Match(r.Feature, feature); ok {
+			res = append(res, r)
+		}
+	}
+	return
+}
+
+// Prolong prolongs the feature till the date adding the extra terms of the rules,
+// the version is changed unless newVersion is 0. Apply must be called on the
+// changed license set afterwards.
+func (e *Engine) Prolong(f dao.LicenseSetItem, till time.Time, newVersion float32) (dao.LicenseSetItem, []Fired) {
+	f.End = till
+	if newVersion > 0.0 {
+		f.Version = newVersion
+	}
+	fired := []Fired{}
+	for _, r := range e.matching(f.Feature) {
+		if r.ExtraMonths > 0 {
+			f.End = f.End.AddDate(0, r.ExtraMonths, 0)
+			fired = append(fired, Fired{Rule: r.Name, Feature: f.Feature,
+				Effect: fmt.Sprintf("term extended by %d month(s) till %s", r.ExtraMonths, f.End.Format("2006-01-02"))})
+		}
+	}
+	return f, fired
+}
+
+// BaseEnd returns the end of the feature without the extra terms of the rules. Terms
+// counted from the current end start there, so that Prolong adds the extra terms once
+// rather than on top of the ones added by the previous prolongation.
+func (e *Engine) BaseEnd(f dao.LicenseSetItem) time.Time {
+	res := f.End
+	for _, r := range e.matching(f.Feature) {
+		if r.ExtraMonths > 0 {
+			res = res.AddDate(0, -r.ExtraMonths, 0)
+		}
+	}
+	return res
+}
+
+// Apply adds the always included features to the license set and enforces the versions
+// and the minimal counts of features. The set is not changed, the result is a copy.
+func (e *Engine) Apply(licSet []dao.LicenseSetItem) ([]dao.LicenseSetItem, []Fired) {
+	fired := []Fired{}
+	res := append([]dao.LicenseSetItem{}, licSet...)
+	if len(res) > 0 {
+		present := map[string]bool{}
+		for _, f := range res {
+			present[f.Feature] = true
+		}
+		for _, r := range e.Rules {
+			if !r.AlwaysIncluded || present[r.Feature] {
+				continue
+			}
+			res = append(res, includedFeature(r, licSet))
+			present[r.Feature] = true
+			fired = append(fired, Fired{Rule: r.Name, Feature: r.Feature, Effect: "added to the license set"})
+		}
+	}
+	for i, f := range res {
+		for _, r := range e.matching(f.Feature) {
+			if r.Version > 0 && f.Version != r.Version {
+				fired = append(fired, Fired{Rule: r.Name, Feature: f.Feature, Effect: fmt.Sprintf("version changed from %.2f to %.2f", f.Version, r.Version)})
+				f.Version = r.Version
+			}
+			if f.Count < r.MinCount {
+				fired = append(fired, Fired{Rule: r.Name, Feature: f.Feature, Effect: fmt.Sprintf("count raised from %d to %d", f.Count, r.MinCount)})
+				f.Count = r.MinCount
+			}
+		}
+		res[i] = f
+	}
+	return res, fired
+}
+
+// includedFeature makes the always included feature licensed for the whole term of
+// the license set, its version is the highest one in the set unless the rule fixes it
+func includedFeature(r Rule, licSet []dao.LicenseSetItem) dao.LicenseSetItem {
+	res := dao.LicenseSetItem{KeyID: licSet[0].KeyID, Feature: r.Feature, Version: r.Version, Count: 1,
+		Start: licSet[0].Start, End: licSet[0].End}
+	if r.MinCount > res.Count {
+		res.Count = r.MinCount
+	}
+	for _, f := range licSet {
+		if f.Start.Before(res.Start) {
+			res.Start = f.Start
+		}
+		if f.End.After(res.End) {
+			res.End = f.End
+		}
+		if r.Version == 0 && f.Version > res.Version {
+			res.Version = f.Version
+		}
+	}
+	return res
+}
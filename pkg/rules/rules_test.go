@@ -0,0 +1,107 @@
+package rules_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/rules"
+)
+
+func date(s string) time.Time {
+	res, _ := time.Parse("2006-01-02", s)
+	return res
+}
+
+func TestProlongDefault(t *testing.T) {
+	e := rules.Default()
+	till := date("2030-01-01")
+
+	f, fired := e.Prolong(dao.LicenseSetItem{Feature: "LM_CONSOLE", Version: 19.0, Count: 1}, till, 20.0)
+	assert.Equal(t, date("2031-01-01"), f.End)
+	assert.Equal(t, float32(20.0), f.Version)
+	assert.Equal(t, []rules.Fired{{Rule: "lm-console", Feature: "LM_CONSOLE", Effect: "term extended by 12 month(s) till 2031-01-01"}}, fired)
+
+	set, fired := e.Apply([]dao.LicenseSetItem{f})
+	assert.Equal(t, float32(1.0), set[0].Version)
+	assert.Equal(t, []rules.Fired{{Rule: "lm-console", Feature: "LM_CONSOLE", Effect: "version changed from 20.00 to 1.00"}}, fired)
+
+	f, fired = e.Prolong(dao.LicenseSetItem{Feature: "PANGEA_BASE", Version: 19.0}, till, 0)
+	assert.Equal(t, till, f.End)
+	assert.Equal(t, float32(19.0), f.Version)
+	assert.Empty(t, fired)
+}
+
+func TestBaseEnd(t *testing.T) {
+	e := rules.Default()
+	assert.Equal(t, date("2030-01-01"), e.BaseEnd(dao.LicenseSetItem{Feature: "LM_CONSOLE", End: date("2031-01-01")}))
+	assert.Equal(t, date("2031-01-01"), e.BaseEnd(dao.LicenseSetItem{Feature: "F1", End: date("2031-01-01")}))
+}
+
+func TestApply(t *testing.T) {
+	e, err := rules.New([]rules.Rule{
+		{Feature: "PANGEA_*", MinCount: 2},
+		{Name: "viewer", Feature: "VIEWER", Version: 1.5, AlwaysIncluded: true},
+	})
+	assert.Nil(t, err)
+	licSet := []dao.LicenseSetItem{
+		{KeyID: "k1", Feature: "PANGEA_BASE", Version: 19, Count: 1, Start: date("2020-01-01"), End: date("2021-01-01")},
+		{KeyID: "k1", Feature: "F1", Version: 20, Count: 1, Start: date("2019-06-01"), End: date("2022-01-01")},
+	}
+	res, fired := e.Apply(licSet)
+	assert.Equal(t, 1, licSet[0].Count, "the input must not change")
+	assert.Equal(t, []dao.LicenseSetItem{
+		{KeyID: "k1", Feature: "PANGEA_BASE", Version: 19, Count: 2, Start: date("2020-01-01"), End: date("2021-01-01")},
+		{KeyID: "k1", Feature: "F1", Version: 20, Count: 1, Start: date("2019-06-01"), End: date("2022-01-01")},
+		{KeyID: "k1", Feature: "VIEWER", Version: 1.5, Count: 1, Start: date("2019-06-01"), End: date("2022-01-01")},
+	}, res)
+	assert.Equal(t, []rules.Fired{
+		{Rule: "viewer", Feature: "VIEWER", Effect: "added to the license set"},
+		{Rule: "PANGEA_*", Feature: "PANGEA_BASE", Effect: "count raised from 1 to 2"},
+	}, fired)
+
+	res, fired = e.Apply(res)
+	assert.Equal(t, 3, len(res))
+	assert.Empty(t, fired)
+
+	res, fired = e.Apply(nil)
+	assert.Empty(t, res)
+	assert.Empty(t, fired)
+}
+
+func TestNew(t *testing.T) {
+	for _, r := range []rules.Rule{
+		{},
+		{Feature: "F[1"},
+		{Feature: "F*", AlwaysIncluded: true},
+		{Feature: "F1", MinCount: -1},
+	} {
+		_, err := rules.New([]rules.Rule{r})
+		assert.NotNil(t, err, r)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	e, err := rules.Load("")
+	assert.Nil(t, err)
+	assert.Equal(t, rules.DefaultRules, e.Rules)
+
+	dir, err := ioutil.TempDir("", "rules")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	rulesPath := filepath.Join(dir, "rules.yaml")
+	assert.Nil(t, ioutil.WriteFile(rulesPath, []byte("rules:\n  - feature: LM_CONSOLE\n    version: 1.0\n    extraMonths: 24\n"), 0644))
+	e, err = rules.Load(rulesPath)
+	assert.Nil(t, err)
+	assert.Equal(t, []rules.Rule{{Name: "LM_CONSOLE", Feature: "LM_CONSOLE", Version: 1.0, ExtraMonths: 24}}, e.Rules)
+
+	assert.Nil(t, ioutil.WriteFile(rulesPath, []byte("rules:\n  - version: 1.0\n"), 0644))
+	_, err = rules.Load(rulesPath)
+	assert.NotNil(t, err)
+	_, err = rules.Load(filepath.Join(dir, "missing.yaml"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a job is run next
+type Schedule interface {
+	// Next returns the first moment strictly after t the job should be run at
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// Parse parses the schedule spec. The spec is either the time of day "HH:MM",
+// the job is run daily, or a cron expression of 5 fields: minute, hour, day of
+// month, month and day of week (0 or 7 is Sunday). Cron fields may be *, numbers,
+// ranges a-b and lists separated with commas, */n and a-b/n select every n-th value.
+// Times are taken in the given location.
+func Parse(spec string, loc *time.Location) (Schedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	spec = strings.TrimSpace(spec)
+	if fields := strings.Fields(spec); len(fields) == 5 {
+		return parseCron(spec, fields, loc)
+	}
+	tm, err := time.Parse("15:04", spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: expected HH:MM or 5 cron fields", spec)
+	}
+	return daily{hour: tm.Hour(), minute: tm.Minute(), loc: loc}, nil
+}
+
+// daily is run once a day at the given time
+type daily struct {
+	hour, minute int
+	loc          *time.Location
+}
+
+func (d daily) Next(t time.Time) time.Time {
+	t = t.In(d.loc)
+	next := time.Date(t.Year(), t.Month(), t.Day(), d.hour, d.minute, 0, 0, d.loc)
+	if !next.After(t) {
+		next = time.Date(t.Year(), t.Month(), t.Day()+1, d.hour, d.minute, 0, 0, d.loc)
+	}
+	return next
+}
+
+func (d daily) String() string {
+	return fmt.Sprintf("%02d:%02d %s", d.hour, d.minute, d.loc)
+}
+
+// cron is a cron expression, every field is the set of allowed values
+type cron struct {
+	spec                          string
+	minute, hour, dom, month, dow map[int]bool
+	domRestricted, dowRestricted  bool
+	loc                           *time.Location
+}
+
+// maxYears limits the search of the next moment for expressions like "0 0 30 2 *"
+const maxYears = 5
+
+func parseCron(spec string, fields []string, loc *time.Location) (Schedule, error) {
+	c := cron{spec: spec, loc: loc}
+	var err error
+	if c.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute in schedule %q: %s", spec, err)
+	}
+	if c.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour in schedule %q: %s", spec, err)
+	}
+	if c.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month in schedule %q: %s", spec, err)
+	}
+	if c.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month in schedule %q: %s", spec, err)
+	}
+	if c.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week in schedule %q: %s", spec, err)
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.domRestricted = fields[2] != "*"
+	c.dowRestricted = fields[4] != "*"
+	return c, nil
+}
+
+func parseField(field string, min, max int) (map[int]bool, error) {
+	res := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			res[v] = true
+		}
+	}
+	return res, nil
+}
+
+func (c cron) dayMatches(t time.Time) bool {
+	domOk := c.dom[t.Day()]
+	dowOk := c.dow[int(t.Weekday())]
+	if c.domRestricted && c.dowRestricted {
+		// As in the classic cron, either of the day fields may match
+		return domOk || dowOk
+	}
+	return domOk && dowOk
+}
+
+func (c cron) Next(t time.Time) time.Time {
+	t = t.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxYears, 0, 0)
+	for t.Before(limit) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.loc)
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.loc)
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c cron) String() string {
+	return fmt.Sprintf("%s %s", c.spec, c.loc)
+}
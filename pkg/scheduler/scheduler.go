@@ -0,0 +1,194 @@
+// Package scheduler runs periodic jobs of the server (expiry checks, digests) at
+// the moments set by their schedules. A job is never run concurrently with itself,
+// failures are logged and do not stop the scheduler. Jobs may also be run on demand.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrUnknownJob is returned by RunNow for names that were not added to the scheduler
+var ErrUnknownJob = errors.New("unknown job")
+
+// ErrJobRunning is returned by RunNow if the job is being run already
+var ErrJobRunning = errors.New("job is running already")
+
+// JobFunc does the job, it should return early when the context is canceled
+type JobFunc func(ctx context.Context) error
+
+// Status describes the job and the result of its last run
+type Status struct {
+	Name      string
+	Schedule  string
+	Next      time.Time
+	Running   bool
+	LastStart time.Time
+	LastEnd   time.Time
+	LastError string
+}
+
+type job struct {
+	name     string
+	schedule Schedule
+	fn       JobFunc
+
+	mu        sync.Mutex
+	running   bool
+	lastStart time.Time
+	lastEnd   time.Time
+	lastErr   error
+}
+
+// Scheduler runs the added jobs
+type Scheduler struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// New returns a scheduler without jobs
+func New() *Scheduler {
+	return &Scheduler{jobs: map[string]*job{}}
+}
+
+// Add adds the job, names of jobs must be unique
+func (s *Scheduler) Add(name string, schedule Schedule, fn JobFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[name]; ok {
+		return fmt.Errorf("job %s has been added already", name)
+	}
+	s.jobs[name] = &job{name: name, schedule: schedule, fn: fn}
+	return nil
+}
+
+// Jobs returns status of all the jobs ordered by name
+func (s *Scheduler) Jobs() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := []Status{}
+	now := time.Now()
+	for _, j := range s.jobs {
+		res = append(res, j.status(now))
+	}
+	sort.Slice(res, func(i, k int) bool { return res[i].Name < res[k].Name })
+	return res
+}
+
+// Job returns status of the job
+func (s *Scheduler) Job(name string) (Status, error) {
+	j, ok := s.job(name)
+	if !ok {
+		return Status{}, ErrUnknownJob
+	}
+	return j.status(time.Now()), nil
+}
+
+func (s *Scheduler) job(name string) (*job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	return j, ok
+}
+
+// RunNow runs the job immediately and waits for it to finish. The error of the
+// job itself is returned, as well as ErrUnknownJob and ErrJobRunning.
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	j, ok := s.job(name)
+	if !ok {
+		return ErrUnknownJob
+	}
+	if !j.start() {
+		return ErrJobRunning
+	}
+	return j.run(ctx)
+}
+
+// Run runs every job by its schedule until the context is canceled. It returns
+// after the jobs being run have finished.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	jobs := []*job{}
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j *job) {
+			defer wg.Done()
+			j.loop(ctx)
+		}(j)
+	}
+	wg.Wait()
+}
+
+func (j *job) loop(ctx context.Context) {
+	for {
+		next := j.schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("Job %s (%s) will never run", j.name, j.schedule)
+			return
+		}
+		log.Printf("Job %s will run at %s", j.name, next)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !j.start() {
+			log.Printf("Job %s is running already, skipped", j.name)
+			continue
+		}
+		j.run(ctx)
+	}
+}
+
+// start marks the job as running unless it is running already
+func (j *job) start() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.running {
+		return false
+	}
+	j.running = true
+	j.lastStart = time.Now()
+	return true
+}
+
+func (j *job) run(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job %s panicked: %v", j.name, r)
+		}
+		if err != nil {
+			log.Printf("Job %s failed: %s", j.name, err)
+		}
+		j.mu.Lock()
+		j.running = false
+		j.lastEnd = time.Now()
+		j.lastErr = err
+		j.mu.Unlock()
+	}()
+	log.Printf("Job %s started", j.name)
+	return j.fn(ctx)
+}
+
+func (j *job) status(now time.Time) Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	res := Status{Name: j.name, Schedule: j.schedule.String(), Next: j.schedule.Next(now), Running: j.running,
+		LastStart: j.lastStart, LastEnd: j.lastEnd}
+	if j.lastErr != nil {
+		res.LastError = j.lastErr.Error()
+	}
+	return res
+}
@@ -0,0 +1,130 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/pkg/scheduler"
+)
+
+func mustParse(t *testing.T, spec string, loc *time.Location) scheduler.Schedule {
+	s, err := scheduler.Parse(spec, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDaily(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*3600)
+	s := mustParse(t, "09:30", msk)
+	from := time.Date(2020, 3, 10, 5, 0, 0, 0, time.UTC) // 08:00 MSK
+	assert.Equal(t, time.Date(2020, 3, 10, 9, 30, 0, 0, msk), s.Next(from))
+	from = time.Date(2020, 3, 10, 6, 30, 0, 0, time.UTC) // 09:30 MSK exactly
+	assert.Equal(t, time.Date(2020, 3, 11, 9, 30, 0, 0, msk), s.Next(from))
+	// The end of month
+	assert.Equal(t, time.Date(2020, 4, 1, 9, 30, 0, 0, msk), s.Next(time.Date(2020, 3, 31, 10, 0, 0, 0, msk)))
+}
+
+func TestCron(t *testing.T) {
+	from := time.Date(2020, 3, 10, 8, 7, 30, 0, time.UTC) // Tuesday
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2020, 3, 10, 8, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 3, 10, 8, 15, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2020, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{"0 7 * * *", time.Date(2020, 3, 11, 7, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2020, 3, 10, 9, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1", time.Date(2020, 3, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2020, 3, 15, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 8,20 * * *", time.Date(2020, 3, 10, 20, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are set
+		{"0 0 1 * 5", time.Date(2020, 3, 13, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.next, mustParse(t, c.spec, time.UTC).Next(from), c.spec)
+	}
+	assert.True(t, mustParse(t, "0 0 30 2 *", time.UTC).Next(from).IsZero())
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "9", "25:00", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := scheduler.Parse(spec, time.UTC)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+// every is run at the given interval
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time { return t.Add(time.Duration(e)) }
+func (e every) String() string             { return time.Duration(e).String() }
+
+func TestRunNow(t *testing.T) {
+	s := scheduler.New()
+	var runs int32
+	failing := errors.New("mail server is down")
+	release := make(chan struct{})
+	assert.Nil(t, s.Add("slow", every(time.Hour), func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		<-release
+		return nil
+	}))
+	assert.Nil(t, s.Add("failing", every(time.Hour), func(ctx context.Context) error { return failing }))
+	assert.Nil(t, s.Add("panicking", every(time.Hour), func(ctx context.Context) error { panic("boom") }))
+	assert.NotNil(t, s.Add("slow", every(time.Hour), nil))
+
+	assert.Equal(t, scheduler.ErrUnknownJob, s.RunNow(context.Background(), "none"))
+	assert.Equal(t, failing, s.RunNow(context.Background(), "failing"))
+	assert.Contains(t, s.RunNow(context.Background(), "panicking").Error(), "boom")
+
+	done := make(chan error)
+	go func() { done <- s.RunNow(context.Background(), "slow") }()
+	for atomic.LoadInt32(&runs) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, scheduler.ErrJobRunning, s.RunNow(context.Background(), "slow"))
+	st, _ := s.Job("slow")
+	assert.True(t, st.Running)
+	close(release)
+	assert.Nil(t, <-done)
+
+	jobs := s.Jobs()
+	assert.Equal(t, []string{"failing", "panicking", "slow"}, []string{jobs[0].Name, jobs[1].Name, jobs[2].Name})
+	assert.Equal(t, failing.Error(), jobs[0].LastError)
+	assert.False(t, jobs[2].Running)
+	assert.Equal(t, "", jobs[2].LastError)
+	assert.False(t, jobs[2].LastEnd.Before(jobs[2].LastStart))
+}
+
+func TestRun(t *testing.T) {
+	s := scheduler.New()
+	var runs int32
+	assert.Nil(t, s.Add("frequent", every(10*time.Millisecond), func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(stopped)
+	}()
+	for atomic.LoadInt32(&runs) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler has not stopped")
+	}
+}
@@ -0,0 +1,26 @@
+package view
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// BulkProlong outputs the form to prolong features of many keys at once
+func BulkProlong(c *gin.Context, params *gin.H) {
+	db := c.MustGet("db").(*dao.DbConn)
+	clients, err := db.Clients()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	features, err := db.Features()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	(*params)["clients"] = clients
+	(*params)["features"] = features
+	c.HTML(http.StatusOK, "bulkprolong.html", params)
+}
@@ -7,17 +7,38 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailtmpl"
 )
 
 type ClientOut struct {
 	dao.Organization
-	Keys []string
+	Keys        []string
+	Settings    dao.ClientSettings
+	ContactList []dao.Contact
 }
 
 // Clients output list of clients
 func Clients(c *gin.Context, params *gin.H) {
 	db := c.MustGet("db").(*dao.DbConn)
 	clients, err := db.Clients()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	settings, err := db.AllClientSettings()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	contacts, err := db.AllContacts()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	contactsOfOrg := map[int][]dao.Contact{}
+	for _, ct := range contacts {
+		contactsOfOrg[ct.OrgId] = append(contactsOfOrg[ct.OrgId], ct)
+	}
 	sortOrder := c.Query("sort")
 	clientsOut := []ClientOut{}
 	for _, cl := range clients {
@@ -29,13 +50,11 @@ func Clients(c *gin.Context, params *gin.H) {
 		} else {
 			fmt.Println(cl.Id, err)
 		}
-		curClient := ClientOut{Organization: cl, Keys: keys}
+		curClient := ClientOut{Organization: cl, Keys: keys, Settings: settings.Get(cl.Id), ContactList: contactsOfOrg[cl.Id]}
 		clientsOut = append(clientsOut, curClient)
 	}
 	(*params)["clients"] = clientsOut
-	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
-	}
+	(*params)["langs"] = mailtmpl.Langs()
 
 	// Sorting according with the sorting parameter
 	sort.Slice(clientsOut, func(i, j int) bool {
@@ -0,0 +1,103 @@
+package view
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+type compareCell struct {
+	*dao.ComparedFeature
+	VersionDiffers bool
+	CountDiffers   bool
+	EndDiffers     bool
+}
+
+type compareRow struct {
+	Feature   string
+	IsPackage bool
+	Differs   bool
+	Cells     []compareCell
+}
+
+// comparedKeyIDs returns the keys listed in the keys parameter, or the keys of the client
+// in orgId that are not retired
+func comparedKeyIDs(c *gin.Context, db *dao.DbConn) (res []string, err error) {
+	res = []string{}
+	if orgID, err := strconv.Atoi(c.Query("orgId")); err == nil {
+		keys, err := db.KeysOfOrg(orgID)
+		if err != nil {
+			return res, err
+		}
+		for _, k := range keys {
+			_, retired, err := db.KeyRetirement(k.Id)
+			if err != nil {
+				return res, err
+			}
+			if !retired {
+				res = append(res, k.Id)
+			}
+		}
+		return res, nil
+	}
+	seen := map[string]bool{}
+	for _, keyID := range strings.Split(c.Query("keys"), ",") {
+		keyID = strings.TrimSpace(keyID)
+		if keyID != "" && !seen[keyID] {
+			seen[keyID] = true
+			res = append(res, keyID)
+		}
+	}
+	return
+}
+
+// Compare outputs the feature-by-key matrix of the license sets of the keys
+func Compare(c *gin.Context, params *gin.H) {
+	db := c.MustGet("db").(*dao.DbConn)
+	keyIDs, err := comparedKeyIDs(c, db)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	keys, unknown := []string{}, []string{}
+	for _, keyID := range keyIDs {
+		if _, err := db.KeyOfWhichOrg(keyID); err == nil {
+			keys = append(keys, keyID)
+		} else {
+			unknown = append(unknown, keyID)
+		}
+	}
+	rows := []compareRow{}
+	identical := true
+	if len(keys) > 1 {
+		comparison, err := db.CompareLicenseSets(keys)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		for _, f := range comparison {
+			row := compareRow{Feature: f.Feature, IsPackage: f.IsPackage, Differs: len(f.Differs) > 0}
+			differs := map[string]bool{}
+			for _, d := range f.Differs {
+				differs[d] = true
+			}
+			for _, it := range f.Items {
+				row.Cells = append(row.Cells, compareCell{ComparedFeature: it,
+					VersionDiffers: differs[dao.CompareVersion],
+					CountDiffers:   differs[dao.CompareCount],
+					EndDiffers:     differs[dao.CompareEnd]})
+			}
+			identical = identical && !row.Differs
+			rows = append(rows, row)
+		}
+	}
+	(*params)["keys"] = keys
+	(*params)["keysParam"] = strings.Join(keyIDs, ",")
+	(*params)["unknownKeys"] = unknown
+	(*params)["rows"] = rows
+	(*params)["identical"] = identical
+	c.HTML(http.StatusOK, "compare.html", params)
+}
@@ -0,0 +1,34 @@
+package view
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// Contacts outputs the page to edit contact persons of a client
+func Contacts(c *gin.Context, params *gin.H) {
+	db := c.MustGet("db").(*dao.DbConn)
+	clientID, err := strconv.Atoi(c.Query("clientId"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	clientName, err := db.ClientNameByID(clientID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	contacts, err := db.Contacts(clientID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	(*params)["clientId"] = clientID
+	(*params)["clientName"] = clientName
+	(*params)["contacts"] = contacts
+	(*params)["roles"] = []string{dao.RoleLicenseAdmin, dao.RoleTechnical, dao.RoleBilling}
+	c.HTML(http.StatusOK, "contacts.html", params)
+}
@@ -0,0 +1,92 @@
+package view
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/chkexprd"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// forecastMonths is the number of months shown in the forecast on the start page
+const forecastMonths = 12
+
+// forecastBarHeight is the height (in pixels) of the tallest bar of the chart
+const forecastBarHeight = 60
+
+type forecastCell struct {
+	Month    string
+	Features int
+	Keys     int
+	Height   int
+}
+
+type forecastRow struct {
+	Label    string
+	Features int
+	Cells    []forecastCell
+}
+
+// forecastChart is the table of features ending in each month of the period by client
+type forecastChart struct {
+	From   string
+	To     string
+	Months []string
+	Rows   []forecastRow
+	Totals []int
+}
+
+func makeForecastChart(db *dao.DbConn, from time.Time, to time.Time) (res forecastChart, err error) {
+	groups, err := chkexprd.Forecast(db, from, to, chkexprd.GroupByClient)
+	if err != nil {
+		return
+	}
+	res = forecastChart{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Months: chkexprd.Months(from, to)}
+	res.Totals = make([]int, len(res.Months))
+	max := 0
+	for _, g := range groups {
+		byMonth := map[string]chkexprd.ForecastMonth{}
+		for _, m := range g.Months {
+			byMonth[m.Month] = m
+			if m.Features > max {
+				max = m.Features
+			}
+		}
+		row := forecastRow{Label: g.Label, Features: g.Features}
+		for i, month := range res.Months {
+			m := byMonth[month]
+			row.Cells = append(row.Cells, forecastCell{Month: month, Features: m.Features, Keys: m.Keys})
+			res.Totals[i] += m.Features
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	for _, row := range res.Rows {
+		for i := range row.Cells {
+			if max > 0 {
+				row.Cells[i].Height = row.Cells[i].Features * forecastBarHeight / max
+			}
+		}
+	}
+	return
+}
+
+// Forecast outputs the month-by-month chart of features ending within the period
+func Forecast(c *gin.Context, params *gin.H) {
+	db := c.MustGet("db").(*dao.DbConn)
+	from := time.Now()
+	if tmp, err := time.Parse("2006-01-02", c.Query("from")); err == nil {
+		from = tmp
+	}
+	to := from.AddDate(0, forecastMonths, 0)
+	if tmp, err := time.Parse("2006-01-02", c.Query("to")); err == nil && tmp.After(from) {
+		to = tmp
+	}
+	chart, err := makeForecastChart(db, from, to)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	(*params)["forecast"] = chart
+	c.HTML(http.StatusOK, "forecast.html", params)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/vaefremov/pnglic/pkg/chkexprd"
 	"github.com/vaefremov/pnglic/pkg/dao"
 	"github.com/vaefremov/pnglic/pkg/mailnotify"
+	"github.com/vaefremov/pnglic/pkg/rules"
 )
 
 // Index is the index handler.
@@ -38,26 +39,65 @@ func Index(c *gin.Context) {
 		SinglePackageContent(c, &params)
 	case "/clients.html":
 		Clients(c, &params)
+	case "/contacts.html":
+		Contacts(c, &params)
+	case "/outbox.html":
+		Outbox(c, &params)
+	case "/bulkprolong.html":
+		BulkProlong(c, &params)
+	case "/compare.html":
+		Compare(c, &params)
+	case "/forecast.html":
+		Forecast(c, &params)
+	case "/webhooks.html":
+		Webhooks(c, &params)
 	default:
 		StartPage(c, &params)
 	}
 }
 
+// recentNotificationsDays is how far back the start page looks into the notifications log
+const recentNotificationsDays = 30
+
 func StartPage(c *gin.Context, params *gin.H) {
 	conf := c.MustGet("conf").(*config.Config)
 	db := c.MustGet("db").(*dao.DbConn)
-	expTerm := time.Duration(time.Duration(24) * time.Duration(conf.DaysToExpire1) * time.Hour)
+	expDays := conf.DaysToExpire1
+	if thresholds := conf.Thresholds(); len(thresholds) > 0 {
+		expDays = thresholds[len(thresholds)-1]
+	}
+	expTerm := time.Duration(time.Duration(24) * time.Duration(expDays) * time.Hour)
 	expired, err := chkexprd.FindFeaturesWillExpire(db, expTerm)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	lapsed, err := chkexprd.FindExpiredFeatures(db, time.Duration(conf.ExpiredReportDays)*24*time.Hour)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	notifications, err := db.NotificationsSince(time.Now().AddDate(0, 0, -recentNotificationsDays))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	forecast, err := makeForecastChart(db, time.Now(), time.Now().AddDate(0, forecastMonths, 0))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 	expiredNo := len(expired)
 	// fmt.Println(conf.DaysToExpire1, expiredNo)
 	(*params)["expired_no"] = expiredNo
 	(*params)["exp_term"] = expTerm
-	(*params)["exp_days"] = conf.DaysToExpire1
+	(*params)["exp_days"] = expDays
 	(*params)["will_expire"] = expired
+	(*params)["expired"] = lapsed
+	(*params)["expired_days"] = conf.ExpiredReportDays
+	(*params)["notifications"] = notifications
+	(*params)["notifications_days"] = recentNotificationsDays
+	(*params)["forecast"] = forecast
 	c.HTML(http.StatusOK, "index.html", params)
 }
 
@@ -103,16 +143,64 @@ func KeyFeatures(c *gin.Context, params *gin.H) {
 		featuresOut = append(featuresOut, tmp)
 		ind += 1
 	}
+	allFeatures, err := db.Features()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	revision, err := db.LicenseSetRevision(keyID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 	client, err := db.KeyOfWhichOrg(keyID)
 	conf := c.MustGet("conf").(*config.Config)
+	otherKeys := []string{}
+	if keys, err := db.KeysOfOrg(client.Id); err == nil {
+		for _, k := range keys {
+			if k.Id != keyID {
+				otherKeys = append(otherKeys, k.Id)
+			}
+		}
+	}
+	retirement, retired, err := db.KeyRetirement(keyID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	replacements, err := db.ReplacementChain(keyID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	fileRecipients := []string{}
+	if contacts, err := db.Contacts(client.Id); err == nil {
+		for _, ct := range contacts {
+			if ct.NotifyFiles && ct.Email != "" {
+				fileRecipients = append(fileRecipients, ct.Email)
+			}
+		}
+	}
 	(*params)["features"] = featuresOut
 	(*params)["keyId"] = keyID
 	(*params)["client"] = client
+	(*params)["proposedStart"] = time.Now().Format("2006-01-02")
 	(*params)["proposedExtTerm"] = time.Now().AddDate(0, 1, 0).Format("2006-01-02")
+	(*params)["allFeatures"] = allFeatures
+	(*params)["otherKeys"] = otherKeys
+	(*params)["retired"] = retired
+	(*params)["retirement"] = retirement
+	(*params)["replacements"] = replacements
+	(*params)["licenseSetETag"] = strconv.Quote(strconv.Itoa(revision))
 	(*params)["proposedCount"] = proposedCount
 	(*params)["mailTo"] = conf.AdminMail
+	(*params)["fileRecipients"] = fileRecipients
 	(*params)["licenseFileName"] = mailnotify.MakeLicenseFileName(client.Name, keyID)
 	(*params)["fullPage"] = fullPage
+	if e, ok := c.Get("rules"); ok {
+		_, fired := e.(*rules.Engine).Apply(features)
+		(*params)["fileRules"] = fired
+	}
 	c.HTML(http.StatusOK, "keyfeatures.html", params)
 }
 
@@ -129,10 +217,17 @@ func History(c *gin.Context, params *gin.H) {
 		clientID = tmp
 	} else {
 		(*params)["history"] = []dao.HistoryItem{}
+		(*params)["replacements"] = []dao.KeyReplacement{}
 		c.HTML(http.StatusOK, "licenses.html", params)
 		fmt.Println("Unable to get client ID")
 		return
 	}
+	replacements, err := db.KeyReplacements(clientID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	(*params)["replacements"] = replacements
 	history, err := db.HistoryForClientId(clientID)
 	fmt.Println("History lenfth:", len(history))
 	sort.Slice(history, func(i, j int) bool { return history[i].IssueTime.After(history[j].IssueTime) })
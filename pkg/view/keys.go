@@ -13,6 +13,7 @@ import (
 type KeyOut struct {
 	dao.HWKey
 	ClientName string
+	ReplacedBy string
 }
 
 // Keys output the Keys page
@@ -28,11 +29,20 @@ func Keys(c *gin.Context, params *gin.H) {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	replacements, err := db.KeyReplacements(-1)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	replacedBy := map[string]string{}
+	for _, r := range replacements {
+		replacedBy[r.KeyID] = r.ReplacedBy
+	}
 	keysOut := []KeyOut{}
 	for _, k := range keys {
 		if orgName, err := db.ClientNameByID(k.OrgId); err == nil {
 			if selectedOrgID == -1 || selectedOrgID == k.OrgId {
-				keysOut = append(keysOut, KeyOut{HWKey: k, ClientName: orgName})
+				keysOut = append(keysOut, KeyOut{HWKey: k, ClientName: orgName, ReplacedBy: replacedBy[k.Id]})
 			}
 		} else {
 			fmt.Println(k.OrgId, err)
@@ -52,5 +62,6 @@ func Keys(c *gin.Context, params *gin.H) {
 		}
 	})
 	(*params)["keys"] = keysOut
+	(*params)["orgId"] = selectedOrgID
 	c.HTML(http.StatusOK, "keys.html", params)
 }
@@ -0,0 +1,26 @@
+package view
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/mailtmpl"
+)
+
+// outboxPageSize is the number of the most recent messages shown in the outbox page
+const outboxPageSize = 100
+
+// Outbox outputs the list of outgoing messages with their delivery status
+func Outbox(c *gin.Context, params *gin.H) {
+	db := c.MustGet("db").(*dao.DbConn)
+	items, err := db.OutboxItems(outboxPageSize)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	(*params)["messages"] = items
+	(*params)["mailTemplates"] = mailtmpl.Names()
+	(*params)["langs"] = mailtmpl.Langs()
+	c.HTML(http.StatusOK, "outbox.html", params)
+}
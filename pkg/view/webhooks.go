@@ -0,0 +1,26 @@
+package view
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// webhooksPageSize is the number of the most recent deliveries shown in the webhooks page
+const webhooksPageSize = 100
+
+// Webhooks outputs the list of webhook deliveries with their status
+func Webhooks(c *gin.Context, params *gin.H) {
+	db := c.MustGet("db").(*dao.DbConn)
+	conf := c.MustGet("conf").(*config.Config)
+	items, err := db.WebhookDeliveries(webhooksPageSize)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	(*params)["deliveries"] = items
+	(*params)["hooks"] = conf.Webhooks
+	c.HTML(http.StatusOK, "webhooks.html", params)
+}
@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/vaefremov/pnglic/pkg/dao"
+)
+
+// Payloads of the events, they are sent in the data field of Event
+
+// LicenseFileIssued is the payload of license.file.issued
+type LicenseFileIssued struct {
+	ClientID int       `json:"clientId"`
+	KeyID    string    `json:"keyId"`
+	Issued   time.Time `json:"issued"`
+	MailedTo string    `json:"mailedTo,omitempty"`
+}
+
+// Operations reported by licenseset.changed
+const (
+	OperationUpdate  = "update"
+	OperationProlong = "prolong"
+	OperationCount   = "count"
+	OperationAdd     = "add"
+	OperationChange  = "change"
+	OperationRemove  = "remove"
+	OperationCopy    = "copy"
+	OperationReplace = "replace"
+)
+
+// LicenseSetChanged is the payload of licenseset.changed
+type LicenseSetChanged struct {
+	KeyID     string    `json:"keyId"`
+	Operation string    `json:"operation"`
+	Features  []Feature `json:"features"`
+}
+
+// Feature is a licensed feature as it is after the change
+type Feature struct {
+	Name    string  `json:"name"`
+	Version float32 `json:"version"`
+	Count   int     `json:"count"`
+	Start   string  `json:"start"`
+	End     string  `json:"end"`
+}
+
+// KeyCreated is the payload of key.created
+type KeyCreated struct {
+	KeyID    string `json:"keyId"`
+	ClientID int    `json:"clientId"`
+	Comments string `json:"comments"`
+}
+
+// KeyTransferred is the payload of key.transferred
+type KeyTransferred struct {
+	KeyID        string `json:"keyId"`
+	FromClientID int    `json:"fromClientId"`
+	ToClientID   int    `json:"toClientId"`
+}
+
+// KeyReplaced is the payload of key.replaced, the license set of the key has been moved
+// to the new key and the key is retired
+type KeyReplaced struct {
+	KeyID      string `json:"keyId"`
+	ReplacedBy string `json:"replacedBy"`
+	ClientID   int    `json:"clientId"`
+	Reason     string `json:"reason"`
+}
+
+// FeatureExpiry is the payload of feature.expiring and feature.expired
+type FeatureExpiry struct {
+	KeyID      string `json:"keyId"`
+	ClientID   int    `json:"clientId"`
+	ClientName string `json:"clientName"`
+	Feature    string `json:"feature"`
+	End        string `json:"end"`
+	// DaysLeft is the expiry threshold reached, for feature.expiring only
+	DaysLeft int `json:"daysLeft,omitempty"`
+}
+
+// FeaturesOf converts the license set to the list of features sent in payloads
+func FeaturesOf(licSet []dao.LicenseSetItem) []Feature {
+	res := []Feature{}
+	for _, f := range licSet {
+		res = append(res, Feature{Name: f.Feature, Version: f.Version, Count: f.Count,
+			Start: f.Start.Format("2006-01-02"), End: f.End.Format("2006-01-02")})
+	}
+	return res
+}
@@ -0,0 +1,203 @@
+// Package webhook posts license events to the URLs configured in config.Webhooks.
+// Events are stored in the webhookdeliveries table by Emit and are delivered by
+// the Dispatcher in the background, failed deliveries are retried with exponential
+// backoff. Every request is signed with HMAC-SHA256 of the body using the secret of
+// the hook, the signature is sent in the X-Pnglic-Signature header as sha256=<hex>.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/outbox"
+)
+
+// Events posted to webhooks
+const (
+	EventLicenseFileIssued = "license.file.issued"
+	EventLicenseSetChanged = "licenseset.changed"
+	EventKeyCreated        = "key.created"
+	EventKeyTransferred    = "key.transferred"
+	EventKeyReplaced       = "key.replaced"
+	EventFeatureExpiring   = "feature.expiring"
+	EventFeatureExpired    = "feature.expired"
+)
+
+// Headers of the requests posted to webhooks
+const (
+	HeaderEvent     = "X-Pnglic-Event"
+	HeaderDelivery  = "X-Pnglic-Delivery"
+	HeaderSignature = "X-Pnglic-Signature"
+)
+
+const (
+	defaultPollInterval = 30 * time.Second
+	defaultBaseDelay    = time.Minute
+	defaultMaxDelay     = 6 * time.Hour
+	defaultTimeout      = 30 * time.Second
+	batchSize           = 50
+)
+
+// Event is the body of the request posted to webhooks
+type Event struct {
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// Dispatcher stores events for delivery and delivers them
+type Dispatcher struct {
+	db           *dao.DbConn
+	hooks        []config.Webhook
+	Client       *http.Client
+	PollInterval time.Duration
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	MaxAttempts  int
+}
+
+// New constructs dispatcher of the webhooks set in config
+func New(db *dao.DbConn, conf *config.Config) *Dispatcher {
+	return &Dispatcher{
+		db:           db,
+		hooks:        conf.Webhooks,
+		Client:       &http.Client{Timeout: defaultTimeout},
+		PollInterval: defaultPollInterval,
+		BaseDelay:    defaultBaseDelay,
+		MaxDelay:     defaultMaxDelay,
+		MaxAttempts:  conf.WebhookMaxAttempts,
+	}
+}
+
+// Enabled checks if there are webhooks to post events to
+func (d *Dispatcher) Enabled() bool {
+	return len(d.hooks) > 0
+}
+
+// Emit stores the event for delivery to every webhook subscribed to it
+func (d *Dispatcher) Emit(event string, data interface{}) error {
+	payload, err := json.Marshal(Event{Event: event, Time: time.Now(), Data: data})
+	if err != nil {
+		return err
+	}
+	for _, h := range d.hooks {
+		if !subscribed(h, event) {
+			continue
+		}
+		if err = d.db.EnqueueWebhook(h.URL, event, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func subscribed(h config.Webhook, event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the signature of the body sent in the X-Pnglic-Signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// RunOnce makes a delivery attempt for every event due by now. It returns the
+// number of events delivered successfully.
+func (d *Dispatcher) RunOnce(now time.Time) (delivered int, err error) {
+	items, err := d.db.DueWebhookDeliveries(now, batchSize)
+	if err != nil {
+		return
+	}
+	for _, it := range items {
+		code, deliveryErr := d.deliver(it)
+		if deliveryErr != nil {
+			attempts := it.Attempts + 1
+			log.Printf("Webhook delivery %d to %s (attempt %d) failed: %s", it.Id, it.URL, attempts, deliveryErr)
+			if d.MaxAttempts > 0 && attempts >= d.MaxAttempts {
+				err = d.db.MarkWebhookFailed(it.Id, code, deliveryErr.Error())
+			} else {
+				err = d.db.MarkWebhookRetry(it.Id, code, deliveryErr.Error(), now.Add(outbox.Backoff(attempts, d.BaseDelay, d.MaxDelay)))
+			}
+		} else {
+			delivered++
+			err = d.db.MarkWebhookDelivered(it.Id, code, now)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// deliver posts the event, any response but 2xx is a failure
+func (d *Dispatcher) deliver(it dao.WebhookDelivery) (code int, err error) {
+	hook, ok := d.hook(it.URL)
+	if !ok {
+		return 0, fmt.Errorf("webhook %s is not configured any more", it.URL)
+	}
+	req, err := http.NewRequest(http.MethodPost, it.URL, bytes.NewReader(it.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, it.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(it.Id))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, it.Payload))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) hook(url string) (config.Webhook, bool) {
+	for _, h := range d.hooks {
+		if h.URL == url {
+			return h, true
+		}
+	}
+	return config.Webhook{}, false
+}
+
+// Run delivers the events every PollInterval until the context is canceled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.RunOnce(time.Now()); err != nil {
+			log.Println("Error when delivering webhooks: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
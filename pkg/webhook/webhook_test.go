@@ -0,0 +1,175 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vaefremov/pnglic/config"
+	"github.com/vaefremov/pnglic/pkg/dao"
+	"github.com/vaefremov/pnglic/pkg/webhook"
+)
+
+type received struct {
+	event     string
+	delivery  string
+	signature string
+	body      []byte
+}
+
+// receiver is an httptest server recording the posted events, it answers with
+// the given statuses first and with 204 afterwards
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []received
+	statuses []int
+}
+
+func newReceiver(statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, received{event: req.Header.Get(webhook.HeaderEvent), delivery: req.Header.Get(webhook.HeaderDelivery),
+			signature: req.Header.Get(webhook.HeaderSignature), body: body})
+		status := http.StatusNoContent
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return r
+}
+
+func (r *receiver) received() []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]received{}, r.requests...)
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(t, "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		webhook.Sign("key", []byte("The quick brown fox jumps over the lazy dog")))
+}
+
+func TestDispatcher(t *testing.T) {
+	ops := newReceiver()
+	defer ops.Close()
+	crm := newReceiver()
+	defer crm.Close()
+	db := dao.MustInMemoryTestPool()
+	conf := &config.Config{WebhookMaxAttempts: 3, Webhooks: []config.Webhook{
+		{URL: ops.URL, Secret: "ops secret"},
+		{URL: crm.URL, Secret: "crm secret", Events: []string{webhook.EventKeyCreated}},
+	}}
+	d := webhook.New(db, conf)
+	assert.True(t, d.Enabled())
+	assert.False(t, webhook.New(db, &config.Config{}).Enabled())
+
+	assert.Nil(t, d.Emit(webhook.EventKeyCreated, webhook.KeyCreated{KeyID: "123abc", ClientID: 1}))
+	assert.Nil(t, d.Emit(webhook.EventFeatureExpired, webhook.FeatureExpiry{KeyID: "123abc", ClientID: 1, Feature: "F1", End: "2020-01-01"}))
+	deliveries, err := db.WebhookDeliveries(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(deliveries))
+	assert.Equal(t, 0, len(ops.received()))
+
+	delivered, err := d.RunOnce(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, delivered)
+
+	got := ops.received()
+	assert.Equal(t, 2, len(got))
+	for _, r := range got {
+		assert.Equal(t, webhook.Sign("ops secret", r.body), r.signature)
+		ev := webhook.Event{}
+		assert.Nil(t, json.Unmarshal(r.body, &ev))
+		assert.Equal(t, r.event, ev.Event)
+	}
+	got = crm.received()
+	assert.Equal(t, 1, len(got))
+	assert.Equal(t, webhook.EventKeyCreated, got[0].event)
+	assert.Equal(t, webhook.Sign("crm secret", got[0].body), got[0].signature)
+	id, _ := strconv.Atoi(got[0].delivery)
+	it, err := db.WebhookDelivery(id)
+	assert.Nil(t, err)
+	assert.Equal(t, dao.OutboxSent, it.Status)
+	assert.Equal(t, http.StatusNoContent, it.ResponseCode)
+
+	// Nothing more to deliver
+	delivered, _ = d.RunOnce(time.Now())
+	assert.Equal(t, 0, delivered)
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	rcv := newReceiver(http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable)
+	defer rcv.Close()
+	db := dao.MustInMemoryTestPool()
+	d := webhook.New(db, &config.Config{WebhookMaxAttempts: 2, Webhooks: []config.Webhook{{URL: rcv.URL, Secret: "s"}}})
+	assert.Nil(t, d.Emit(webhook.EventKeyTransferred, webhook.KeyTransferred{KeyID: "123abc", FromClientID: 1, ToClientID: 2}))
+	deliveries, _ := db.WebhookDeliveries(1)
+	id := deliveries[0].Id
+
+	now := time.Now()
+	delivered, err := d.RunOnce(now)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+	it, _ := db.WebhookDelivery(id)
+	assert.Equal(t, dao.OutboxPending, it.Status)
+	assert.Equal(t, 1, it.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, it.ResponseCode)
+	assert.Equal(t, now.Add(d.BaseDelay).Format("2006-01-02 15:04:05"), it.NextAttempt.Format("2006-01-02 15:04:05"))
+
+	// Not due yet
+	delivered, _ = d.RunOnce(now.Add(d.BaseDelay / 2))
+	assert.Equal(t, 0, delivered)
+	assert.Equal(t, 1, len(rcv.received()))
+
+	// The second failure is the last one
+	delivered, _ = d.RunOnce(now.Add(d.BaseDelay))
+	assert.Equal(t, 0, delivered)
+	it, _ = db.WebhookDelivery(id)
+	assert.Equal(t, dao.OutboxFailed, it.Status)
+	assert.Equal(t, 2, it.Attempts)
+	assert.Equal(t, http.StatusBadGateway, it.ResponseCode)
+	assert.Contains(t, it.LastError, "502")
+	delivered, _ = d.RunOnce(now.Add(time.Hour))
+	assert.Equal(t, 0, delivered)
+
+	// Redelivered by hand: fails once more, then succeeds
+	assert.Nil(t, db.RedeliverWebhook(id, now))
+	delivered, _ = d.RunOnce(now)
+	assert.Equal(t, 0, delivered)
+	delivered, _ = d.RunOnce(now.Add(d.BaseDelay))
+	assert.Equal(t, 1, delivered)
+	it, _ = db.WebhookDelivery(id)
+	assert.Equal(t, dao.OutboxSent, it.Status)
+	assert.Equal(t, "", it.LastError)
+	assert.Equal(t, 4, len(rcv.received()))
+	for _, r := range rcv.received() {
+		assert.Equal(t, strconv.Itoa(id), r.delivery)
+	}
+}
+
+func TestDispatcherUnconfiguredHook(t *testing.T) {
+	rcv := newReceiver()
+	defer rcv.Close()
+	db := dao.MustInMemoryTestPool()
+	d := webhook.New(db, &config.Config{WebhookMaxAttempts: 1, Webhooks: []config.Webhook{{URL: rcv.URL}}})
+	assert.Nil(t, d.Emit(webhook.EventKeyCreated, webhook.KeyCreated{KeyID: "123abc", ClientID: 1}))
+	// The hook has been removed from config since the event was emitted
+	d = webhook.New(db, &config.Config{WebhookMaxAttempts: 1})
+	delivered, err := d.RunOnce(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+	deliveries, _ := db.WebhookDeliveries(1)
+	assert.Equal(t, dao.OutboxFailed, deliveries[0].Status)
+	assert.Equal(t, 0, len(rcv.received()))
+}